@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of Event.
+const (
+	eventDown      = "DOWN"
+	eventRecovered = "RECOVERED"
+	eventDegrading = "DEGRADING"
+)
+
+// Event is something about a target worth telling a human.
+type Event struct {
+	kind   string
+	state  State // the poll that raised the event
+	time   time.Time
+	detail string // what happened, beyond the status
+
+	// Set by the Correlator for several targets going down together.
+	group   string  // what they have in common, e.g. "host db.internal"
+	related []State // every target of the group, state's included
+}
+
+// key identifies what e is about, for sinks that group alerts: the target,
+// or the group of a correlated event.
+func (e Event) key() string {
+	if e.group != "" {
+		return e.group
+	}
+	return e.state.url
+}
+
+func (e Event) title() string {
+	if len(e.related) > 0 {
+		if e.kind == eventRecovered {
+			return fmt.Sprintf("%d targets recovered: %s", len(e.related), e.group)
+		}
+		return fmt.Sprintf("%d targets are down: %s", len(e.related), e.group)
+	}
+	switch e.kind {
+	case eventDown:
+		return e.state.url + " is down"
+	case eventRecovered:
+		return e.state.url + " recovered"
+	}
+	return e.state.url + " is degrading"
+}
+
+// text is the body of an alert about e: what happened, then the target's
+// notes and runbook link when it has them.
+func (e Event) text() string {
+	if len(e.related) > 0 {
+		return correlatedText(e)
+	}
+	lines := []string{e.state.result.String()}
+	if e.kind == eventDown && e.state.result.Class != "" {
+		lines = append(lines, "Failure: "+string(e.state.result.Class))
+	}
+	if e.detail != "" {
+		lines = append(lines, e.detail)
+	}
+	if e.kind == eventDown && e.state.response != nil {
+		lines = append(lines, e.state.response.String())
+	}
+	if e.state.target.notes != "" {
+		lines = append(lines, "Notes: "+e.state.target.notes)
+	}
+	if e.state.target.runbook != "" {
+		lines = append(lines, "Runbook: "+e.state.target.runbook)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// latencyWindow accumulates the latencies of one target's successful polls.
+type latencyWindow struct {
+	n         int
+	sum       time.Duration
+	prevMean  time.Duration // mean of the last complete window, 0 before the first
+	degrading bool          // a DEGRADING event has been raised and not yet cleared
+}
+
+// minDegradeLatency keeps jitter on very fast targets, 2ms to 7ms say, from
+// counting as degradation.
+const minDegradeLatency = 50 * time.Millisecond
+
+/*
+Alerter turns the stream of states into Events for the alerting sinks and logs each one.
+A target raises DOWN when a poll finds it down after being up (or on its first poll) and
+RECOVERED when it comes back. Paused targets raise nothing, and resuming one is not a recovery.
+DEGRADING is raised when the mean latency of a window of successful polls is at least factor
+times that of the window before, since slowdowns usually precede outages; it is raised once and
+re-armed when a window no longer shows the jump. A target's windows start over when it is paused,
+and are dropped when live from WatchTargets shows it retired.
+When a target goes DOWN because it could not be reached at all, a traceroute to its host (see
+traceHops) is attached to the event, unless traceTimeout is 0. The trace runs on its own
+goroutine; the target's later events are held back until it is done so they stay in order.
+It returns the channel on which it wants to hear about state changes; pass it to StateMonitor
+as a listener. Events are sent to every one of the listeners in turn.
+*/
+func Alerter(factor float64, window int, traceTimeout time.Duration, live <-chan map[string]bool, listeners ...chan<- Event) chan<- State {
+	states := make(chan State)
+	traced := make(chan Event)
+	windows := make(map[string]*latencyWindow)
+	tracing := make(map[string][]Event) // urls with a trace in flight, and the events waiting for it
+	emit := func(e Event) {
+		logger.Printf("Event %s %s: %s", e.kind, e.state.url, strings.ReplaceAll(e.text(), "\n", "; "))
+		for _, l := range listeners {
+			l <- e
+		}
+	}
+	go func() {
+		for {
+			var s State
+			select {
+			case e := <-traced:
+				emit(e)
+				for _, e := range tracing[e.state.url] {
+					emit(e)
+				}
+				delete(tracing, e.state.url)
+				continue
+			case targets := <-live:
+				for u := range windows {
+					if _, ok := targets[u]; !ok {
+						delete(windows, u)
+					}
+				}
+				continue
+			case s = <-states:
+			}
+			if s.result.Paused() {
+				delete(windows, s.url)
+				continue
+			}
+			prev := s.prev
+			if prev.Paused() {
+				prev = Result{}
+			}
+			up := s.result.Up
+			first := prev.String() == ""
+			var events []Event
+			switch {
+			case !up && (first || prev.Up):
+				events = append(events, Event{kind: eventDown, state: s})
+			case up && !first && !prev.Up:
+				events = append(events, Event{kind: eventRecovered, state: s})
+			}
+
+			if up {
+				w, ok := windows[s.url]
+				if !ok {
+					w = &latencyWindow{}
+					windows[s.url] = w
+				}
+				w.n++
+				w.sum += s.result.Latency
+				if w.n == window {
+					mean := w.sum / time.Duration(w.n)
+					jump := w.prevMean > 0 && mean >= minDegradeLatency &&
+						float64(mean) >= factor*float64(w.prevMean)
+					if jump && !w.degrading {
+						events = append(events, Event{kind: eventDegrading, state: s,
+							detail: "mean latency rose from " + w.prevMean.Round(time.Millisecond).String() +
+								" to " + mean.Round(time.Millisecond).String()})
+					}
+					w.degrading = jump
+					w.prevMean, w.n, w.sum = mean, 0, 0
+				}
+			}
+
+			for _, e := range events {
+				e.time = time.Now()
+				if queued, ok := tracing[s.url]; ok {
+					tracing[s.url] = append(queued, e)
+					continue
+				}
+				if e.kind == eventDown && traceTimeout > 0 && networkError(s.result.String()) {
+					tracing[s.url] = nil
+					go func(e Event) {
+						e.detail = traceHops(e.state.url, traceTimeout)
+						traced <- e
+					}(e)
+					continue
+				}
+				emit(e)
+			}
+		}
+	}()
+	return states
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultAPI is where the CLI verbs expect a poller started with -listen :9100.
+const defaultAPI = "http://127.0.0.1:9100"
+
+// targetsAPI serves the target-management endpoints:
+//
+//	GET  /targets/                   every target with its labels, notes and runbook
+//	POST /targets/pause?target=URL   stop polling URL and report it PAUSED
+//	POST /targets/resume?target=URL  poll URL again from its next turn
+//	POST /targets/poll?target=URL    poll URL right now and return the result
+//
+// The result of a poll over TLS includes the certificate chain the target
+// presented, with whatever makes any of its certificates weak.
+//
+// An out-of-schedule poll is made on a copy of the target, since its Resource
+// may be owned by a Poller or a Sleep at the time; it leaves the error count
+// alone. Its result is reported to the StateMonitor on status, except for
+// paused targets, which stay PAUSED.
+//
+// Every action is recorded in audit.
+func targetsAPI(controls chan<- schedulerControl, status chan<- State, audit *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verb := strings.TrimPrefix(r.URL.Path, "/targets/")
+		if verb == "" && r.Method == http.MethodGet {
+			reply := make(chan controlReply)
+			controls <- schedulerControl{op: "list", reply: reply}
+			rep := <-reply
+			out := make([]targetJSON, len(rep.targets))
+			for i, lt := range rep.targets {
+				out[i] = targetJSON{URL: lt.target.url, Labels: lt.target.labels, Notes: lt.target.notes, Runbook: lt.target.runbook, Paused: lt.paused}
+			}
+			writeJSON(w, map[string]interface{}{"targets": out, "quiesce": quiesceStatus(rep.quiescedSince)})
+			return
+		}
+		if verb != "pause" && verb != "resume" && verb != "poll" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		target := r.FormValue("target")
+		if target == "" {
+			http.Error(w, "target: missing", http.StatusBadRequest)
+			return
+		}
+		op := verb
+		if verb == "poll" {
+			op = "lookup"
+		}
+		reply := make(chan controlReply)
+		controls <- schedulerControl{op: op, url: target, reply: reply}
+		rep := <-reply
+		if rep.err != nil {
+			http.Error(w, rep.err.Error(), http.StatusNotFound)
+			return
+		}
+		if verb != "poll" {
+			after := verb == "pause"
+			before := after
+			if rep.changed {
+				before = !after
+			}
+			audit.Record(r, verb, target, map[string]bool{"paused": before}, map[string]bool{"paused": after})
+			writeJSON(w, map[string]interface{}{"target": target, "paused": after})
+			return
+		}
+		s := (&Resource{url: target, target: rep.target}).PollState()
+		if !rep.held {
+			status <- s
+		}
+		audit.Record(r, verb, target, nil, map[string]string{"status": s.result.String()})
+		writeJSON(w, s.displayed())
+	})
+}
+
+// targetJSON is one entry of GET /targets/.
+type targetJSON struct {
+	URL     string            `json:"url"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Notes   string            `json:"notes,omitempty"`
+	Runbook string            `json:"runbook,omitempty"`
+	Paused  bool              `json:"paused"`
+}
+
+// controlCommand implements the "pause <url>", "resume <url>" and "poll <url>"
+// subcommands, which ask a running poller to pause, resume or immediately poll
+// one target through its API. It returns the process exit code; for poll, 1
+// when the target is down.
+func controlCommand(verb string, args []string) int {
+	fs := flag.NewFlagSet(verb, flag.ContinueOnError)
+	api := fs.String("api", defaultAPI, "`URL` of the running poller's API")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s [-api URL] <target>\n", os.Args[0], verb)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	if verb == "poll" {
+		var res State
+		if err := apiPost(*api, "/targets/poll", url.Values{"target": {fs.Arg(0)}}, &res); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%s %s (%dms)\n", res.url, res.result, res.result.Latency.Milliseconds())
+		for _, c := range res.chain {
+			fmt.Printf("  %s, issued by %s, expires %s, %s, %s\n", c.Subject, c.Issuer,
+				c.NotAfter.Format("2006-01-02"), c.Key, c.SignatureAlgorithm)
+			for _, w := range c.Weak {
+				fmt.Printf("    weak: %s\n", w)
+			}
+		}
+		if res.response != nil {
+			fmt.Println(res.response)
+		}
+		if !res.result.Up {
+			return 1
+		}
+		return 0
+	}
+	if err := apiPost(*api, "/targets/"+verb, url.Values{"target": {fs.Arg(0)}}, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%sd %s\n", verb, fs.Arg(0))
+	return 0
+}
+
+// apiPost posts form to path on the poller API at base and decodes the JSON
+// answer into out, unless out is nil.
+func apiPost(base, path string, form url.Values, out interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(base, "/")+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token := os.Getenv(apiTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: errTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiGet copies the answer to a GET of path on the poller API at base to w.
+// It returns the process exit code for the CLI verbs, reporting failures on
+// stderr.
+func apiGet(base, path string, w io.Writer) int {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(base, "/")+path, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if token := os.Getenv(apiTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		fmt.Fprintf(os.Stderr, "%s: %s\n", resp.Status, strings.TrimSpace(string(msg)))
+		return 1
+	}
+	io.Copy(w, resp.Body)
+	return 0
+}
+
+// quiesceAPI serves the global switch:
+//
+//	GET  /quiesce  whether all polling is paused, and since when
+//	POST /quiesce  pause all polling
+//	DELETE /quiesce  resume it
+//
+// Changes are recorded in audit.
+func quiesceAPI(controls chan<- schedulerControl, audit *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := "status"
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			op = "quiesce"
+		case http.MethodDelete:
+			op = "unquiesce"
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reply := make(chan controlReply)
+		controls <- schedulerControl{op: op, reply: reply}
+		rep := <-reply
+		if op != "status" {
+			audit.Record(r, op, "", map[string]bool{"quiesced": (op == "quiesce") != rep.changed}, map[string]bool{"quiesced": op == "quiesce"})
+		}
+		writeJSON(w, quiesceStatus(rep.quiescedSince))
+	})
+}
+
+func quiesceStatus(since time.Time) map[string]interface{} {
+	if since.IsZero() {
+		return map[string]interface{}{"quiesced": false}
+	}
+	return map[string]interface{}{"quiesced": true, "since": displayed(since)}
+}
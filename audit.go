@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// auditEntry is one administrative action.
+type auditEntry struct {
+	Time      time.Time   `json:"time"`
+	Principal string      `json:"principal"`
+	Remote    string      `json:"remote,omitempty"`
+	Action    string      `json:"action"`
+	Target    string      `json:"target,omitempty"`
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+}
+
+/*
+AuditLog records who changed what and when.
+Every entry is logged and, when the log was opened with a file, appended to it as a line of JSON
+and synced before the action is acknowledged, so an acknowledged action is always on record.
+The file is owned by one goroutine; GET /audit asks it for the matching entries.
+A nil *AuditLog records nothing, for callers that have none.
+*/
+type AuditLog struct {
+	entries chan auditWrite
+	queries chan auditQuery
+}
+
+type auditWrite struct {
+	entry auditEntry
+	done  chan struct{}
+}
+
+type auditQuery struct {
+	match func(auditEntry) bool
+	reply chan []auditEntry
+}
+
+// OpenAuditLog opens the audit log at path, creating it if needed. An empty
+// path gives an audit log that only logs.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600); err != nil {
+			return nil, err
+		}
+	}
+	a := &AuditLog{entries: make(chan auditWrite), queries: make(chan auditQuery)}
+	go func() {
+		for {
+			select {
+			case w := <-a.entries:
+				b, _ := json.Marshal(w.entry)
+				logger.Printf("Audit %s", b)
+				if f != nil {
+					if _, err := f.Write(append(b, '\n')); err == nil {
+						err = f.Sync()
+					} else {
+						logger.Println("Error", "audit log", err)
+					}
+				}
+				close(w.done)
+			case q := <-a.queries:
+				q.reply <- readAudit(f, q.match)
+			}
+		}
+	}()
+	return a, nil
+}
+
+func readAudit(f *os.File, match func(auditEntry) bool) []auditEntry {
+	out := []auditEntry{}
+	if f == nil {
+		return out
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return out
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e auditEntry
+		if json.Unmarshal(sc.Bytes(), &e) == nil && match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// add records e, stamping it with the current time.
+func (a *AuditLog) add(e auditEntry) {
+	if a == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	done := make(chan struct{})
+	a.entries <- auditWrite{entry: e, done: done}
+	<-done
+}
+
+// Record records an action taken through the API request r.
+func (a *AuditLog) Record(r *http.Request, action, target string, before, after interface{}) {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	a.add(auditEntry{Principal: principal(r), Remote: host, Action: action, Target: target, Before: before, After: after})
+}
+
+// ServeHTTP answers GET /audit?from=&to=&principal=&action=&target=, with
+// from and to defaulting to the last day as for /history.
+func (a *AuditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	v := r.URL.Query()
+	to := time.Now().UTC()
+	var err error
+	if s := v.Get("to"); s != "" {
+		if to, err = parseTime(s); err != nil {
+			http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.Add(-historyDefaultRange)
+	if s := v.Get("from"); s != "" {
+		if from, err = parseTime(s); err != nil {
+			http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	match := func(e auditEntry) bool {
+		return !e.Time.Before(from) && !e.Time.After(to) &&
+			(v.Get("principal") == "" || e.Principal == v.Get("principal")) &&
+			(v.Get("action") == "" || e.Action == v.Get("action")) &&
+			(v.Get("target") == "" || e.Target == v.Get("target"))
+	}
+	reply := make(chan []auditEntry)
+	a.queries <- auditQuery{match: match, reply: reply}
+	entries := <-reply
+	for i := range entries {
+		entries[i].Time = displayed(entries[i].Time)
+	}
+	writeJSON(w, map[string]interface{}{"entries": entries})
+}
+
+// apiTokenEnv holds the token the CLI verbs present to the API.
+const apiTokenEnv = "POLLER_TOKEN"
+
+// apiTokens maps admin API bearer tokens to the principal they identify.
+type apiTokens map[string]string
+
+// loadTokens reads "principal token" lines from path; # starts a comment.
+// An empty path means the admin API is open and every caller is anonymous.
+func loadTokens(path string) (apiTokens, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tokens := make(apiTokens)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"principal token\"", path, n)
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New(path + ": no tokens")
+	}
+	return tokens, nil
+}
+
+// lookup returns the principal for a bearer token, comparing in constant time.
+func (t apiTokens) lookup(token string) (string, bool) {
+	found, who := false, ""
+	for tok, p := range t {
+		if subtle.ConstantTimeCompare([]byte(tok), []byte(token)) == 1 {
+			found, who = true, p
+		}
+	}
+	return who, found
+}
+
+type principalKey struct{}
+
+// adminOnly lets a request through to h only when it presents one of tokens,
+// and makes the token's principal available to h. With no tokens every
+// request gets through as "anonymous".
+func adminOnly(tokens apiTokens, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who := "anonymous"
+		if tokens != nil {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			var ok bool
+			if who, ok = tokens.lookup(token); !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, who)))
+	})
+}
+
+// principal returns who made r, as established by adminOnly.
+func principal(r *http.Request) string {
+	if who, ok := r.Context().Value(principalKey{}).(string); ok {
+		return who
+	}
+	return "anonymous"
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// awsClient calls AWS query-protocol APIs (form-encoded POSTs) signed with
+// Signature Version 4. Credentials come from the standard environment
+// variables, which is also how ECS and most sidecars hand them out.
+type awsClient struct {
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newAWSClient(region string) (*awsClient, error) {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	c := &awsClient{
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: errTimeout},
+	}
+	if c.region == "" {
+		return nil, errors.New("aws: no region: set AWS_REGION")
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, errors.New("aws: no credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return c, nil
+}
+
+// call posts form to the regional endpoint of service and returns an error
+// for any non-2xx answer.
+func (c *awsClient) call(service string, form url.Values) error {
+	body := form.Encode()
+	host := service + "." + c.region + ".amazonaws.com"
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+"/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	c.sign(req, service, host, body, time.Now().UTC())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("aws: %s %s: %s", service, form.Get("Action"), strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 headers to req.
+func (c *awsClient) sign(req *http.Request, service, host, body string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	values := map[string]string{
+		"content-type": req.Header.Get("Content-Type"),
+		"host":         host,
+		"x-amz-date":   amzDate,
+	}
+	if c.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+		values["x-amz-security-token"] = c.sessionToken
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		canonicalHeaders.WriteString(h + ":" + values[h] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, "/", "", canonicalHeaders.String(), signedHeaders, sha256Hex(body),
+	}, "\n")
+	scope := date + "/" + c.region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// cloudWatchDatum is one value for PutMetricData.
+type cloudWatchDatum struct {
+	name       string
+	dimensions map[string]string
+	value      float64
+	unit       string // e.g. "None", "Milliseconds"
+	timestamp  time.Time
+}
+
+// cloudWatchBatch is the most data PutMetricData is sent in one call.
+const cloudWatchBatch = 20
+
+// putMetricData publishes data to CloudWatch under namespace, in as many calls
+// as it takes.
+func (c *awsClient) putMetricData(namespace string, data []cloudWatchDatum) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > cloudWatchBatch {
+			n = cloudWatchBatch
+		}
+		form := url.Values{
+			"Action":    {"PutMetricData"},
+			"Version":   {"2010-08-01"},
+			"Namespace": {namespace},
+		}
+		for i, d := range data[:n] {
+			p := fmt.Sprintf("MetricData.member.%d.", i+1)
+			form.Set(p+"MetricName", d.name)
+			form.Set(p+"Value", fmt.Sprint(d.value))
+			form.Set(p+"Unit", d.unit)
+			form.Set(p+"Timestamp", d.timestamp.UTC().Format(time.RFC3339))
+			j := 1
+			for k, v := range d.dimensions {
+				form.Set(fmt.Sprintf("%sDimensions.member.%d.Name", p, j), k)
+				form.Set(fmt.Sprintf("%sDimensions.member.%d.Value", p, j), v)
+				j++
+			}
+		}
+		if err := c.call("monitoring", form); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil"
+)
+
+// The benchmarks below weigh the channel topologies this program could be
+// built from; the README records what they found and the defaults that follow
+// from it. Run them with go test -bench . -benchmem -run '^$'.
+
+// BenchmarkChannelBuffer passes values from one goroutine to another through
+// channels of various buffer sizes, as between the stages of the pipeline.
+func BenchmarkChannelBuffer(b *testing.B) {
+	for _, size := range []int{0, 1, 16, 256} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			c := make(chan *Resource, size)
+			done := make(chan struct{})
+			go func() {
+				for range c {
+				}
+				close(done)
+			}()
+			r := &Resource{}
+			for i := 0; i < b.N; i++ {
+				c <- r
+			}
+			close(c)
+			<-done
+		})
+	}
+}
+
+// wakeAfter is how long the sleeping Resources of the scheduling benchmarks
+// sleep: short, so the benchmarks measure the bookkeeping rather than the
+// wait.
+const wakeAfter = 10 * time.Millisecond
+
+// BenchmarkSleepGoroutines has every Resource sleep on a goroutine of its own
+// and send itself back when it wakes, as the Scheduler does.
+func BenchmarkSleepGoroutines(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("targets=%d", n), func(b *testing.B) {
+			rs := make([]*Resource, n)
+			for i := range rs {
+				rs[i] = &Resource{}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				wake := make(chan *Resource)
+				for _, r := range rs {
+					go func(r *Resource) {
+						time.Sleep(wakeAfter)
+						wake <- r
+					}(r)
+				}
+				for range rs {
+					<-wake
+				}
+			}
+		})
+	}
+}
+
+// timerWheel is the alternative to a goroutine per sleep: one goroutine
+// keeping sleepers in slots of tick width, waking a slot's worth at a time.
+type timerWheel struct {
+	add chan wheelEntry
+}
+
+type wheelEntry struct {
+	r     *Resource
+	after time.Duration
+}
+
+func newTimerWheel(tick time.Duration, slots int, wake chan<- *Resource) *timerWheel {
+	w := &timerWheel{add: make(chan wheelEntry)}
+	go func() {
+		wheel := make([][]*Resource, slots)
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		var pos int
+		var due []*Resource
+		for {
+			var out chan<- *Resource
+			var next *Resource
+			if len(due) > 0 {
+				out, next = wake, due[0]
+			}
+			select {
+			case e, ok := <-w.add:
+				if !ok {
+					return
+				}
+				ticks := int(e.after / tick)
+				if ticks < 1 {
+					ticks = 1
+				}
+				slot := (pos + ticks) % slots
+				wheel[slot] = append(wheel[slot], e.r)
+			case <-ticker.C:
+				pos = (pos + 1) % slots
+				due = append(due, wheel[pos]...)
+				wheel[pos] = wheel[pos][:0]
+			case out <- next:
+				due = due[1:]
+			}
+		}
+	}()
+	return w
+}
+
+// BenchmarkSleepTimerWheel is BenchmarkSleepGoroutines with the sleepers
+// kept on a timerWheel.
+func BenchmarkSleepTimerWheel(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("targets=%d", n), func(b *testing.B) {
+			rs := make([]*Resource, n)
+			for i := range rs {
+				rs[i] = &Resource{}
+			}
+			wake := make(chan *Resource)
+			w := newTimerWheel(time.Millisecond, 64, wake)
+			defer close(w.add)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, r := range rs {
+					w.add <- wheelEntry{r, wakeAfter}
+				}
+				for range rs {
+					<-wake
+				}
+			}
+		})
+	}
+}
+
+// pollLatency stands in for the network round trip of a poll.
+const pollLatency = 100 * time.Microsecond
+
+// BenchmarkPollers sends every target once through a Pool of Pollers whose
+// polls take pollLatency, for various numbers of targets and Pollers.
+// Combinations of over 2000 polls a Poller are skipped as too slow.
+func BenchmarkPollers(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		for _, workers := range []int{numPollers, 16, 128, 1024} {
+			b.Run(fmt.Sprintf("targets=%d/pollers=%d", n, workers), func(b *testing.B) {
+				if n/workers > 2000 {
+					b.Skip("too many polls a Poller")
+				}
+				poll := func(_ context.Context, r *Resource) (*Resource, error) {
+					time.Sleep(pollLatency)
+					return r, nil
+				}
+				rs := make([]*Resource, n)
+				for i := range rs {
+					rs[i] = &Resource{}
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					ctx, cancel := context.WithCancel(context.Background())
+					p := chanutil.NewPool(ctx, chanutil.FromSlice(ctx, rs), workers, poll)
+					for range p.Out() {
+					}
+					p.Wait()
+					cancel()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkStateMonitor sends results for 10k targets to a StateMonitor of
+// various numbers of shards from 16 Pollers, one by one or in batches of 64,
+// with two listeners that each keep a map of what they hear, as the sinks do.
+func BenchmarkStateMonitor(b *testing.B) {
+	for _, c := range []struct{ shards, batch int }{{1, 0}, {2, 0}, {4, 0}, {8, 0}, {1, 64}, {4, 64}} {
+		shards := c.shards
+		b.Run(fmt.Sprintf("shards=%d/batch=%d", shards, c.batch), func(b *testing.B) {
+			const targets, senders = 10000, 16
+			var listeners []chan<- State
+			for i := 0; i < 2; i++ {
+				l := make(chan State)
+				go func() {
+					seen := make(map[string]Result)
+					for s := range l {
+						seen[s.url] = s.result
+					}
+				}()
+				listeners = append(listeners, l)
+			}
+			m := StateMonitor(time.Hour, shards, nil, listeners...)
+			defer func() {
+				m.Stop()
+				for _, l := range listeners {
+					close(l)
+				}
+			}()
+			send := func(s State) { m.Updates <- s }
+			if c.batch > 0 {
+				send = newStatusBatcher(m.Batches, c.batch).add
+			}
+			urls := make([]string, targets)
+			for i := range urls {
+				urls[i] = fmt.Sprintf("https://target-%d.example.com/", i)
+			}
+			b.ResetTimer()
+			done := make(chan struct{})
+			for w := 0; w < senders; w++ {
+				go func() {
+					for i := w; i < b.N; i += senders {
+						send(State{url: urls[i%targets], result: Result{Up: i%7 != 0}})
+					}
+					done <- struct{}{}
+				}()
+			}
+			for w := 0; w < senders; w++ {
+				<-done
+			}
+		})
+	}
+}
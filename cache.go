@@ -0,0 +1,61 @@
+package main
+
+import "time"
+
+/*
+expiringCache keeps values that are slow or costly to fetch, a CRL or a domain's registration,
+until they expire. Its goroutine owns the map: get asks it for a value, which the asker fetches
+itself when there is none, so that a slow fetch never holds up the others, and put hands it back.
+*/
+type expiringCache[V any] struct {
+	get chan cacheLookup[V]
+	put chan cacheEntry[V]
+}
+
+type cacheLookup[V any] struct {
+	key   string
+	reply chan cacheEntry[V]
+}
+
+type cacheEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+func newExpiringCache[V any]() *expiringCache[V] {
+	c := &expiringCache[V]{get: make(chan cacheLookup[V]), put: make(chan cacheEntry[V])}
+	go func() {
+		cached := make(map[string]cacheEntry[V])
+		for {
+			select {
+			case l := <-c.get:
+				e, ok := cached[l.key]
+				if ok && time.Now().After(e.expires) {
+					delete(cached, l.key)
+					e = cacheEntry[V]{}
+				}
+				l.reply <- e
+			case e := <-c.put:
+				cached[e.key] = e
+			}
+		}
+	}()
+	return c
+}
+
+// fetch returns the value for key from the cache or else from fetch, which
+// also says until when the value may be kept. Errors are not cached.
+func (c *expiringCache[V]) fetch(key string, fetch func() (V, time.Time, error)) (V, error) {
+	reply := make(chan cacheEntry[V])
+	c.get <- cacheLookup[V]{key, reply}
+	if e := <-reply; !e.expires.IsZero() {
+		return e.value, nil
+	}
+	v, expires, err := fetch()
+	if err != nil {
+		return v, err
+	}
+	c.put <- cacheEntry[V]{key, v, expires}
+	return v, nil
+}
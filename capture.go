@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// capturedResponse is what a failing target actually returned, kept so that
+// alerts can show it.
+type capturedResponse struct {
+	Status    string      `json:"status"`
+	Header    http.Header `json:"header"`
+	Body      string      `json:"body"`
+	Truncated bool        `json:"truncated"` // the body was longer than what was kept
+}
+
+// captureResponse fetches url with a GET through client, since a HEAD has no
+// body, and keeps the status, the headers and the first max bytes of the body.
+// Secret headers, such as the Set-Cookie of a session, are kept masked, since
+// what is captured goes out with alerts. It returns nil if the request fails.
+func captureResponse(client *http.Client, url string, max int) *capturedResponse {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, int64(max)+1))
+	header := make(http.Header, len(resp.Header))
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			header.Add(k, redactHeader(k, v))
+		}
+	}
+	c := &capturedResponse{Status: resp.Status, Header: header, Body: string(body)}
+	if len(body) > max {
+		c.Body, c.Truncated = c.Body[:max], true
+	}
+	return c
+}
+
+// String formats c for an alert: status line, headers in order, then the body.
+func (c *capturedResponse) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Response: %s\n", c.Status)
+	keys := make([]string, 0, len(c.Header))
+	for k := range c.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range c.Header[k] {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+	b.WriteString("\n" + c.Body)
+	if c.Truncated {
+		b.WriteString("\n(truncated)")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// certInfo describes one certificate of the chain a TLS target presented.
+type certInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	NotAfter           time.Time `json:"not_after"`
+	Key                string    `json:"key"` // algorithm and size, as "RSA 2048" or "ECDSA P-256"
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	Weak               []string  `json:"weak,omitempty"` // why the certificate should be replaced, if it should
+}
+
+// certChain describes the certificates a server presented, leaf first, as
+// they were sent. It is nil for a target not polled over TLS.
+func certChain(certs []*x509.Certificate) []certInfo {
+	if len(certs) == 0 {
+		return nil
+	}
+	chain := make([]certInfo, len(certs))
+	for i, c := range certs {
+		chain[i] = certInfo{
+			Subject:            c.Subject.String(),
+			Issuer:             c.Issuer.String(),
+			NotAfter:           c.NotAfter,
+			Key:                keyDescription(c),
+			SignatureAlgorithm: c.SignatureAlgorithm.String(),
+			Weak:               weaknesses(c),
+		}
+	}
+	return chain
+}
+
+// keyDescription names the algorithm and size of the public key of c.
+func keyDescription(c *x509.Certificate) string {
+	switch k := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return c.PublicKeyAlgorithm.String()
+}
+
+/*
+weaknesses lists what is wrong with c even if it is still valid: an RSA key shorter than 2048 bits,
+a signature made with MD5 or SHA-1, or having expired. The signature of a self-signed root is not
+held against it, since clients trust the root itself rather than its signature.
+*/
+func weaknesses(c *x509.Certificate) []string {
+	var weak []string
+	if k, ok := c.PublicKey.(*rsa.PublicKey); ok && k.N.BitLen() < 2048 {
+		weak = append(weak, fmt.Sprintf("%d-bit RSA key", k.N.BitLen()))
+	}
+	selfSigned := c.Subject.String() == c.Issuer.String()
+	switch c.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		if !selfSigned {
+			weak = append(weak, c.SignatureAlgorithm.String()+" signature")
+		}
+	}
+	if time.Now().After(c.NotAfter) {
+		weak = append(weak, "expired")
+	}
+	return weak
+}
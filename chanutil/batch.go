@@ -0,0 +1,43 @@
+package chanutil
+
+import "time"
+
+/*
+Batch collects the values of in into slices of up to maxSize, sending each batch as soon as it is
+full or maxWait after its first value arrived, whichever comes first, so no value waits longer
+than maxWait. When in is closed the partial batch, if any, is sent and the output closed.
+A maxSize of 0 or less means no size limit.
+*/
+func Batch[T any](in <-chan T, maxSize int, maxWait time.Duration) <-chan []T {
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		var batch []T
+		var timeout <-chan time.Time
+		flush := func() {
+			if len(batch) > 0 {
+				out <- batch
+			}
+			batch, timeout = nil, nil
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if len(batch) == 0 {
+					timeout = time.After(maxWait)
+				}
+				batch = append(batch, v)
+				if maxSize > 0 && len(batch) >= maxSize {
+					flush()
+				}
+			case <-timeout:
+				flush()
+			}
+		}
+	}()
+	return out
+}
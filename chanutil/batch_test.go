@@ -0,0 +1,28 @@
+package chanutil
+
+import (
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestBatchSize(t *testing.T) {
+	leakcheck.Verify(t)
+	got := collect(Batch(count(5), 2, time.Hour))
+	if len(got) != 3 || len(got[0]) != 2 || len(got[2]) != 1 {
+		t.Errorf("Batch = %v", got)
+	}
+}
+
+func TestBatchWait(t *testing.T) {
+	leakcheck.Verify(t)
+	in := make(chan int)
+	out := Batch(in, 10, 10*time.Millisecond)
+	in <- 1
+	if b := <-out; len(b) != 1 {
+		t.Errorf("first batch = %v", b)
+	}
+	close(in)
+	collect(out)
+}
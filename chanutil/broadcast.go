@@ -0,0 +1,53 @@
+package chanutil
+
+import "context"
+
+// Policy says what a stage does with a value a slow consumer is not ready for.
+type Policy int
+
+const (
+	// Block waits for the consumer, holding up every other one meanwhile.
+	Block Policy = iota
+	// Drop discards the value for that consumer only.
+	Drop
+)
+
+/*
+Broadcast fans out: it returns n channels, each of which receives every value sent on in, and
+closes them all when in is closed or ctx is cancelled.
+Each subscriber channel has room for buffer values. When a subscriber's buffer is full, policy
+decides whether Broadcast waits for it (Block), which paces every subscriber to the slowest, or
+skips the value for that subscriber (Drop), so that one stalled consumer cannot hold up the rest.
+*/
+func Broadcast[T any](ctx context.Context, in <-chan T, n, buffer int, policy Policy) []<-chan T {
+	outs := make([]chan T, n)
+	ro := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T, buffer)
+		ro[i] = outs[i]
+	}
+	go func() {
+		defer func() {
+			for _, c := range outs {
+				close(c)
+			}
+		}()
+		for v := range OrDone(ctx, in) {
+			for _, c := range outs {
+				if policy == Drop {
+					select {
+					case c <- v:
+					default:
+					}
+					continue
+				}
+				select {
+				case c <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ro
+}
@@ -0,0 +1,40 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestBroadcastBlock(t *testing.T) {
+	leakcheck.Verify(t)
+	outs := Broadcast(context.Background(), count(5), 3, 0, Block)
+	results := make(chan []int)
+	for _, o := range outs {
+		go func(o <-chan int) { results <- collect(o) }(o)
+	}
+	for range outs {
+		if got := <-results; !equal(got, seq(5)) {
+			t.Errorf("subscriber got %v", got)
+		}
+	}
+}
+
+func TestBroadcastDrop(t *testing.T) {
+	leakcheck.Verify(t)
+	in := make(chan int)
+	outs := Broadcast(context.Background(), in, 2, 1, Drop)
+	fast := make(chan []int)
+	go func() { fast <- collect(outs[0]) }()
+	for i := 0; i < 100; i++ {
+		in <- i
+	}
+	close(in)
+	// The slow subscriber, not reading until now, had room for one value, and
+	// perhaps one or two still on their way through when it started.
+	if got := collect(outs[1]); len(got) > 3 || got[0] != 0 {
+		t.Errorf("slow subscriber got %v", got)
+	}
+	<-fast
+}
@@ -0,0 +1,184 @@
+package chanutil
+
+/*
+Broker is an in-process publish/subscribe hub: values published on a topic go to every current
+subscriber to it, each through a channel of its own with room for the broker's buffer of values.
+As with Broadcast, policy decides what happens when a subscriber's buffer is full: Block waits for
+it, pacing the topic to its slowest reader, while Drop skips the value for that subscriber alone,
+counting it against the topic for Dropped.
+One goroutine owns the subscriptions; Close stops it and closes every subscriber channel.
+*/
+type Broker[T any] struct {
+	publish     chan brokerMessage[T]
+	subscribe   chan brokerSub[T]
+	unsubscribe chan brokerSub[T]
+	dropped     chan brokerDropped
+	stop        chan struct{}
+	done        chan struct{} // closed when the owner has exited, after setting final
+	final       map[string]uint64
+}
+
+type brokerDropped struct {
+	topic string
+	reply chan uint64
+}
+
+type brokerMessage[T any] struct {
+	topic string
+	v     T
+}
+
+type brokerSub[T any] struct {
+	topic string
+	ch    <-chan T
+	reply chan (<-chan T) // for subscribe: where the new channel is returned
+}
+
+// NewBroker starts a Broker giving each subscriber a buffer of the given size.
+func NewBroker[T any](buffer int, policy Policy) *Broker[T] {
+	b := &Broker[T]{
+		publish:     make(chan brokerMessage[T]),
+		subscribe:   make(chan brokerSub[T]),
+		unsubscribe: make(chan brokerSub[T]),
+		dropped:     make(chan brokerDropped),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go func() {
+		topics := make(map[string][]chan T)
+		drops := make(map[string]uint64)
+		defer func() {
+			b.final = drops
+			close(b.done)
+		}()
+		remove := func(u brokerSub[T]) {
+			subs := topics[u.topic]
+			for i, c := range subs {
+				if c == u.ch {
+					close(c)
+					topics[u.topic] = append(subs[:i], subs[i+1:]...)
+					break
+				}
+			}
+			if len(topics[u.topic]) == 0 {
+				delete(topics, u.topic)
+			}
+		}
+		subscribed := func(topic string, c chan T) bool {
+			for _, x := range topics[topic] {
+				if x == c {
+					return true
+				}
+			}
+			return false
+		}
+		defer func() {
+			for _, subs := range topics {
+				for _, c := range subs {
+					close(c)
+				}
+			}
+		}()
+		for {
+			select {
+			case s := <-b.subscribe:
+				c := make(chan T, buffer)
+				topics[s.topic] = append(topics[s.topic], c)
+				s.reply <- c
+			case u := <-b.unsubscribe:
+				remove(u)
+			case q := <-b.dropped:
+				q.reply <- drops[q.topic]
+			case m := <-b.publish:
+				// Copied, as a blocked send below may unsubscribe from the topic.
+				for _, c := range append([]chan T(nil), topics[m.topic]...) {
+					if !subscribed(m.topic, c) {
+						continue // closed by an unsubscribe while sending to another
+					}
+					if policy == Drop {
+						select {
+						case c <- m.v:
+						default:
+							drops[m.topic]++
+						}
+						continue
+					}
+					// Keep serving unsubscribes while waiting, or a reader that
+					// stopped reading to leave would never get to.
+				send:
+					for {
+						select {
+						case c <- m.v:
+							break send
+						case u := <-b.unsubscribe:
+							remove(u)
+							if u.ch == c {
+								break send
+							}
+						case <-b.stop:
+							return
+						}
+					}
+				}
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+	return b
+}
+
+// Publish sends v to the subscribers of topic. It does nothing once the
+// broker is closed.
+func (b *Broker[T]) Publish(topic string, v T) {
+	select {
+	case b.publish <- brokerMessage[T]{topic, v}:
+	case <-b.done:
+	}
+}
+
+// Subscribe returns a new channel receiving what is published on topic from
+// now on, closed on Unsubscribe or Close. Once the broker is closed it
+// returns a closed channel.
+func (b *Broker[T]) Subscribe(topic string) <-chan T {
+	reply := make(chan (<-chan T), 1)
+	select {
+	case b.subscribe <- brokerSub[T]{topic: topic, reply: reply}:
+		return <-reply
+	case <-b.done:
+		c := make(chan T)
+		close(c)
+		return c
+	}
+}
+
+// Unsubscribe ends the subscription to topic that returned ch, closing ch.
+func (b *Broker[T]) Unsubscribe(topic string, ch <-chan T) {
+	select {
+	case b.unsubscribe <- brokerSub[T]{topic: topic, ch: ch}:
+	case <-b.done:
+	}
+}
+
+// Dropped returns how many values published on topic have been skipped for
+// a subscriber whose buffer was full, counting each subscriber that missed
+// one.
+func (b *Broker[T]) Dropped(topic string) uint64 {
+	reply := make(chan uint64)
+	select {
+	case b.dropped <- brokerDropped{topic, reply}:
+		return <-reply
+	case <-b.done:
+		return b.final[topic]
+	}
+}
+
+// Close stops the broker and closes every subscriber channel. It may be
+// called more than once.
+func (b *Broker[T]) Close() {
+	select {
+	case b.stop <- struct{}{}:
+		<-b.done
+	case <-b.done:
+	}
+}
@@ -0,0 +1,63 @@
+package chanutil
+
+import (
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestBroker(t *testing.T) {
+	leakcheck.Verify(t)
+	b := NewBroker[int](4, Block)
+	defer b.Close()
+	x1, x2, y := b.Subscribe("x"), b.Subscribe("x"), b.Subscribe("y")
+	b.Publish("x", 1)
+	b.Publish("y", 2)
+	if <-x1 != 1 || <-x2 != 1 || <-y != 2 {
+		t.Fatal("a subscriber missed a value")
+	}
+	b.Unsubscribe("x", x2)
+	if _, ok := <-x2; ok {
+		t.Error("channel still open after Unsubscribe")
+	}
+}
+
+func TestBrokerUnsubscribeWhileBlocked(t *testing.T) {
+	leakcheck.Verify(t)
+	b := NewBroker[int](0, Block)
+	a, stalled := b.Subscribe("x"), b.Subscribe("x")
+	published := make(chan struct{})
+	go func() {
+		b.Publish("x", 1)
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// Leaving must not wait for the value the broker is trying to send.
+	b.Unsubscribe("x", stalled)
+	if v := <-a; v != 1 {
+		t.Errorf("got %d", v)
+	}
+	<-published
+	b.Close()
+	b.Close()
+	if _, ok := <-a; ok {
+		t.Error("channel still open after Close")
+	}
+}
+
+func TestBrokerDrop(t *testing.T) {
+	leakcheck.Verify(t)
+	b := NewBroker[int](1, Drop)
+	s := b.Subscribe("t")
+	for i := 0; i < 3; i++ {
+		b.Publish("t", i)
+	}
+	if d := b.Dropped("t"); d != 2 {
+		t.Errorf("Dropped = %d, want 2", d)
+	}
+	b.Close()
+	if got := collect(s); len(got) != 1 {
+		t.Errorf("subscriber got %v, want 1 value", got)
+	}
+}
@@ -0,0 +1,85 @@
+package chanutil
+
+/*
+Closer wraps a channel so that any number of goroutines may send on it and close it without a
+send on a closed channel or a second close ever panicking: Close may be called any number of
+times, and Send reports false once it has been. Senders still in Send when it is closed give up,
+and the channel itself is closed after the last of them has. Close returns only then, so no Send
+succeeds after Close has returned, though one racing with it may.
+*/
+type Closer[T any] struct {
+	ch      chan T
+	done    chan struct{} // closed by the first Close
+	drained chan struct{} // closed with ch, once the last Send has given up
+	state   chan *closerState
+}
+
+type closerState struct {
+	closed  bool
+	senders int // goroutines inside Send
+}
+
+// NewCloser returns a Closer around a new channel with the given buffer.
+func NewCloser[T any](buffer int) *Closer[T] {
+	c := &Closer[T]{ch: make(chan T, buffer), done: make(chan struct{}), drained: make(chan struct{}), state: make(chan *closerState, 1)}
+	c.state <- &closerState{}
+	return c
+}
+
+// Out returns the channel to receive from.
+func (c *Closer[T]) Out() <-chan T { return c.ch }
+
+// Done returns a channel closed when Close is first called.
+func (c *Closer[T]) Done() <-chan struct{} { return c.done }
+
+// Send waits until v is sent, and reports true, or until the Closer is
+// closed, and reports false.
+func (c *Closer[T]) Send(v T) bool {
+	st := <-c.state
+	if st.closed {
+		c.state <- st
+		return false
+	}
+	st.senders++
+	c.state <- st
+
+	var sent bool
+	select {
+	case c.ch <- v:
+		sent = true
+	case <-c.done:
+	}
+
+	st = <-c.state
+	st.senders--
+	if st.closed && st.senders == 0 {
+		c.closeChannel()
+	}
+	c.state <- st
+	return sent
+}
+
+// Close closes the Closer, and the channel once no Send is in progress,
+// which it waits for. It reports whether this call was the one that closed
+// it.
+func (c *Closer[T]) Close() bool {
+	st := <-c.state
+	first := !st.closed
+	if first {
+		st.closed = true
+		close(c.done)
+		if st.senders == 0 {
+			c.closeChannel()
+		}
+	}
+	c.state <- st
+	<-c.drained
+	return first
+}
+
+// closeChannel closes the channel; it is called holding the state, once it
+// is closed and no Send is left.
+func (c *Closer[T]) closeChannel() {
+	close(c.ch)
+	close(c.drained)
+}
@@ -0,0 +1,72 @@
+package chanutil
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestCloser(t *testing.T) {
+	leakcheck.Verify(t)
+	c := NewCloser[int](0)
+	drained := make(chan struct{})
+	go func() {
+		collect(c.Out())
+		close(drained)
+	}()
+	// Senders and closers racing must never panic.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.Send(j)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c.Close()
+		}()
+	}
+	wg.Wait()
+	<-drained
+	if c.Send(1) {
+		t.Error("Send after Close succeeded")
+	}
+	if c.Close() {
+		t.Error("a second Close reported closing")
+	}
+}
+
+func TestCloserSendAfterClose(t *testing.T) {
+	leakcheck.Verify(t)
+	for i := 0; i < 100; i++ {
+		c := NewCloser[int](0)
+		sent := make(chan bool)
+		go func() { sent <- c.Send(i) }()
+		for {
+			st := <-c.state
+			n := st.senders
+			c.state <- st
+			if n > 0 {
+				break
+			}
+			runtime.Gosched()
+		}
+		c.Close()
+		select {
+		case v, ok := <-c.Out():
+			if ok {
+				t.Fatalf("received %d after Close returned", v)
+			}
+		default:
+			t.Fatal("channel still open after Close returned")
+		}
+		if <-sent {
+			t.Fatal("Send reported success after Close returned")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package chanutil
+
+import "time"
+
+/*
+Debounce collapses bursts: it holds back each value of in until window has passed without another
+one, and then sends only the latest. A steady stream with gaps shorter than window therefore
+yields nothing until it pauses. When in is closed a held value is sent at once and the output
+closed.
+*/
+func Debounce[T any](in <-chan T, window time.Duration) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		var latest T
+		var quiet <-chan time.Time
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if quiet != nil {
+						out <- latest
+					}
+					return
+				}
+				latest, quiet = v, time.After(window)
+			case <-quiet:
+				out <- latest
+				quiet = nil
+			}
+		}
+	}()
+	return out
+}
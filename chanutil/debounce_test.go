@@ -0,0 +1,22 @@
+package chanutil
+
+import (
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestDebounce(t *testing.T) {
+	leakcheck.Verify(t)
+	in := make(chan int)
+	out := Debounce(in, 20*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		in <- i
+	}
+	if v := <-out; v != 4 {
+		t.Errorf("Debounce sent %d, want the latest, 4", v)
+	}
+	close(in)
+	collect(out)
+}
@@ -0,0 +1,9 @@
+/*
+Package chanutil collects the channel patterns that kept being rewritten by hand around the
+poller: fan-in, fan-out and the other small stages of a pipeline.
+
+Every function follows the same conventions as the rest of the program. A stage owns the
+channels it returns and is the only one to close them; it closes its output once its inputs are
+drained or its context is cancelled, and launches no goroutine that can outlive both.
+*/
+package chanutil
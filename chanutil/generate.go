@@ -0,0 +1,87 @@
+package chanutil
+
+import (
+	"context"
+	"iter"
+)
+
+// FromSlice sends the values of s in order and then closes its output, or
+// stops early when ctx is cancelled.
+func FromSlice[T any](ctx context.Context, s []T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for _, v := range s {
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// FromFunc sends what fn returns for as long as it reports true, then closes
+// its output; it stops early, without calling fn again, when ctx is
+// cancelled.
+func FromFunc[T any](ctx context.Context, fn func() (T, bool)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := fn()
+			if !ok {
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// FromSeq sends the values of seq and then closes its output. When ctx is
+// cancelled it stops ranging over seq, so the sequence can clean up too.
+func FromSeq[T any](ctx context.Context, seq iter.Seq[T]) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range seq {
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Repeat sends values over and over, in order, until ctx is cancelled, and
+// then closes its output. With no values it closes it at once.
+func Repeat[T any](ctx context.Context, values ...T) <-chan T {
+	return Cycle(ctx, values)
+}
+
+// Cycle is Repeat over the values of s.
+func Cycle[T any](ctx context.Context, s []T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		if len(s) == 0 {
+			return
+		}
+		for i := 0; ; i = (i + 1) % len(s) {
+			select {
+			case out <- s[i]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
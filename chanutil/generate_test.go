@@ -0,0 +1,49 @@
+package chanutil
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestGenerators(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if got := collect(FromSlice(ctx, []int{1, 2, 3})); !equal(got, []int{1, 2, 3}) {
+		t.Errorf("FromSlice = %v", got)
+	}
+	i := 0
+	next := func() (int, bool) { i++; return i, i <= 3 }
+	if got := collect(FromFunc(ctx, next)); !equal(got, []int{1, 2, 3}) {
+		t.Errorf("FromFunc = %v", got)
+	}
+	if got := collect(FromSeq(ctx, slices.Values([]int{4, 5}))); !equal(got, []int{4, 5}) {
+		t.Errorf("FromSeq = %v", got)
+	}
+	if got := collect(Take(ctx, Cycle(ctx, []int{1, 2}), 5)); !equal(got, []int{1, 2, 1, 2, 1}) {
+		t.Errorf("Cycle = %v", got)
+	}
+	if got := collect(Repeat[int](ctx)); len(got) != 0 {
+		t.Errorf("Repeat of nothing = %v", got)
+	}
+}
+
+func TestGeneratorsCancel(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	naturals := func(yield func(int) bool) {
+		for i := 0; yield(i); i++ {
+		}
+	}
+	outs := []<-chan int{FromSeq(ctx, naturals), Repeat(ctx, 1), FromFunc(ctx, func() (int, bool) { return 0, true })}
+	for _, o := range outs {
+		<-o
+	}
+	cancel()
+	for _, o := range outs {
+		collect(o)
+	}
+}
@@ -0,0 +1,71 @@
+package chanutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+Group runs the goroutines of a pipeline together: the first of them to fail cancels the context
+they share, so the rest wind down, and Wait returns once every one has exited. Nothing is left
+running behind a failed pipeline.
+*/
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	errs   chan Errors // holds the failures so far; whoever has it may add to it
+}
+
+// NewGroup returns an empty Group and the context its goroutines run under,
+// derived from ctx and cancelled on the first failure or by Wait.
+func NewGroup(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	g := &Group{ctx: ctx, cancel: cancel, errs: make(chan Errors, 1)}
+	g.errs <- nil
+	return g, ctx
+}
+
+// Go starts fn on a goroutine of its own, passing it the group's context.
+func (g *Group) Go(fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err := fn(g.ctx)
+		if err == nil {
+			return
+		}
+		errs := <-g.errs
+		// Once one goroutine has failed the others are expected to give up
+		// with the cancellation; that is its echo, not news.
+		if len(errs) == 0 || !errors.Is(err, context.Canceled) {
+			errs = append(errs, err)
+		}
+		g.errs <- errs
+		g.cancel()
+	}()
+}
+
+// Wait waits for every goroutine started by Go to return, then cancels the
+// group's context and returns their failures in the order they happened, as
+// Errors, or nil if none failed.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	errs := <-g.errs
+	g.errs <- errs
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Run starts each of fns in a Group under ctx and waits for them.
+func Run(ctx context.Context, fns ...func(ctx context.Context) error) error {
+	g, _ := NewGroup(ctx)
+	for _, fn := range fns {
+		g.Go(fn)
+	}
+	return g.Wait()
+}
@@ -0,0 +1,45 @@
+package chanutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestGroup(t *testing.T) {
+	leakcheck.Verify(t)
+	boom := errors.New("boom")
+	c := make(chan int)
+	err := Run(context.Background(),
+		func(ctx context.Context) error {
+			defer close(c)
+			for i := 0; ; i++ {
+				select {
+				case c <- i:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		},
+		func(ctx context.Context) error {
+			for v := range c {
+				if v == 5 {
+					return boom
+				}
+			}
+			return nil
+		},
+		func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0] != boom {
+		t.Errorf("Run = %v, want just boom", err)
+	}
+	if err := Run(context.Background(), func(context.Context) error { return nil }); err != nil {
+		t.Errorf("Run = %v, want nil", err)
+	}
+}
@@ -0,0 +1,45 @@
+package chanutil
+
+import (
+	"context"
+	"sort"
+)
+
+// count sends 0, 1, ... n-1 and closes its output.
+func count(n int) <-chan int {
+	return FromSlice(context.Background(), seq(n))
+}
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+// collect receives from c until it is closed.
+func collect[T any](c <-chan T) []T {
+	var vs []T
+	for v := range c {
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+func sorted(s []int) []int {
+	sort.Ints(s)
+	return s
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
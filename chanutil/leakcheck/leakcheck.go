@@ -0,0 +1,88 @@
+/*
+Package leakcheck fails tests that leave goroutines behind. Verify notes the goroutines running
+when a test starts; when it ends, any new one still running after a short grace period, such as a
+pipeline stage nobody drained or cancelled, fails the test with its stack.
+It compares goroutines by ID, so tests using Verify must not run in parallel with others.
+*/
+package leakcheck
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Grace is how long a finished test's goroutines are given to exit.
+var Grace = 2 * time.Second
+
+// Verify fails t if goroutines started during it are still running once it
+// has finished and Grace has passed.
+func Verify(t testing.TB) {
+	t.Helper()
+	before := goroutines()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(Grace)
+		for {
+			var leaked []string
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				sort.Strings(leaked)
+				t.Errorf("leaked %d goroutines:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// ignored are the goroutines of the runtime and of the test framework, which
+// come and go on their own.
+var ignored = []string{
+	"testing.(*T).Run(",
+	"testing.(*M).",
+	"testing.tRunner(",
+	"testing.runTests(",
+	"os/signal.signal_recv(",
+	"os/signal.loop(",
+	"runtime.ensureSigM(",
+	"leakcheck.goroutines(",
+}
+
+// goroutines returns the stacks of the running goroutines, but for ignored
+// ones, by ID.
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[string]string)
+next:
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		for _, s := range ignored {
+			if strings.Contains(g, s) {
+				continue next
+			}
+		}
+		// goroutine 7 [chan receive]:
+		fields := strings.Fields(g)
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		stacks[fields[1]] = g
+	}
+	return stacks
+}
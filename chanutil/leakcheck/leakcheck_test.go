@@ -0,0 +1,50 @@
+package leakcheck
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder is a testing.TB that keeps its cleanups and failures to itself.
+type recorder struct {
+	testing.TB
+	cleanups []func()
+	errors   []string
+}
+
+func (r *recorder) Helper()          {}
+func (r *recorder) Cleanup(f func()) { r.cleanups = append(r.cleanups, f) }
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestVerify(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 50 * time.Millisecond
+
+	r := &recorder{TB: t}
+	Verify(r)
+	stop := make(chan struct{})
+	go func() { <-stop }()
+	r.finish()
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "leakcheck.TestVerify") {
+		t.Errorf("leak not reported: %q", r.errors)
+	}
+
+	r = &recorder{TB: t}
+	Verify(r)
+	close(stop)
+	go func() {}()
+	r.finish()
+	if len(r.errors) != 0 {
+		t.Errorf("goroutines that exited reported: %q", r.errors)
+	}
+}
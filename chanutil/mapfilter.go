@@ -0,0 +1,88 @@
+package chanutil
+
+import (
+	"context"
+	"sync"
+)
+
+// parallel runs workers goroutines of work, each reading in and sending on
+// out, and closes out once they have all returned. work must return when in
+// is drained or ctx is cancelled.
+func parallel[T, U any](ctx context.Context, in <-chan T, workers int, work func(in <-chan T, out chan<- U)) <-chan U {
+	if workers < 1 {
+		workers = 1
+	}
+	out := make(chan U)
+	in = OrDone(ctx, in)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			work(in, out)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// MapCh sends fn of every value of in on its output, running fn on workers
+// goroutines at once. Results come out in the order they are ready, which for
+// more than one worker need not be the order of in. The output closes once in
+// is drained, or soon after ctx is cancelled.
+func MapCh[T, U any](ctx context.Context, in <-chan T, workers int, fn func(context.Context, T) U) <-chan U {
+	return parallel(ctx, in, workers, func(in <-chan T, out chan<- U) {
+		for v := range in {
+			select {
+			case out <- fn(ctx, v):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// FilterCh passes on the values of in for which keep is true, testing them on
+// workers goroutines at once; like MapCh it does not keep their order.
+func FilterCh[T any](ctx context.Context, in <-chan T, workers int, keep func(context.Context, T) bool) <-chan T {
+	return parallel(ctx, in, workers, func(in <-chan T, out chan<- T) {
+		for v := range in {
+			if !keep(ctx, v) {
+				continue
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+/*
+ReduceCh folds the values of in into one. Each of workers goroutines folds the values it receives
+into its own accumulator, starting from init with fn, and the accumulators are then folded
+together with combine; so init must be an identity of combine, and fn and combine must not care
+about the order of values, as with sums, counts or maxima.
+It returns once in is drained, or with ctx.Err() if ctx is cancelled first.
+*/
+func ReduceCh[T, A any](ctx context.Context, in <-chan T, workers int, init A, fn func(A, T) A, combine func(A, A) A) (A, error) {
+	partials := parallel(ctx, in, workers, func(in <-chan T, out chan<- A) {
+		acc := init
+		for v := range in {
+			acc = fn(acc, v)
+		}
+		out <- acc // collected below until every worker is done
+	})
+	acc := init
+	for p := range partials {
+		acc = combine(acc, p)
+	}
+	if err := ctx.Err(); err != nil {
+		return init, err
+	}
+	return acc, nil
+}
@@ -0,0 +1,38 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestMapFilter(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx := context.Background()
+	double := func(_ context.Context, v int) int { return 2 * v }
+	big := func(_ context.Context, v int) bool { return v >= 10 }
+	got := sorted(collect(FilterCh(ctx, MapCh(ctx, count(8), 3, double), 2, big)))
+	if want := []int{10, 12, 14}; !equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReduce(t *testing.T) {
+	leakcheck.Verify(t)
+	add := func(a, b int) int { return a + b }
+	sum, err := ReduceCh(context.Background(), count(101), 4, 0, add, add)
+	if err != nil || sum != 5050 {
+		t.Errorf("ReduceCh = %d, %v, want 5050", sum, err)
+	}
+}
+
+func TestReduceCancel(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	add := func(a, b int) int { return a + b }
+	if _, err := ReduceCh(ctx, make(chan int), 2, 0, add, add); err != context.Canceled {
+		t.Errorf("ReduceCh after cancel: err = %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package chanutil
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+Merge fans in: it returns a channel that receives every value sent on any of chans, in the order
+they arrive, and closes it once all of chans are closed.
+When ctx is cancelled Merge stops forwarding and closes the output without waiting for the
+inputs; values still unread on them are left there.
+*/
+func Merge[T any](ctx context.Context, chans ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, c := range chans {
+		go func(c <-chan T) {
+			defer wg.Done()
+			for v := range OrDone(ctx, c) {
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(c)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,26 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestMerge(t *testing.T) {
+	leakcheck.Verify(t)
+	got := sorted(collect(Merge(context.Background(), count(3), count(2))))
+	if want := []int{0, 0, 1, 1, 2}; !equal(got, want) {
+		t.Errorf("Merge = %v, want %v", got, want)
+	}
+}
+
+func TestMergeCancel(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	never := make(chan int)
+	out := Merge(ctx, never, Repeat(ctx, 1))
+	<-out
+	cancel()
+	collect(out)
+}
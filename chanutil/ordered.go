@@ -0,0 +1,68 @@
+package chanutil
+
+import "context"
+
+// sequenced is a value tagged with its position in the input.
+type sequenced[T any] struct {
+	seq int
+	v   T
+}
+
+/*
+OrderedMapCh is MapCh keeping the order of in: fn runs on workers goroutines at once, but its
+results are sent in the order of the values they came from. Results that are ready early wait in
+a reordering buffer for those before them; at most twice workers values are in flight, so one
+slow value holds up the stream instead of letting the buffer grow without bound.
+The output closes once in is drained, or soon after ctx is cancelled.
+*/
+func OrderedMapCh[T, U any](ctx context.Context, in <-chan T, workers int, fn func(context.Context, T) U) <-chan U {
+	if workers < 1 {
+		workers = 1
+	}
+	window := make(chan struct{}, 2*workers) // one token per value in flight
+	tagged := make(chan sequenced[T])
+	go func() {
+		defer close(tagged)
+		seq := 0
+		for v := range OrDone(ctx, in) {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case tagged <- sequenced[T]{seq, v}:
+				seq++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	results := MapCh(ctx, tagged, workers, func(ctx context.Context, s sequenced[T]) sequenced[U] {
+		return sequenced[U]{s.seq, fn(ctx, s.v)}
+	})
+	out := make(chan U)
+	go func() {
+		defer close(out)
+		pending := make(map[int]U)
+		next := 0
+		for r := range results {
+			pending[r.seq] = r.v
+			for {
+				v, ok := pending[next]
+				if !ok {
+					break
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+				delete(pending, next)
+				next++
+				<-window
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,21 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestOrderedMapCh(t *testing.T) {
+	leakcheck.Verify(t)
+	// Later values finish first; they must still come out in order.
+	slow := func(_ context.Context, v int) int {
+		time.Sleep(time.Duration(10-v) * time.Millisecond)
+		return v
+	}
+	if got := collect(OrderedMapCh(context.Background(), count(10), 4, slow)); !equal(got, seq(10)) {
+		t.Errorf("OrderedMapCh = %v", got)
+	}
+}
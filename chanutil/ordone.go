@@ -0,0 +1,35 @@
+package chanutil
+
+import "context"
+
+/*
+OrDone forwards the values of in until in is closed or ctx is cancelled, then closes its output.
+It turns the usual consumer loop, a select on both in and ctx.Done() around every receive, into
+a plain range:
+
+	for v := range chanutil.OrDone(ctx, in) {
+		...
+	}
+*/
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
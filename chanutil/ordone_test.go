@@ -0,0 +1,20 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestOrDone(t *testing.T) {
+	leakcheck.Verify(t)
+	if got := collect(OrDone(context.Background(), count(3))); !equal(got, []int{0, 1, 2}) {
+		t.Errorf("OrDone = %v", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := collect(OrDone(ctx, make(chan int))); len(got) != 0 {
+		t.Errorf("OrDone after cancel = %v", got)
+	}
+}
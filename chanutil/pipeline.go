@@ -0,0 +1,90 @@
+package chanutil
+
+import "context"
+
+// Stage is one step of a pipeline: it reads from in until in is closed or ctx
+// is cancelled, and returns the channel it sends its results on, which it
+// closes when it is done.
+type Stage[In, Out any] func(ctx context.Context, in <-chan In) <-chan Out
+
+// Lift makes a Stage of fn, calling it on every value in turn on one goroutine
+// and sending on what it returns unless ok is false.
+func Lift[In, Out any](fn func(ctx context.Context, v In) (out Out, ok bool)) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In) <-chan Out {
+		out := make(chan Out)
+		go func() {
+			defer close(out)
+			for v := range OrDone(ctx, in) {
+				r, ok := fn(ctx, v)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out
+	}
+}
+
+// Chain returns the stage that feeds the output of first into second.
+func Chain[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in <-chan A) <-chan C {
+		return second(ctx, first(ctx, in))
+	}
+}
+
+/*
+Pipeline is a chain of stages sharing one context, built up from a source:
+
+	p := chanutil.Through(chanutil.From(ctx, urls), fetch)
+	p = p.Then(retryFailed)
+	for r := range p.Out() {
+		...
+	}
+
+Closing the source winds the pipeline down stage by stage as each drains its input and closes its
+output; Stop cancels every stage at once. Either way every goroutine of the pipeline exits.
+Since a method cannot introduce a type parameter, a stage that changes the element type is added
+with the function Through and one that keeps it with the method Then.
+*/
+type Pipeline[T any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	out    <-chan T
+}
+
+// From starts a pipeline reading src, under a context derived from ctx.
+func From[T any](ctx context.Context, src <-chan T) *Pipeline[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Pipeline[T]{ctx: ctx, cancel: cancel, out: src}
+}
+
+// Through returns p extended by s.
+func Through[T, U any](p *Pipeline[T], s Stage[T, U]) *Pipeline[U] {
+	return &Pipeline[U]{ctx: p.ctx, cancel: p.cancel, out: s(p.ctx, p.out)}
+}
+
+// Then returns p extended by s.
+func (p *Pipeline[T]) Then(s Stage[T, T]) *Pipeline[T] {
+	return Through(p, s)
+}
+
+// Out returns the output of the last stage.
+func (p *Pipeline[T]) Out() <-chan T { return p.out }
+
+// Stop cancels every stage of the pipeline. The output is closed soon after;
+// values still in flight are lost.
+func (p *Pipeline[T]) Stop() { p.cancel() }
+
+// Drain calls fn for every value out of the pipeline until it is closed, and
+// releases the pipeline's context.
+func (p *Pipeline[T]) Drain(fn func(T)) {
+	defer p.cancel()
+	for v := range p.out {
+		fn(v)
+	}
+}
@@ -0,0 +1,31 @@
+package chanutil
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestPipeline(t *testing.T) {
+	leakcheck.Verify(t)
+	even := Lift(func(_ context.Context, v int) (int, bool) { return v, v%2 == 0 })
+	str := Lift(func(_ context.Context, v int) (string, bool) { return strconv.Itoa(v), true })
+	var got []string
+	Through(From(context.Background(), count(6)).Then(even), str).Drain(func(s string) { got = append(got, s) })
+	if len(got) != 3 || got[0] != "0" || got[2] != "4" {
+		t.Errorf("pipeline = %v", got)
+	}
+}
+
+func TestPipelineStop(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	id := Lift(func(_ context.Context, v int) (int, bool) { return v, true })
+	p := From(ctx, Repeat(ctx, 1)).Then(Chain(id, id))
+	<-p.Out()
+	p.Stop()
+	collect(p.Out())
+}
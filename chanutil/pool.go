@@ -0,0 +1,154 @@
+package chanutil
+
+import (
+	"context"
+	"strings"
+)
+
+// Errors is the error of a Pool whose work failed for some values, or of a
+// Group some of whose goroutines did: every failure, in the order they
+// happened.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+/*
+Pool runs a number of workers over an input channel: each receives values from in, calls the
+pool's function on them and sends what it returns on Out. A value for which the function fails
+produces no output; its error is kept for Wait instead. Resize changes how many workers there are.
+The workers stop when in is closed or the pool is closed, and Out is closed once they all have.
+One goroutine keeps count of the workers and collects their errors.
+*/
+type Pool[In, Out any] struct {
+	out     chan Out
+	cancel  context.CancelFunc
+	resize  chan int
+	resized chan struct{} // answers each resize once it is done
+	done    chan struct{} // closed when the workers have exited, after errs is set
+	errs    Errors
+}
+
+// NewPool starts workers goroutines running fn over in, under a context
+// derived from ctx that is passed to fn and cancelled by Close.
+func NewPool[In, Out any](ctx context.Context, in <-chan In, workers int, fn func(context.Context, In) (Out, error)) *Pool[In, Out] {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Pool[In, Out]{out: make(chan Out), cancel: cancel, resize: make(chan int), resized: make(chan struct{}), done: make(chan struct{})}
+	errc := make(chan error)
+	exited := make(chan bool) // true when the worker ran out of input
+	worker := func(stop <-chan struct{}) {
+		for {
+			// A worker told to stop must not pick another value first,
+			// as the select below might.
+			select {
+			case <-stop:
+				exited <- false
+				return
+			default:
+			}
+			select {
+			case <-stop:
+				exited <- false
+				return
+			case <-ctx.Done():
+				exited <- true
+				return
+			case v, ok := <-in:
+				if !ok {
+					exited <- true
+					return
+				}
+				r, err := fn(ctx, v)
+				if err != nil {
+					errc <- err
+					continue
+				}
+				select {
+				case p.out <- r:
+				case <-ctx.Done():
+					exited <- true
+					return
+				}
+			}
+		}
+	}
+	go func() {
+		var stops []chan struct{} // of the workers not yet told to stop
+		live := 0
+		finishing := false // once in is closed or ctx is done, no new workers
+		set := func(n int) {
+			for len(stops) < n {
+				stop := make(chan struct{})
+				stops = append(stops, stop)
+				live++
+				go worker(stop)
+			}
+			for len(stops) > n {
+				close(stops[len(stops)-1])
+				stops = stops[:len(stops)-1]
+			}
+		}
+		set(workers)
+		var errs Errors
+		for live > 0 {
+			select {
+			case n := <-p.resize:
+				if !finishing {
+					set(n)
+				}
+				p.resized <- struct{}{}
+			case out := <-exited:
+				live--
+				finishing = finishing || out
+			case err := <-errc:
+				errs = append(errs, err)
+			}
+		}
+		close(p.out)
+		p.errs = errs
+		close(p.done)
+	}()
+	return p
+}
+
+// Resize changes the number of workers to n, at least 1. It returns once
+// workers beyond n will take no more values; each stops after sending the
+// result of the one it is working on, if any. Resizing a pool whose workers
+// have stopped does nothing.
+func (p *Pool[In, Out]) Resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	select {
+	case p.resize <- n:
+		<-p.resized
+	case <-p.done:
+	}
+}
+
+// Out returns the channel the results are sent on.
+func (p *Pool[In, Out]) Out() <-chan Out { return p.out }
+
+// Close stops the workers, abandoning any values they have not yet sent. It
+// may be called more than once.
+func (p *Pool[In, Out]) Close() { p.cancel() }
+
+// Wait waits for the workers to exit, whether because in was closed or the
+// pool was, and returns the errors of the values that failed, as Errors, or
+// nil if none did. Out must be drained for the workers to finish.
+func (p *Pool[In, Out]) Wait() error {
+	<-p.done
+	p.cancel()
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs
+}
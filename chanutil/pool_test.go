@@ -0,0 +1,83 @@
+package chanutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestPool(t *testing.T) {
+	leakcheck.Verify(t)
+	odd := errors.New("odd")
+	p := NewPool(context.Background(), count(10), 3, func(_ context.Context, v int) (int, error) {
+		if v%2 == 1 {
+			return 0, odd
+		}
+		return v, nil
+	})
+	if got := sorted(collect(p.Out())); !equal(got, []int{0, 2, 4, 6, 8}) {
+		t.Errorf("Pool sent %v", got)
+	}
+	var errs Errors
+	if err := p.Wait(); !errors.As(err, &errs) || len(errs) != 5 {
+		t.Errorf("Wait = %v, want 5 errors", err)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := NewPool(ctx, Repeat(ctx, 1), 2, func(_ context.Context, v int) (int, error) { return v, nil })
+	<-p.Out()
+	p.Close()
+	collect(p.Out())
+	if err := p.Wait(); err != nil {
+		t.Errorf("Wait = %v", err)
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	leakcheck.Verify(t)
+	in := make(chan int)
+	started := make(chan int)
+	release := make(chan struct{})
+	p := NewPool(context.Background(), in, 1, func(_ context.Context, v int) (int, error) {
+		started <- v
+		<-release
+		return v, nil
+	})
+	go collect(p.Out())
+
+	// Three workers take three values at once.
+	p.Resize(3)
+	for i := 0; i < 3; i++ {
+		in <- i
+		<-started
+	}
+	// Back down to one: the busy workers finish, and only one takes more.
+	p.Resize(1)
+	for i := 0; i < 3; i++ {
+		release <- struct{}{}
+	}
+	go func() {
+		defer close(in)
+		in <- 3
+		in <- 4
+	}()
+	<-started
+	select {
+	case v := <-started:
+		t.Errorf("started %d while the only worker was busy", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	if err := p.Wait(); err != nil {
+		t.Errorf("Wait = %v", err)
+	}
+}
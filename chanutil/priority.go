@@ -0,0 +1,72 @@
+package chanutil
+
+import (
+	"context"
+	"reflect"
+)
+
+/*
+PrioritySelect merges chans like Merge, but in priority order: whenever values are waiting on
+more than one of them, the one from the earliest of chans is sent first. Control messages such
+as shutdown or reload put first are so never queued behind bulk traffic put after them. The
+output is closed once all of chans are, or when ctx is cancelled.
+Only values already waiting are compared: one that arrives while a lower-priority value is being
+sent goes next, not instead.
+*/
+func PrioritySelect[T any](ctx context.Context, chans ...<-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		open := append([]<-chan T(nil), chans...)
+		for len(open) > 0 {
+			v, i, ok := readyFirst(open)
+			if i < 0 {
+				v, i, ok = waitAny(ctx, open)
+				if i < 0 {
+					return
+				}
+			}
+			if !ok {
+				open = append(open[:i], open[i+1:]...)
+				continue
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// readyFirst receives from the first of chans with a value waiting or that
+// is closed, returning its index, or -1 if none is ready.
+func readyFirst[T any](chans []<-chan T) (v T, i int, ok bool) {
+	for i, c := range chans {
+		select {
+		case v, ok := <-c:
+			return v, i, ok
+		default:
+		}
+	}
+	return v, -1, false
+}
+
+// waitAny blocks until one of chans is ready, returning as readyFirst does,
+// or -1 if ctx is cancelled first.
+func waitAny[T any](ctx context.Context, chans []<-chan T) (v T, i int, ok bool) {
+	cases := make([]reflect.SelectCase, len(chans)+1)
+	cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+	for i, c := range chans {
+		cases[i+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)}
+	}
+	chosen, rv, ok := reflect.Select(cases)
+	if chosen == 0 {
+		return v, -1, false
+	}
+	if ok {
+		v, _ = rv.Interface().(T) // a nil interface value stays the zero T
+	}
+	return v, chosen - 1, ok
+}
@@ -0,0 +1,31 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestPrioritySelect(t *testing.T) {
+	leakcheck.Verify(t)
+	hi, lo := make(chan int, 5), make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		lo <- 100 + i
+		hi <- i
+	}
+	close(hi)
+	close(lo)
+	want := []int{0, 1, 2, 3, 4, 100, 101, 102, 103, 104}
+	if got := collect(PrioritySelect(context.Background(), hi, lo)); !equal(got, want) {
+		t.Errorf("PrioritySelect = %v, want %v", got, want)
+	}
+}
+
+func TestPrioritySelectCancel(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := PrioritySelect(ctx, make(chan int), make(chan int))
+	cancel()
+	collect(out)
+}
@@ -0,0 +1,154 @@
+package chanutil
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrFull is returned by Put on a full Queue whose policy is Drop.
+var ErrFull = errors.New("chanutil: queue full")
+
+// QueueStats is a snapshot of a Queue's metrics. The durations are totals,
+// to be divided by the matching counts for averages.
+type QueueStats struct {
+	Depth, Capacity int
+	Enqueued        uint64        // values accepted by Put
+	Rejected        uint64        // values turned away because the queue was full
+	Dequeued        uint64        // values received from Out
+	EnqueueWait     time.Duration // time Put spent waiting for room
+	QueueWait       time.Duration // time dequeued values spent in the queue
+}
+
+/*
+Queue is a bounded FIFO queue between producers, which Put, and a consumer, which receives from
+Out. When it is full its policy decides what Put does: wait for room (Block), or turn the value
+away with ErrFull at once (Drop), leaving the producer to decide what a missed turn means.
+It counts how long producers wait and values queue, so a consumer falling behind shows up in
+Stats before it shows up as lost work.
+One goroutine owns the buffer. After Close, Put fails with ErrClosed; Out is closed once the
+values already queued have been received.
+*/
+type Queue[T any] struct {
+	put     chan queuePut[T]
+	out     chan T
+	closing chan struct{}
+	closed  chan struct{} // closed once Put no longer accepts values
+	stats   chan chan QueueStats
+	done    chan struct{} // closed when the owner exits, after setting final
+	final   QueueStats
+}
+
+type queuePut[T any] struct {
+	v     T
+	start time.Time
+	reply chan error
+}
+
+type queued[T any] struct {
+	v  T
+	at time.Time
+}
+
+// NewQueue returns a Queue holding up to capacity values; capacity must be at
+// least 1.
+func NewQueue[T any](capacity int, policy Policy) *Queue[T] {
+	if capacity < 1 {
+		panic("chanutil: NewQueue capacity must be at least 1")
+	}
+	q := &Queue[T]{
+		put:     make(chan queuePut[T]),
+		out:     make(chan T),
+		closing: make(chan struct{}),
+		closed:  make(chan struct{}),
+		stats:   make(chan chan QueueStats),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(q.out)
+		buf := make([]queued[T], capacity)
+		var head, n int // buf[head] is the oldest of n values
+		st := QueueStats{Capacity: capacity}
+		closing := q.closing
+		for closing != nil || n > 0 {
+			// Under Block, only take values when there is room for them, so
+			// that Put waits; under Drop, take them to turn them away.
+			put := q.put
+			if closing == nil || n == capacity && policy == Block {
+				put = nil
+			}
+			var out chan T
+			var next T
+			if n > 0 {
+				out, next = q.out, buf[head].v
+			}
+			select {
+			case p := <-put:
+				if n == capacity {
+					st.Rejected++
+					p.reply <- ErrFull
+					continue
+				}
+				now := time.Now()
+				st.Enqueued++
+				st.EnqueueWait += now.Sub(p.start)
+				buf[(head+n)%capacity] = queued[T]{v: p.v, at: now}
+				n++
+				p.reply <- nil
+			case out <- next:
+				st.Dequeued++
+				st.QueueWait += time.Since(buf[head].at)
+				buf[head] = queued[T]{}
+				head = (head + 1) % capacity
+				n--
+			case <-closing:
+				closing = nil
+				close(q.closed)
+			case reply := <-q.stats:
+				st.Depth = n
+				reply <- st
+			}
+		}
+		st.Depth = 0
+		q.final = st
+		close(q.done)
+	}()
+	return q
+}
+
+// Put adds v to the queue, as its policy says when the queue is full. It
+// returns ErrFull if v was turned away, ErrClosed if the queue is closed, or
+// ctx's error if ctx was cancelled while waiting for room.
+func (q *Queue[T]) Put(ctx context.Context, v T) error {
+	reply := make(chan error, 1)
+	select {
+	case q.put <- queuePut[T]{v: v, start: time.Now(), reply: reply}:
+		return <-reply
+	case <-q.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Out returns the channel to receive queued values from.
+func (q *Queue[T]) Out() <-chan T { return q.out }
+
+// Close stops the queue accepting values. It may be called more than once.
+func (q *Queue[T]) Close() {
+	select {
+	case q.closing <- struct{}{}:
+	case <-q.closed:
+	}
+}
+
+// Stats returns the queue's metrics so far.
+func (q *Queue[T]) Stats() QueueStats {
+	reply := make(chan QueueStats)
+	select {
+	case q.stats <- reply:
+		return <-reply
+	case <-q.done:
+		return q.final
+	}
+}
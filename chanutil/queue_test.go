@@ -0,0 +1,58 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestQueueReject(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx := context.Background()
+	q := NewQueue[int](2, Drop)
+	for i, want := range []error{nil, nil, ErrFull} {
+		if err := q.Put(ctx, i); err != want {
+			t.Errorf("Put(%d) = %v, want %v", i, err, want)
+		}
+	}
+	if s := q.Stats(); s.Depth != 2 || s.Enqueued != 2 || s.Rejected != 1 {
+		t.Errorf("Stats = %+v", s)
+	}
+	q.Close()
+	q.Close()
+	if err := q.Put(ctx, 3); err != ErrClosed {
+		t.Errorf("Put after Close = %v", err)
+	}
+	if got := collect(q.Out()); !equal(got, []int{0, 1}) {
+		t.Errorf("drained %v", got)
+	}
+	if s := q.Stats(); s.Dequeued != 2 || s.Depth != 0 {
+		t.Errorf("Stats after draining = %+v", s)
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx := context.Background()
+	q := NewQueue[int](1, Block)
+	defer q.Close()
+	q.Put(ctx, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-q.Out()
+	}()
+	if err := q.Put(ctx, 2); err != nil {
+		t.Fatalf("Put = %v", err)
+	}
+	if w := q.Stats().EnqueueWait; w < 15*time.Millisecond {
+		t.Errorf("EnqueueWait = %s, want about 20ms", w)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	if err := q.Put(ctx, 3); err != context.DeadlineExceeded {
+		t.Errorf("Put on a full queue = %v", err)
+	}
+	<-q.Out()
+}
@@ -0,0 +1,107 @@
+package chanutil
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long to wait before each retry. Next is called after
+// the given failed attempt, counting from 1, and returns the delay before the
+// next one, or false to give up.
+type Backoff interface {
+	Next(attempt int) (time.Duration, bool)
+}
+
+/*
+Exponential is the usual backoff: Initial before the first retry, then Multiplier times longer
+each time (2 if unset), up to Max if set and the longest time.Duration if not, for at most
+MaxAttempts attempts in all (0: no limit). Jitter spreads each delay randomly over ±Jitter of
+itself, 0.2 meaning ±20%, so that items that failed together do not all retry together.
+*/
+type Exponential struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64
+	MaxAttempts int
+}
+
+func (b Exponential) Next(attempt int) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt >= b.MaxAttempts {
+		return 0, false
+	}
+	m := b.Multiplier
+	if m == 0 {
+		m = 2
+	}
+	ceiling := float64(math.MaxInt64)
+	if b.Max > 0 {
+		ceiling = float64(b.Max)
+	}
+	d := float64(b.Initial)
+	for i := 1; i < attempt; i++ {
+		d *= m
+		if d > ceiling {
+			d = ceiling
+			break
+		}
+	}
+	if b.Jitter > 0 {
+		d *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+	// float64(math.MaxInt64) rounds up to 1<<63, which time.Duration cannot hold.
+	if d >= float64(math.MaxInt64) {
+		return math.MaxInt64, true
+	}
+	return time.Duration(d), true
+}
+
+// Constant waits Delay between attempts, for at most MaxAttempts attempts in
+// all (0: no limit).
+type Constant struct {
+	Delay       time.Duration
+	MaxAttempts int
+}
+
+func (b Constant) Next(attempt int) (time.Duration, bool) {
+	return b.Delay, b.MaxAttempts == 0 || attempt < b.MaxAttempts
+}
+
+// FailedItem is an item Retry gave up on, with its last error.
+type FailedItem[T any] struct {
+	Item     T
+	Err      error
+	Attempts int
+}
+
+/*
+Retry calls attempt on every item of in, retrying those for which it fails as policy says. Items
+that succeed are sent on the first channel; those it gives up on go to the second, the dead
+letters, with their last error. Both close when in is closed and every item has been dealt with.
+Items are dealt with one at a time, in order, so one waiting out its backoff holds up the rest;
+fan out over several Retry stages if that matters. Both outputs must be read.
+*/
+func Retry[T any](in <-chan T, attempt func(T) error, policy Backoff) (<-chan T, <-chan FailedItem[T]) {
+	ok, failed := make(chan T), make(chan FailedItem[T])
+	go func() {
+		defer close(ok)
+		defer close(failed)
+		for v := range in {
+			for n := 1; ; n++ {
+				err := attempt(v)
+				if err == nil {
+					ok <- v
+					break
+				}
+				d, again := policy.Next(n)
+				if !again {
+					failed <- FailedItem[T]{Item: v, Err: err, Attempts: n}
+					break
+				}
+				time.Sleep(d)
+			}
+		}
+	}()
+	return ok, failed
+}
@@ -0,0 +1,68 @@
+package chanutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestRetry(t *testing.T) {
+	leakcheck.Verify(t)
+	tries := make(map[int]int)
+	// Multiples of 3 fail twice, 5 always.
+	attempt := func(v int) error {
+		tries[v]++
+		if v == 5 || v%3 == 0 && tries[v] < 3 {
+			return errors.New("no")
+		}
+		return nil
+	}
+	ok, failed := Retry(count(6), attempt, Exponential{Initial: time.Millisecond, Jitter: 0.2, MaxAttempts: 4})
+	var good []int
+	var bad []FailedItem[int]
+	for ok != nil || failed != nil {
+		select {
+		case v, more := <-ok:
+			if !more {
+				ok = nil
+				continue
+			}
+			good = append(good, v)
+		case f, more := <-failed:
+			if !more {
+				failed = nil
+				continue
+			}
+			bad = append(bad, f)
+		}
+	}
+	if !equal(good, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("succeeded: %v", good)
+	}
+	if len(bad) != 1 || bad[0].Item != 5 || bad[0].Attempts != 4 {
+		t.Errorf("dead letters: %+v", bad)
+	}
+}
+
+func TestExponential(t *testing.T) {
+	b := Exponential{Initial: time.Second, Max: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		if d, ok := b.Next(attempt); !ok || d != want {
+			t.Errorf("Next(%d) = %s, %v, want %s", attempt, d, ok, want)
+		}
+	}
+	if _, ok := (Constant{MaxAttempts: 2}).Next(2); ok {
+		t.Error("Constant retried past MaxAttempts")
+	}
+}
+
+func TestExponentialUncapped(t *testing.T) {
+	b := Exponential{Initial: time.Second, Jitter: 0.5}
+	for _, attempt := range []int{40, 100, 10000} {
+		if d, _ := b.Next(attempt); d <= 0 {
+			t.Errorf("Next(%d) = %s", attempt, d)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package chanutil
+
+/*
+Ring is a bounded queue whose writers never wait for its reader: once it holds size values, each
+new one pushes out the oldest, and the number of values lost that way is counted. It suits
+streams where the latest values matter most, such as status updates, and where a stalled reader
+must not hold up the writers.
+One goroutine owns the buffer. Closing In lets the reader drain what is left, after which Out is
+closed.
+*/
+type Ring[T any] struct {
+	in      chan T
+	out     chan T
+	dropped chan chan uint64
+	done    chan struct{} // closed when the owner exits, after setting final
+	final   uint64
+}
+
+// NewRing returns a Ring holding up to size values; size must be at least 1.
+func NewRing[T any](size int) *Ring[T] {
+	if size < 1 {
+		panic("chanutil: NewRing size must be at least 1")
+	}
+	r := &Ring[T]{in: make(chan T), out: make(chan T), dropped: make(chan chan uint64), done: make(chan struct{})}
+	go func() {
+		defer close(r.out)
+		buf := make([]T, size)
+		var head, n int // buf[head] is the oldest of n values
+		var dropped uint64
+		in := r.in
+		for in != nil || n > 0 {
+			// Only offer a value to the reader when there is one.
+			var out chan T
+			var next T
+			if n > 0 {
+				out, next = r.out, buf[head]
+			}
+			select {
+			case v, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				if n == size {
+					head = (head + 1) % size
+					n--
+					dropped++
+				}
+				buf[(head+n)%size] = v
+				n++
+			case out <- next:
+				var zero T
+				buf[head] = zero
+				head = (head + 1) % size
+				n--
+			case reply := <-r.dropped:
+				reply <- dropped
+			}
+		}
+		r.final = dropped
+		close(r.done)
+	}()
+	return r
+}
+
+// In returns the channel to write to.
+func (r *Ring[T]) In() chan<- T { return r.in }
+
+// Out returns the channel to read from.
+func (r *Ring[T]) Out() <-chan T { return r.out }
+
+// Dropped returns how many values have been discarded to make room so far.
+func (r *Ring[T]) Dropped() uint64 {
+	reply := make(chan uint64)
+	select {
+	case r.dropped <- reply:
+		return <-reply
+	case <-r.done:
+		return r.final
+	}
+}
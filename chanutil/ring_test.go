@@ -0,0 +1,25 @@
+package chanutil
+
+import (
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestRing(t *testing.T) {
+	leakcheck.Verify(t)
+	r := NewRing[int](2)
+	for i := 0; i < 5; i++ {
+		r.In() <- i
+	}
+	if d := r.Dropped(); d != 3 {
+		t.Errorf("Dropped = %d before close, want 3", d)
+	}
+	close(r.In())
+	if got := collect(r.Out()); !equal(got, []int{3, 4}) {
+		t.Errorf("Ring kept %v, want the newest, [3 4]", got)
+	}
+	if d := r.Dropped(); d != 3 {
+		t.Errorf("Dropped = %d after close, want 3", d)
+	}
+}
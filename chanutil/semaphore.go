@@ -0,0 +1,128 @@
+package chanutil
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+Semaphore is a weighted semaphore: callers Acquire some of its capacity, wait while not enough is
+free, and Release it when done. Waiters are served in arrival order, so a large request is not
+starved by a stream of small ones. It bounds work by what it costs, such as memory or
+connections, rather than by how many goroutines do it.
+Its state travels on a channel of one: whoever holds the value may change it.
+*/
+type Semaphore struct {
+	state chan *semState
+}
+
+type semState struct {
+	size, used int64
+	waiters    []*semWaiter
+}
+
+type semWaiter struct {
+	n     int64
+	ready chan struct{} // closed once the waiter holds its share
+}
+
+// NewSemaphore returns a Semaphore of the given capacity.
+func NewSemaphore(size int64) *Semaphore {
+	s := &Semaphore{state: make(chan *semState, 1)}
+	s.state <- &semState{size: size}
+	return s
+}
+
+// Acquire waits until n is free and takes it, or returns ctx's error, having
+// taken nothing, if ctx is cancelled first. Asking for more than the capacity
+// fails at once.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	st := <-s.state
+	if n > st.size {
+		s.state <- st
+		return fmt.Errorf("chanutil: acquiring %d of a semaphore of %d", n, st.size)
+	}
+	if st.used+n <= st.size && len(st.waiters) == 0 {
+		st.used += n
+		s.state <- st
+		return nil
+	}
+	w := &semWaiter{n: n, ready: make(chan struct{})}
+	st.waiters = append(st.waiters, w)
+	s.state <- st
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	st = <-s.state
+	select {
+	case <-w.ready:
+		// Granted while we were being cancelled: give it back.
+		st.used -= n
+	default:
+		for i, x := range st.waiters {
+			if x == w {
+				st.waiters = append(st.waiters[:i], st.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	st.grant() // the waiters behind us may fit now
+	s.state <- st
+	return ctx.Err()
+}
+
+// TryAcquire takes n if it is free now and nobody is waiting, and reports
+// whether it did.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	st := <-s.state
+	ok := st.used+n <= st.size && len(st.waiters) == 0
+	if ok {
+		st.used += n
+	}
+	s.state <- st
+	return ok
+}
+
+// Release gives back n taken by Acquire.
+func (s *Semaphore) Release(n int64) {
+	st := <-s.state
+	st.used -= n
+	if st.used < 0 {
+		s.state <- st
+		panic("chanutil: Semaphore released more than acquired")
+	}
+	st.grant()
+	s.state <- st
+}
+
+// grant hands out capacity to the waiters at the front of the queue for as
+// long as they fit.
+func (st *semState) grant() {
+	for len(st.waiters) > 0 {
+		w := st.waiters[0]
+		if st.used+w.n > st.size {
+			return
+		}
+		st.used += w.n
+		st.waiters = st.waiters[1:]
+		close(w.ready)
+	}
+}
+
+// Limited wraps fn so that each call first acquires weight(v) of sem, and
+// releases it when fn returns. It fits Pool, MapCh and Lift alike, letting
+// costly values be bounded separately from the number of workers.
+func Limited[In, Out any](sem *Semaphore, weight func(In) int64, fn func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, v In) (Out, error) {
+		n := weight(v)
+		if err := sem.Acquire(ctx, n); err != nil {
+			var zero Out
+			return zero, err
+		}
+		defer sem.Release(n)
+		return fn(ctx, v)
+	}
+}
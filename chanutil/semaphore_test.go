@@ -0,0 +1,53 @@
+package chanutil
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestLimited(t *testing.T) {
+	leakcheck.Verify(t)
+	sem := NewSemaphore(10)
+	var cur, peak int64
+	work := func(_ context.Context, v int) (int, error) {
+		c := atomic.AddInt64(&cur, int64(v))
+		for p := atomic.LoadInt64(&peak); c > p && !atomic.CompareAndSwapInt64(&peak, p, c); p = atomic.LoadInt64(&peak) {
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&cur, -int64(v))
+		return v, nil
+	}
+	weight := func(v int) int64 { return int64(v) }
+	in := MapCh(context.Background(), count(40), 1, func(_ context.Context, v int) int { return 1 + v%7 })
+	p := NewPool(context.Background(), in, 8, Limited(sem, weight, work))
+	if n := len(collect(p.Out())); n != 40 {
+		t.Errorf("got %d results, want 40", n)
+	}
+	if peak > 10 {
+		t.Errorf("%d in use at once, over the capacity of 10", peak)
+	}
+}
+
+func TestSemaphoreCancel(t *testing.T) {
+	leakcheck.Verify(t)
+	sem := NewSemaphore(2)
+	if !sem.TryAcquire(2) {
+		t.Fatal("TryAcquire failed on an idle semaphore")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sem.Acquire(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("Acquire on a full semaphore: %v", err)
+	}
+	if err := sem.Acquire(context.Background(), 3); err == nil {
+		t.Error("Acquire beyond the capacity succeeded")
+	}
+	sem.Release(2)
+	if !sem.TryAcquire(2) {
+		t.Error("a cancelled Acquire kept its share")
+	}
+}
@@ -0,0 +1,71 @@
+package chanutil
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is returned when a value is wanted from a channel that turns out
+// to be closed.
+var ErrClosed = errors.New("chanutil: channel closed")
+
+// Take sends on the first n values of in and then closes its output, leaving
+// the rest of in unread. It stops early if in is closed or ctx is cancelled.
+func Take[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for i := 0; i < n; i++ {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Skip discards the first n values of in and sends on the rest, until in is
+// closed or ctx is cancelled.
+func Skip[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range OrDone(ctx, in) {
+			if n > 0 {
+				n--
+				continue
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// First waits for the next value of in. It returns ErrClosed if in is closed
+// first, or ctx's error if ctx is cancelled first.
+func First[T any](ctx context.Context, in <-chan T) (T, error) {
+	select {
+	case v, ok := <-in:
+		if !ok {
+			return v, ErrClosed
+		}
+		return v, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
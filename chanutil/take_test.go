@@ -0,0 +1,40 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestTakeSkip(t *testing.T) {
+	leakcheck.Verify(t)
+	// Take leaves the rest of its input unread, so its sources must be
+	// cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if got := collect(Take(ctx, Skip(ctx, FromSlice(ctx, seq(10)), 3), 4)); !equal(got, []int{3, 4, 5, 6}) {
+		t.Errorf("Take(Skip) = %v", got)
+	}
+	if got := collect(Take(ctx, Repeat(ctx, 7), 2)); !equal(got, []int{7, 7}) {
+		t.Errorf("Take(Repeat) = %v", got)
+	}
+}
+
+func TestFirst(t *testing.T) {
+	leakcheck.Verify(t)
+	ctx := context.Background()
+	c := count(2)
+	if v, err := First(ctx, c); v != 0 || err != nil {
+		t.Errorf("First = %d, %v", v, err)
+	}
+	collect(c)
+	if _, err := First(ctx, c); err != ErrClosed {
+		t.Errorf("First of a closed channel: %v", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := First(ctx, make(chan int)); err != context.Canceled {
+		t.Errorf("First after cancel: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package chanutil
+
+import "context"
+
+/*
+Tee splits in into two identical streams. Each value is handed to both outputs before the next
+one is read, so the two consumers move in step, the faster waiting for the slower. Both outputs
+close when in is closed or ctx is cancelled.
+*/
+func Tee[T any](ctx context.Context, in <-chan T) (<-chan T, <-chan T) {
+	out1, out2 := make(chan T), make(chan T)
+	go func() {
+		defer close(out1)
+		defer close(out2)
+		for v := range OrDone(ctx, in) {
+			// Send to whichever is ready first, then to the other: a sent-to
+			// output is set to nil, which blocks forever in the select.
+			o1, o2 := out1, out2
+			for i := 0; i < 2; i++ {
+				select {
+				case o1 <- v:
+					o1 = nil
+				case o2 <- v:
+					o2 = nil
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out1, out2
+}
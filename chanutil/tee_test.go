@@ -0,0 +1,33 @@
+package chanutil
+
+import (
+	"context"
+	"testing"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestTee(t *testing.T) {
+	leakcheck.Verify(t)
+	a, b := Tee(context.Background(), count(4))
+	var ga, gb []int
+	for a != nil || b != nil {
+		select {
+		case v, ok := <-a:
+			if !ok {
+				a = nil
+				continue
+			}
+			ga = append(ga, v)
+		case v, ok := <-b:
+			if !ok {
+				b = nil
+				continue
+			}
+			gb = append(gb, v)
+		}
+	}
+	if want := seq(4); !equal(ga, want) || !equal(gb, want) {
+		t.Errorf("Tee = %v, %v, want %v twice", ga, gb, want)
+	}
+}
@@ -0,0 +1,42 @@
+package chanutil
+
+import "time"
+
+/*
+Throttle passes on the values of in no faster than rate per second, allowing bursts of up to
+burst values after a quiet spell. It is a token bucket: the bucket holds up to burst tokens,
+refills at rate tokens a second and each value takes one, waiting for it if the bucket is empty.
+Nothing is dropped; a producer faster than rate is slowed down to it instead. The output closes
+when in does. rate must be positive, as a rate of nothing could never let a value through;
+Throttle panics otherwise.
+*/
+func Throttle[T any](in <-chan T, rate float64, burst int) <-chan T {
+	if !(rate > 0) {
+		panic("chanutil: non-positive rate for Throttle")
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		tokens := float64(burst)
+		last := time.Now()
+		for v := range in {
+			now := time.Now()
+			tokens += now.Sub(last).Seconds() * rate
+			if tokens > float64(burst) {
+				tokens = float64(burst)
+			}
+			last = now
+			if tokens < 1 {
+				wait := time.Duration((1 - tokens) / rate * float64(time.Second))
+				time.Sleep(wait)
+				tokens, last = 1, time.Now()
+			}
+			tokens--
+			out <- v
+		}
+	}()
+	return out
+}
@@ -0,0 +1,32 @@
+package chanutil
+
+import (
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestThrottle(t *testing.T) {
+	leakcheck.Verify(t)
+	start := time.Now()
+	got := collect(Throttle(count(6), 100, 2))
+	// Two go at once, the other four at 10ms intervals.
+	if d := time.Since(start); len(got) != 6 || d < 30*time.Millisecond {
+		t.Errorf("Throttle passed %d values in %s", len(got), d)
+	}
+}
+
+func TestThrottleRate(t *testing.T) {
+	leakcheck.Verify(t)
+	for _, rate := range []float64{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Throttle with rate %g did not panic", rate)
+				}
+			}()
+			Throttle(make(chan int), rate, 1)
+		}()
+	}
+}
@@ -0,0 +1,129 @@
+package chanutil
+
+import (
+	"math/rand"
+	"time"
+)
+
+/*
+JitterTicker is a time.Ticker whose ticks come base apart give or take a random jitterFrac of
+base, 0.1 meaning ±10%, chosen afresh for every tick. Many tickers started together then drift
+apart instead of all waking at once. As with time.Ticker, a tick the reader is not ready for is
+dropped rather than queued, and Stop ends the goroutine behind it; C is not closed.
+*/
+type JitterTicker struct {
+	C    <-chan time.Time
+	stop chan struct{}
+	done chan struct{} // closed when the goroutine has exited
+}
+
+// NewJitterTicker starts a JitterTicker; base must be positive, and
+// jitterFrac is limited to [0, 1].
+func NewJitterTicker(base time.Duration, jitterFrac float64) *JitterTicker {
+	if base <= 0 {
+		panic("chanutil: non-positive interval for NewJitterTicker")
+	}
+	if jitterFrac < 0 {
+		jitterFrac = 0
+	} else if jitterFrac > 1 {
+		jitterFrac = 1
+	}
+	c := make(chan time.Time, 1)
+	t := &JitterTicker{C: c, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		timer := time.NewTimer(jittered(base, jitterFrac))
+		defer timer.Stop()
+		for {
+			select {
+			case now := <-timer.C:
+				select {
+				case c <- now:
+				default:
+				}
+				timer.Reset(jittered(base, jitterFrac))
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func jittered(base time.Duration, frac float64) time.Duration {
+	return time.Duration(float64(base) * (1 + frac*(2*rand.Float64()-1)))
+}
+
+// Stop turns the ticker off. It may be called more than once.
+func (t *JitterTicker) Stop() {
+	select {
+	case t.stop <- struct{}{}:
+		<-t.done
+	case <-t.done:
+	}
+}
+
+/*
+AlignedTicker ticks on the wall-clock boundaries of its interval: every minute on the minute, or
+with 10s at :00, :10, :20 and so on, counted from midnight UTC. Each tick works the next boundary
+out afresh from the clock, so time spent handling a tick, a timer firing late or the clock being
+stepped never adds up to drift; a boundary passed while the reader was busy is skipped, not
+queued. Stop ends the goroutine behind it; C is not closed.
+*/
+type AlignedTicker struct {
+	C    <-chan time.Time
+	stop chan struct{}
+	done chan struct{} // closed when the goroutine has exited
+}
+
+// NewAlignedTicker starts an AlignedTicker, whose first tick is on the next
+// boundary; interval must be positive.
+func NewAlignedTicker(interval time.Duration) *AlignedTicker {
+	if interval <= 0 {
+		panic("chanutil: non-positive interval for NewAlignedTicker")
+	}
+	c := make(chan time.Time, 1)
+	t := &AlignedTicker{C: c, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		next := nextBoundary(time.Now(), interval)
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				now := time.Now()
+				if now.Before(next) {
+					// The clock was stepped back; wait for it.
+					timer.Reset(next.Sub(now))
+					continue
+				}
+				select {
+				case c <- next:
+				default:
+				}
+				next = nextBoundary(now, interval)
+				timer.Reset(next.Sub(now))
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// nextBoundary returns the first multiple of interval after t, counting
+// from midnight UTC.
+func nextBoundary(t time.Time, interval time.Duration) time.Time {
+	day := t.UTC().Truncate(24 * time.Hour)
+	return day.Add(t.Sub(day).Truncate(interval) + interval)
+}
+
+// Stop turns the ticker off. It may be called more than once.
+func (t *AlignedTicker) Stop() {
+	select {
+	case t.stop <- struct{}{}:
+		<-t.done
+	case <-t.done:
+	}
+}
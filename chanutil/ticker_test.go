@@ -0,0 +1,58 @@
+package chanutil
+
+import (
+	"testing"
+	"time"
+
+	"example/concurrent/chanutil/leakcheck"
+)
+
+func TestJitterTicker(t *testing.T) {
+	leakcheck.Verify(t)
+	tk := NewJitterTicker(5*time.Millisecond, 0.5)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		<-tk.C
+	}
+	// Ten ticks at 2.5ms to 7.5ms each.
+	if d := time.Since(start); d < 25*time.Millisecond {
+		t.Errorf("10 ticks in %s", d)
+	}
+	tk.Stop()
+	tk.Stop()
+}
+
+func TestAlignedTicker(t *testing.T) {
+	leakcheck.Verify(t)
+	const interval = 20 * time.Millisecond
+	tk := NewAlignedTicker(interval)
+	for i := 0; i < 3; i++ {
+		tick := <-tk.C
+		if off := tick.Sub(tick.Truncate(interval)); off != 0 {
+			t.Errorf("tick %s is %s past a boundary", tick.Format(time.StampMicro), off)
+		}
+		if late := time.Since(tick); late < 0 {
+			t.Errorf("tick %s came %s early", tick.Format(time.StampMicro), -late)
+		}
+		// A slow reader skips boundaries rather than drifting.
+		time.Sleep(interval + interval/2)
+	}
+	tk.Stop()
+	tk.Stop()
+}
+
+func TestNextBoundary(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 59, 0, time.UTC)
+	for _, c := range []struct {
+		interval time.Duration
+		want     time.Time
+	}{
+		{time.Minute, time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC)},
+		{10 * time.Second, time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC)},
+		{7 * time.Hour, time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)},
+	} {
+		if got := nextBoundary(at, c.interval); !got.Equal(c.want) {
+			t.Errorf("nextBoundary(%s, %s) = %s, want %s", at, c.interval, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package chanutil
+
+import "time"
+
+// RecvTimeout receives from ch, giving up after d. It reports false if it
+// timed out or ch was closed.
+func RecvTimeout[T any](ch <-chan T, d time.Duration) (T, bool) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case v, ok := <-ch:
+		return v, ok
+	case <-t.C:
+		var zero T
+		return zero, false
+	}
+}
+
+// SendTimeout sends v on ch, giving up after d, and reports whether it was
+// sent.
+func SendTimeout[T any](ch chan<- T, v T, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case ch <- v:
+		return true
+	case <-t.C:
+		return false
+	}
+}
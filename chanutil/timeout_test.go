@@ -0,0 +1,22 @@
+package chanutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeouts(t *testing.T) {
+	c := make(chan int, 1)
+	if _, ok := RecvTimeout(c, time.Millisecond); ok {
+		t.Error("RecvTimeout received from an empty channel")
+	}
+	if !SendTimeout(c, 1, time.Millisecond) {
+		t.Error("SendTimeout failed on a free buffer")
+	}
+	if SendTimeout(c, 2, time.Millisecond) {
+		t.Error("SendTimeout sent on a full channel")
+	}
+	if v, ok := RecvTimeout(c, time.Millisecond); !ok || v != 1 {
+		t.Errorf("RecvTimeout = %d, %v", v, ok)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+Statuses of checks of targets that are not polled with an HTTP HEAD. A passing check returns
+statusOK, a failing one statusFail, each followed after a space by what it found. statusUp counts
+the first as up; a failed check is not a network error, so no traceroute follows it.
+*/
+const (
+	statusOK   = "OK"
+	statusFail = "FAIL"
+)
+
+// checkTimeout is how long a check may take unless its target says otherwise
+// with a timeout parameter.
+const checkTimeout = 10 * time.Second
+
+/*
+checkers maps the URL schemes of targets checked some other way than over HTTP to the functions
+that check them. Their options are given as query parameters, as in
+exec:check-backups?arg=--max-age&arg=1d&timeout=1m.
+*/
+var checkers = map[string]func(u *url.URL) string{
+	"exec":       execCheck,
+	"file":       fileCheck,
+	"disk":       diskCheck,
+	"process":    processCheck,
+	"ports":      portsCheck,
+	"ssh":        sshCheck,
+	"postgres":   dbCheck,
+	"postgresql": dbCheck,
+	"mysql":      dbCheck,
+	"redis":      redisCheck,
+	"rediss":     redisCheck,
+	"memcached":  memcachedCheck,
+	"mqtt":       mqttCheck,
+	"mqtts":      mqttCheck,
+	"ntp":        ntpCheck,
+	"domain":     domainCheck,
+}
+
+/*
+prefixCheckers maps the prefixes of targets that are checked over HTTP, but not with a HEAD, to
+the functions that check them. These take the URL without the prefix and the target's labels for
+their options, as the URL's own query belongs to the endpoint: feed+https://example.com/feed.xml.
+*/
+var prefixCheckers = map[string]func(url string, labels map[string]string) string{
+	feedPrefix:       feedCheck,
+	graphqlPrefix:    graphqlCheck,
+	soapPrefix:       soapCheck,
+	throughputPrefix: throughputCheck,
+}
+
+// readCheckFile reads the file name in dir, the directory given by flagName,
+// refusing any name that is not that of a file in it: names come from labels,
+// which come from discovery sources.
+func readCheckFile(dir, flagName, name string) ([]byte, error) {
+	if dir == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("%q must name a file in %s", name, flagName)
+	}
+	return os.ReadFile(filepath.Join(dir, name))
+}
+
+// setHeaders sets on req the Name: value lines of the file name.headers in
+// dir, if name is not empty.
+func setHeaders(req *http.Request, dir, flagName, name string) error {
+	if name == "" {
+		return nil
+	}
+	b, err := readCheckFile(dir, flagName, name+".headers")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(k) != "" {
+			req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	return nil
+}
+
+// checkPassword gives u the password in the file its password_file parameter
+// names in -secret-dir, if it has one, and removes the parameter, so that the
+// password need not be in the target's URL at all.
+func checkPassword(u *url.URL) error {
+	q := u.Query()
+	name := q.Get("password_file")
+	if name == "" {
+		return nil
+	}
+	b, err := readCheckFile(*secretDir, "-secret-dir", name)
+	if err != nil {
+		return fmt.Errorf("password_file: %v", err)
+	}
+	q.Del("password_file")
+	u.RawQuery = q.Encode()
+	u.User = url.UserPassword(u.User.Username(), strings.TrimRight(string(b), "\r\n"))
+	return nil
+}
+
+// parseCheckURL parses the URL of a check target. Unlike url.Parse it takes
+// any authority, such as the port list of ports://db:5432,6432, leaving what
+// it means to the check.
+func parseCheckURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err == nil {
+		return u, nil
+	}
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return nil, err
+	}
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	u, err2 := url.Parse(scheme + "://host" + rest[end:])
+	if err2 != nil {
+		return nil, err
+	}
+	u.Host = rest[:end]
+	return u, nil
+}
+
+// checkOK and checkFail return the statuses of a passing and a failing check.
+func checkOK(format string, args ...interface{}) string {
+	return statusOK + " " + fmt.Sprintf(format, args...)
+}
+
+func checkFail(format string, args ...interface{}) string {
+	return statusFail + " " + fmt.Sprintf(format, args...)
+}
+
+// checkDeadline returns the context a check of u runs under: limited by its
+// timeout parameter, or checkTimeout.
+func checkDeadline(u *url.URL) (context.Context, context.CancelFunc, error) {
+	timeout := checkTimeout
+	if t := u.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, nil, fmt.Errorf("timeout: %v", err)
+		}
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return ctx, cancel, nil
+}
+
+// opaque returns what follows the scheme of u, whether written as
+// exec:name or exec://name.
+func opaque(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return strings.TrimPrefix(u.Host+u.Path, "/")
+}
+
+// dialCheck connects to the host of u, defaulting to port, over TLS when
+// useTLS is set, with the connection's deadline that of ctx. It connects from
+// the source parameter of u, or -source, and through its proxy.
+func dialCheck(ctx context.Context, u *url.URL, port string, useTLS bool) (net.Conn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	d, err := checkDialer("tcp", u)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if useTLS {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+/*
+execCheck runs a health script, the way existing shell checks are run by cron or Nagios:
+exec:name?arg=a&arg=b runs the script name from -check-dir with arguments a and b. As targets can
+come from any discovery source, the name may only be that of a file in -check-dir. Exit status 0
+means up and any other down; the first line of the output describes the result either way.
+*/
+func execCheck(u *url.URL) string {
+	name := opaque(u)
+	if *checkDir == "" || name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return checkFail("exec: %q must name a script in -check-dir", name)
+	}
+	ctx, cancel, err := checkDeadline(u)
+	if err != nil {
+		return checkFail("exec: %v", err)
+	}
+	defer cancel()
+	cmd := exec.CommandContext(ctx, filepath.Join(*checkDir, name), u.Query()["arg"]...)
+	cmd.WaitDelay = time.Second // for children still holding the output open
+	out, err := cmd.CombinedOutput()
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	var exit *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return checkFail("exec: timed out")
+	case errors.As(err, &exit):
+		if line == "" {
+			return checkFail("exit %d", exit.ExitCode())
+		}
+		return checkFail("exit %d: %s", exit.ExitCode(), line)
+	case err != nil:
+		return checkFail("exec: %v", err)
+	}
+	if line == "" {
+		return statusOK
+	}
+	return checkOK("%s", line)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"example/concurrent/chanutil"
+)
+
+// cloudWatchFlush is how often CloudWatchSink sends what it has collected, on
+// the minute.
+const cloudWatchFlush = 60 * time.Second
+
+/*
+CloudWatchSink publishes two metrics per poll to CloudWatch under namespace, with the target URL,
+as rendered by policy, as the only dimension:
+Availability, 1 when the poll found the target up and 0 otherwise, and Latency in milliseconds.
+Alarms on the Average of Availability give an uptime ratio per period.
+It returns the channel on which it wants to hear about state changes; pass it to StateMonitor
+as a listener.
+Samples are collected with their own timestamps and sent every interval on a separate goroutine,
+so a slow or unreachable CloudWatch never holds up the StateMonitor.
+*/
+func CloudWatchSink(aws *awsClient, namespace string, policy labelPolicy, interval time.Duration) chan<- State {
+	states := make(chan State)
+	ticker := chanutil.NewAlignedTicker(interval)
+	go func() {
+		var data []cloudWatchDatum
+		for {
+			select {
+			case s := <-states:
+				if s.result.Paused() {
+					continue
+				}
+				now := time.Now()
+				dims := map[string]string{"URL": policy.url(s.url)}
+				up := 0.0
+				if s.result.Up {
+					up = 1
+				}
+				data = append(data,
+					cloudWatchDatum{name: "Availability", dimensions: dims, value: up, unit: "None", timestamp: now},
+					cloudWatchDatum{name: "Latency", dimensions: dims, value: float64(s.result.Latency) / float64(time.Millisecond), unit: "Milliseconds", timestamp: now},
+				)
+			case <-ticker.C:
+				if len(data) == 0 {
+					continue
+				}
+				batch := data
+				data = nil
+				go func() {
+					if err := aws.putMetricData(namespace, batch); err != nil {
+						logger.Println("Error", "cloudwatch", err)
+					}
+				}()
+			}
+		}
+	}()
+	return states
+}
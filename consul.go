@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service meta keys that tune how a Consul service instance is polled.
+const (
+	consulMetaPath    = "sharemem_poll_path"    // default: /
+	consulMetaScheme  = "sharemem_poll_scheme"  // default: http
+	consulMetaNotes   = "sharemem_poll_notes"   // free-form notes carried to alerts
+	consulMetaRunbook = "sharemem_poll_runbook" // runbook URL carried to alerts
+)
+
+// consulEntry is the part of a /v1/health/service entry the discovery reads.
+type consulEntry struct {
+	Node struct {
+		Node       string
+		Address    string
+		Datacenter string
+	}
+	Service struct {
+		Service string
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+	}
+}
+
+// consulClient queries a Consul agent's HTTP API.
+type consulClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newConsulClient(addr string) *consulClient {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &consulClient{
+		base:   strings.TrimSuffix(addr, "/"),
+		token:  os.Getenv("CONSUL_HTTP_TOKEN"),
+		client: &http.Client{Timeout: errTimeout},
+	}
+}
+
+func (c *consulClient) get(path string, query url.Values, out interface{}) error {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul: %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// targets returns one Target per passing instance of every service carrying
+// all of tags.
+func (c *consulClient) targets(tags []string) ([]Target, error) {
+	var services map[string][]string
+	if err := c.get("/v1/catalog/services", nil, &services); err != nil {
+		return nil, err
+	}
+	var ts []Target
+	for name, stags := range services {
+		if !hasTags(stags, tags) {
+			continue
+		}
+		var entries []consulEntry
+		if err := c.get("/v1/health/service/"+url.PathEscape(name), url.Values{"passing": {"1"}}, &entries); err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			// Instances may carry fewer tags than the service as a whole.
+			if !hasTags(e.Service.Tags, tags) {
+				continue
+			}
+			addr := e.Service.Address
+			if addr == "" {
+				addr = e.Node.Address
+			}
+			scheme := e.Service.Meta[consulMetaScheme]
+			if scheme == "" {
+				scheme = "http"
+			}
+			path := e.Service.Meta[consulMetaPath]
+			if path == "" {
+				path = "/"
+			}
+			ts = append(ts, Target{
+				url: scheme + "://" + net.JoinHostPort(addr, strconv.Itoa(e.Service.Port)) + joinPath(path),
+				labels: map[string]string{
+					"service":    e.Service.Service,
+					"node":       e.Node.Node,
+					"datacenter": e.Node.Datacenter,
+				},
+				notes:   e.Service.Meta[consulMetaNotes],
+				runbook: e.Service.Meta[consulMetaRunbook],
+			})
+		}
+	}
+	return sortTargets(ts), nil
+}
+
+func hasTags(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ConsulDiscovery refreshes the Scheduler's "consul" targets from the Consul
+// agent at addr every interval. Only passing instances of services tagged with
+// every one of tags are polled. When Consul cannot be reached the previous
+// targets are kept, so a Consul outage does not look like every service going
+// away.
+func ConsulDiscovery(addr string, tags []string, interval time.Duration, targets chan<- TargetUpdate) {
+	c := newConsulClient(addr)
+	go func() {
+		for {
+			ts, err := c.targets(tags)
+			if err != nil {
+				logger.Println("Error", c.base, err)
+			} else {
+				targets <- TargetUpdate{source: "consul", targets: ts}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// splitList splits a comma-separated flag value, dropping empty elements.
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
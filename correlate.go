@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// correlationKeys returns the groups a target belongs to for correlation: its
+// host, its DNS domain (the host less its first label) and its value for each
+// of labels.
+func correlationKeys(s State, labels []string) []string {
+	var keys []string
+	if u, err := url.Parse(s.url); err == nil && u.Hostname() != "" {
+		host := u.Hostname()
+		keys = append(keys, "host "+host)
+		if net.ParseIP(host) == nil {
+			if i := strings.IndexByte(host, '.'); i >= 0 && strings.Contains(host[i+1:], ".") {
+				keys = append(keys, "domain "+host[i+1:])
+			}
+		}
+	}
+	for _, l := range labels {
+		if v, ok := s.target.labels[l]; ok {
+			keys = append(keys, "label "+l+"="+v)
+		}
+	}
+	return keys
+}
+
+/*
+Correlator sits between the Alerter and the alerting sinks and folds together targets that go
+down at about the same time for a common reason.
+The first DOWN opens a window of the given length, during which every event is held back. When
+it closes, any host, DNS domain or value of one of labels shared by at least minTargets of the
+targets that went down becomes a single DOWN event listing all of them, sent in place of the
+first; the rest of the events are sent on as they came. Correlated events are logged like the
+Alerter's. The biggest groups are formed first, and a target is only ever in one.
+It returns the channel on which it wants to hear about events; pass it to the Alerter. A window
+of 0 passes every event straight through.
+*/
+func Correlator(window time.Duration, minTargets int, labels []string, listeners ...chan<- Event) chan<- Event {
+	events := make(chan Event)
+	send := func(e Event) {
+		for _, l := range listeners {
+			l <- e
+		}
+	}
+	go func() {
+		var held []Event
+		var closed <-chan time.Time
+		for {
+			select {
+			case e := <-events:
+				if window == 0 {
+					send(e)
+					continue
+				}
+				if closed == nil {
+					if e.kind != eventDown {
+						send(e)
+						continue
+					}
+					closed = time.After(window)
+				}
+				held = append(held, e)
+			case <-closed:
+				for _, e := range correlate(held, minTargets, labels) {
+					if e.group != "" {
+						logger.Printf("Event %s %s: %s", e.kind, e.group, strings.ReplaceAll(e.text(), "\n", "; "))
+					}
+					send(e)
+				}
+				held, closed = nil, nil
+			}
+		}
+	}()
+	return events
+}
+
+// correlate replaces the DOWN events among held that share a group of at
+// least minTargets targets with one event per group.
+func correlate(held []Event, minTargets int, labels []string) []Event {
+	members := make(map[string][]int) // group key to the indexes of its DOWN events
+	for i, e := range held {
+		if e.kind != eventDown {
+			continue
+		}
+		for _, k := range correlationKeys(e.state, labels) {
+			members[k] = append(members[k], i)
+		}
+	}
+	keys := make([]string, 0, len(members))
+	for k := range members {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(members[keys[i]]) != len(members[keys[j]]) {
+			return len(members[keys[i]]) > len(members[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	grouped := make(map[int]string) // index to the group it went into
+	for _, k := range keys {
+		var free []int
+		for _, i := range members[k] {
+			if _, ok := grouped[i]; !ok {
+				free = append(free, i)
+			}
+		}
+		if len(free) < minTargets {
+			continue
+		}
+		for _, i := range free {
+			grouped[i] = k
+		}
+	}
+
+	var out []Event
+	for i, e := range held {
+		k, ok := grouped[i]
+		if !ok {
+			out = append(out, e)
+			continue
+		}
+		if e.group != "" {
+			continue // already folded into an earlier event of its group
+		}
+		c := Event{kind: eventDown, state: e.state, time: e.time, group: k}
+		for j := i; j < len(held); j++ {
+			if grouped[j] == k {
+				c.related = append(c.related, held[j].state)
+				held[j].group = k
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// correlatedText lists the targets of a correlated event.
+func correlatedText(e Event) string {
+	what := "went down together"
+	if e.kind == eventRecovered {
+		what = "that went down together are back up"
+	}
+	lines := []string{fmt.Sprintf("%d targets %s (%s):", len(e.related), what, e.group)}
+	for _, s := range e.related {
+		lines = append(lines, "- "+s.url+": "+s.result.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"example/concurrent/chanutil"
+	"golang.org/x/net/html"
+)
+
+// crawlMaxBody bounds how much of a page is read for its links.
+const crawlMaxBody = 5 << 20
+
+// crawlLink is a URL found by the crawler, with how many links away from the
+// seed it is and the first page seen linking to it.
+type crawlLink struct {
+	url   string
+	depth int
+	from  string
+}
+
+// crawlResult is what checking one link found.
+type crawlResult struct {
+	link   crawlLink
+	status string
+	broken bool
+	links  []string // the links on the page, if it was a page to follow
+}
+
+/*
+crawlCommand implements the crawl subcommand, a one-off broken-link check: it fetches the seed
+page, follows the links on it and on the pages it leads to, up to -depth links away on the seed's
+host, and checks every link it finds once, reporting the broken ones together at the end. Links
+to other hosts are checked but not followed. Links are checked by a chanutil.Pool of -workers,
+as targets are polled, fed no faster than -rate a second by chanutil.Throttle; -max-pages bounds
+the whole crawl. It returns the process exit code, 1 if any link is broken.
+*/
+func crawlCommand(args []string) int {
+	fs := flag.NewFlagSet("crawl", flag.ContinueOnError)
+	depth := fs.Int("depth", 2, "follow links up to this many `links` away from the seed")
+	maxPages := fs.Int("max-pages", 500, "check at most this many links in all")
+	workers := fs.Int("workers", numPollers, "check this many links at a time")
+	rate := fs.Float64("rate", 5, "check at most this many links a second")
+	external := fs.Bool("external", true, "also check links to other hosts, without following them")
+	ignoreRobots := fs.Bool("ignore-robots", false, "check links robots.txt disallows, and faster than its Crawl-delay; only for sites you run")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s crawl [-depth n] [-max-pages n] [-workers n] [-rate n] [-external=false] [-ignore-robots] <url>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	seed, err := url.Parse(fs.Arg(0))
+	if err != nil || (seed.Scheme != "http" && seed.Scheme != "https") {
+		fmt.Fprintf(os.Stderr, "crawl: %q is not an http or https URL\n", fs.Arg(0))
+		return 1
+	}
+
+	client := &http.Client{Timeout: errTimeout}
+	results, disallowed := crawl(client, seed, *depth, *maxPages, *workers, *rate, *external, *ignoreRobots)
+	var broken []crawlResult
+	for _, r := range results {
+		if r.broken {
+			broken = append(broken, r)
+		}
+	}
+	sort.Slice(broken, func(i, j int) bool { return broken[i].link.url < broken[j].link.url })
+	fmt.Printf("%d links checked, %d broken", len(results), len(broken))
+	if disallowed > 0 {
+		fmt.Printf(", %d not checked as robots.txt disallows them", disallowed)
+	}
+	fmt.Println()
+	for _, r := range broken {
+		fmt.Printf("%s %s (linked from %s)\n", r.status, r.link.url, r.link.from)
+	}
+	if len(broken) > 0 {
+		return 1
+	}
+	return 0
+}
+
+/*
+crawl checks seed and the links reachable from it, one depth at a time, and returns what it found
+for each. Unless ignoreRobots, it leaves out the links the robots.txt of their host disallows,
+returning how many, and checks no faster than the seed host's Crawl-delay.
+*/
+func crawl(client *http.Client, seed *url.URL, depth, maxPages, workers int, rate float64, external, ignoreRobots bool) (results []crawlResult, disallowed int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	robots := make(map[string]*robotsRules)
+	allowed := func(u *url.URL) bool {
+		if ignoreRobots {
+			return true
+		}
+		rules, ok := robots[u.Host]
+		if !ok {
+			rules = fetchRobots(client, u)
+			robots[u.Host] = rules
+		}
+		return rules.allowed(u)
+	}
+	if !allowed(seed) {
+		return nil, 1
+	}
+	if rules := robots[seed.Host]; rules != nil && rules.delay > 0 && float64(time.Second)/float64(rules.delay) < rate {
+		rate, workers = float64(time.Second)/float64(rules.delay), 1
+	}
+	seen := map[string]bool{seed.String(): true}
+	level := []crawlLink{{url: seed.String()}}
+	for len(level) > 0 {
+		links := chanutil.Throttle(chanutil.FromSlice(ctx, level), rate, workers)
+		pool := chanutil.NewPool(ctx, links, workers, func(ctx context.Context, l crawlLink) (crawlResult, error) {
+			return checkLink(ctx, client, l, seed.Host, depth), nil
+		})
+		var next []crawlLink
+		for r := range pool.Out() {
+			results = append(results, r)
+			for _, found := range r.links {
+				if seen[found] || len(seen) >= maxPages {
+					continue
+				}
+				u, _ := url.Parse(found)
+				if u.Host != seed.Host && !external {
+					continue
+				}
+				seen[found] = true
+				if !allowed(u) {
+					disallowed++
+					continue
+				}
+				next = append(next, crawlLink{url: found, depth: r.link.depth + 1, from: r.link.url})
+			}
+		}
+		pool.Wait()
+		level = next
+	}
+	return results, disallowed
+}
+
+// checkLink checks one link: pages on host less than depth links from the
+// seed are fetched for their links, and anything else only checked, with a
+// HEAD unless the server will not answer one.
+func checkLink(ctx context.Context, client *http.Client, l crawlLink, host string, depth int) crawlResult {
+	r := crawlResult{link: l}
+	u, _ := url.Parse(l.url)
+	follow := u.Host == host && l.depth < depth
+	method := http.MethodHead
+	if follow {
+		method = http.MethodGet
+	}
+	resp, err := crawlRequest(ctx, client, method, l.url)
+	if err == nil && !follow && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = crawlRequest(ctx, client, http.MethodGet, l.url)
+	}
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err // the URL is reported anyway
+		}
+		r.status, r.broken = err.Error(), true
+		return r
+	}
+	defer resp.Body.Close()
+	r.status, r.broken = resp.Status, resp.StatusCode >= 400
+	if follow && !r.broken && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		r.links = pageLinks(resp.Request.URL, io.LimitReader(resp.Body, crawlMaxBody))
+	}
+	return r
+}
+
+func crawlRequest(ctx context.Context, client *http.Client, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", crawlerAgent)
+	return client.Do(req)
+}
+
+// linkAttrs are the attributes of the elements whose links are checked.
+var linkAttrs = map[string]string{"a": "href", "link": "href", "img": "src", "script": "src", "iframe": "src"}
+
+// pageLinks returns the http and https links of the HTML page at base,
+// resolved and without fragments, each once.
+func pageLinks(base *url.URL, body io.Reader) []string {
+	var links []string
+	seen := make(map[string]bool)
+	z := html.NewTokenizer(body)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			want := linkAttrs[string(name)]
+			for hasAttr && want != "" {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				if string(k) != want {
+					continue
+				}
+				ref, err := base.Parse(strings.TrimSpace(string(v)))
+				if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+					continue
+				}
+				ref.Fragment = ""
+				if s := ref.String(); !seen[s] {
+					seen[s] = true
+					links = append(links, s)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"example/concurrent/chanutil"
+)
+
+// datadogFlush is how often DatadogSink sends the metrics it has collected, on
+// the minute.
+const datadogFlush = 60 * time.Second
+
+// datadogClient posts to the Datadog v1 API.
+type datadogClient struct {
+	base   string
+	apiKey string
+	client *http.Client
+}
+
+func newDatadogClient(site string) (*datadogClient, error) {
+	key := os.Getenv("DD_API_KEY")
+	if key == "" {
+		return nil, errors.New("datadog: DD_API_KEY is not set")
+	}
+	return &datadogClient{base: "https://api." + site, apiKey: key, client: &http.Client{Timeout: errTimeout}}, nil
+}
+
+func (c *datadogClient) post(path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.base+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", c.apiKey)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("datadog: %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+type datadogSeries struct {
+	Metric string       `json:"metric"`
+	Points [][2]float64 `json:"points"`
+	Type   string       `json:"type"`
+	Tags   []string     `json:"tags"`
+}
+
+type datadogEvent struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	AlertType      string   `json:"alert_type"`
+	AggregationKey string   `json:"aggregation_key"`
+	SourceTypeName string   `json:"source_type_name"`
+	Tags           []string `json:"tags"`
+}
+
+// datadogTags renders the labels policy exports for s as Datadog tags.
+func datadogTags(policy labelPolicy, s State) []string {
+	var tags []string
+	for k, v := range policy.labels(s) {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// datadogAggregationKey is the same for every event about one URL, so a
+// target's down and recovery events roll up together and a monitor can match
+// on it. Datadog caps keys at 100 characters, hence the hash.
+func datadogAggregationKey(url string) string {
+	h := sha1.Sum([]byte(url))
+	return "sharemem-poll-" + hex.EncodeToString(h[:8])
+}
+
+/*
+DatadogSink sends two gauges per poll to Datadog, sharemem.poll.up (1 or 0) and
+sharemem.poll.latency (milliseconds), tagged with the labels chosen by policy, and posts every
+alert Event.
+It returns the channel on which it wants to hear about state changes, to be passed to
+StateMonitor as a listener, and the one on which it wants events, to be passed to the Alerter.
+Metrics are sent every interval and events as they happen, each on its own goroutine, so
+Datadog being slow never holds up the StateMonitor.
+*/
+func DatadogSink(dd *datadogClient, policy labelPolicy, interval time.Duration) (chan<- State, chan<- Event) {
+	states := make(chan State)
+	events := make(chan Event)
+	ticker := chanutil.NewAlignedTicker(interval)
+	go func() {
+		var series []datadogSeries
+		for {
+			select {
+			case s := <-states:
+				// Paused targets are not measured.
+				if s.result.Paused() {
+					continue
+				}
+				now := float64(time.Now().Unix())
+				isUp := s.result.Up
+				v := 0.0
+				if isUp {
+					v = 1
+				}
+				tags := datadogTags(policy, s)
+				series = append(series,
+					datadogSeries{Metric: "sharemem.poll.up", Points: [][2]float64{{now, v}}, Type: "gauge", Tags: tags},
+					datadogSeries{Metric: "sharemem.poll.latency", Points: [][2]float64{{now, float64(s.result.Latency) / float64(time.Millisecond)}}, Type: "gauge", Tags: tags},
+				)
+			case ev := <-events:
+				e := datadogEvent{
+					Title:          ev.title(),
+					Text:           ev.text(),
+					AlertType:      datadogAlertTypes[ev.kind],
+					AggregationKey: datadogAggregationKey(ev.key()),
+					SourceTypeName: "sharemem.poll",
+					Tags:           datadogTags(policy, ev.state),
+				}
+				go func() {
+					if err := dd.post("/api/v1/events", e); err != nil {
+						logger.Println("Error", "datadog", err)
+					}
+				}()
+			case <-ticker.C:
+				if len(series) == 0 {
+					continue
+				}
+				batch := series
+				series = nil
+				go func() {
+					if err := dd.post("/api/v1/series", map[string]interface{}{"series": batch}); err != nil {
+						logger.Println("Error", "datadog", err)
+					}
+				}()
+			}
+		}
+	}()
+	return states, events
+}
+
+var datadogAlertTypes = map[string]string{
+	eventDown:      "error",
+	eventDegrading: "warning",
+	eventRecovered: "success",
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"example/concurrent/chanutil"
+)
+
+// debugChannels is what /debug/channels serves: the state of the channels
+// between the stages, as /metrics has it, in one document.
+type debugChannels struct {
+	Goroutines     int                  `json:"goroutines"`
+	PendingWaiting int                  `json:"pending_waiting"`
+	PollsInFlight  int                  `json:"polls_in_flight"`
+	Queue          *chanutil.QueueStats `json:"queue,omitempty"`
+	Dropped        map[string]uint64    `json:"dropped,omitempty"`
+}
+
+/*
+debugHandler serves, under /debug/, what it takes to diagnose a hung or overloaded poller in
+place: the net/http/pprof profiles at /debug/pprof/, the stacks of every goroutine at
+/debug/goroutines, which show who is waiting on which channel as the watchdog's dump does, and the
+channel numbers at /debug/channels. main mounts it only with -debug, behind adminOnly.
+*/
+func debugHandler(q *chanutil.Queue[*Resource], drops []dropCounter, s *SchedulerStats) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(goroutineDump())
+	})
+	mux.HandleFunc("/debug/channels", func(w http.ResponseWriter, r *http.Request) {
+		snap := s.snapshot()
+		d := debugChannels{Goroutines: runtime.NumGoroutine(), PendingWaiting: snap.waiting, PollsInFlight: snap.inFlight}
+		if q != nil {
+			st := q.Stats()
+			d.Queue = &st
+		}
+		if len(drops) > 0 {
+			d.Dropped = make(map[string]uint64)
+			for _, c := range drops {
+				d.Dropped[c.channel] = c.dropped()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	})
+	return mux
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// diskUsage is what statfs says of a filesystem.
+type diskUsage struct {
+	total, free uint64 // bytes; free counts only what unprivileged users may use
+	inodes      uint64
+	inodesFree  uint64
+}
+
+/*
+diskCheck reports how full the filesystem holding a path is, so the poller covers basic host
+health too: disk:///var?warn=80&crit=90 is down once /var is crit percent full, of its space or of
+its inodes (inode-warn and inode-crit, by default the same as warn and crit). Past warn it stays
+up, with a warning in its status. The defaults are 80 and 90.
+*/
+func diskCheck(u *url.URL) string {
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	q := u.Query()
+	var errs []string
+	threshold := func(name string, def float64) float64 {
+		s := q.Get(name)
+		if s == "" {
+			return def
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			errs = append(errs, name+": "+err.Error())
+		}
+		return f
+	}
+	warn, crit := threshold("warn", 80), threshold("crit", 90)
+	inodeWarn, inodeCrit := threshold("inode-warn", warn), threshold("inode-crit", crit)
+	if len(errs) > 0 {
+		return checkFail("disk: %s", errs[0])
+	}
+	d, err := statDisk(path)
+	if err != nil {
+		return checkFail("disk: %v", err)
+	}
+	used := percentUsed(d.total, d.free)
+	inodes := percentUsed(d.inodes, d.inodesFree)
+	report := fmt.Sprintf("%s %.0f%% full (%s free), %.0f%% of inodes used", path, used, byteSize(d.free), inodes)
+	switch {
+	case used >= crit || inodes >= inodeCrit:
+		return checkFail("%s", report)
+	case used >= warn || inodes >= inodeWarn:
+		return checkOK("warning: %s", report)
+	}
+	return checkOK("%s", report)
+}
+
+func percentUsed(total, free uint64) float64 {
+	if total == 0 {
+		return 0 // filesystems without inode limits report none
+	}
+	return 100 * float64(total-free) / float64(total)
+}
+
+// byteSize renders n bytes in the largest binary unit that keeps it at least 1.
+func byteSize(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
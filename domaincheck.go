@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rdapBootstrap is IANA's registry of the RDAP servers of each top-level domain.
+const rdapBootstrap = "https://data.iana.org/rdap/dns.json"
+
+// rdapServers and registrations keep the IANA registry for a day and what a
+// domain's registry said for six hours: expiry dates move rarely, and RDAP
+// servers limit how often they may be asked.
+var (
+	rdapServers   = newExpiringCache[map[string]string]()
+	registrations = newExpiringCache[time.Time]()
+)
+
+/*
+domainCheck reports how many days are left before a domain's registration expires, as its
+registry's RDAP server has it: domain:example.com?warn=30&crit=7 is down with crit (7) days or
+fewer left, and warns in its status with warn (30) or fewer, leaving time to renew well before the
+certificates and mail go too. Check the registered domain itself; registries know nothing of the
+names under it.
+*/
+func domainCheck(u *url.URL) string {
+	name := strings.ToLower(strings.TrimSuffix(opaque(u), "."))
+	if !strings.Contains(name, ".") {
+		return checkFail("domain: %q is not a domain name", name)
+	}
+	threshold := func(key string, def int) int {
+		if n, err := strconv.Atoi(u.Query().Get(key)); err == nil {
+			return n
+		}
+		return def
+	}
+	warn, crit := threshold("warn", 30), threshold("crit", 7)
+	expires, err := registrations.fetch(name, func() (time.Time, time.Time, error) {
+		t, err := rdapExpiry(name)
+		return t, time.Now().Add(6 * time.Hour), err
+	})
+	if err != nil {
+		return checkFail("domain: %s: %v", name, err)
+	}
+	days := int(time.Until(expires).Hours() / 24)
+	report := fmt.Sprintf("%s: registration expires %s, in %d days", name, expires.Format(time.DateOnly), days)
+	switch {
+	case days <= crit:
+		return checkFail("%s", report)
+	case days <= warn:
+		return checkOK("warning: %s", report)
+	}
+	return checkOK("%s", report)
+}
+
+// rdapExpiry asks the RDAP server of the domain's top-level domain when its
+// registration expires.
+func rdapExpiry(name string) (time.Time, error) {
+	servers, err := rdapServers.fetch("", func() (map[string]string, time.Time, error) {
+		s, err := fetchRDAPServers()
+		return s, time.Now().Add(24 * time.Hour), err
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("RDAP bootstrap: %v", err)
+	}
+	server := servers[name[strings.LastIndex(name, ".")+1:]]
+	if server == "" {
+		return time.Time{}, errors.New("no RDAP server for its top-level domain")
+	}
+	var domain struct {
+		Events []struct {
+			Action string    `json:"eventAction"`
+			Date   time.Time `json:"eventDate"`
+		} `json:"events"`
+	}
+	if err := getRDAP(strings.TrimSuffix(server, "/")+"/domain/"+name, &domain); err != nil {
+		return time.Time{}, err
+	}
+	for _, e := range domain.Events {
+		if e.Action == "expiration" {
+			return e.Date, nil
+		}
+	}
+	return time.Time{}, errors.New("registry gives no expiration date")
+}
+
+// fetchRDAPServers fetches IANA's registry and maps each top-level domain to
+// its RDAP server, preferring https.
+func fetchRDAPServers() (map[string]string, error) {
+	var bootstrap struct {
+		Services [][][]string `json:"services"`
+	}
+	if err := getRDAP(rdapBootstrap, &bootstrap); err != nil {
+		return nil, err
+	}
+	servers := make(map[string]string)
+	for _, s := range bootstrap.Services {
+		if len(s) < 2 || len(s[1]) == 0 {
+			continue
+		}
+		server := s[1][0]
+		for _, u := range s[1] {
+			if strings.HasPrefix(u, "https://") {
+				server = u
+				break
+			}
+		}
+		for _, tld := range s[0] {
+			servers[strings.ToLower(tld)] = server
+		}
+	}
+	return servers, nil
+}
+
+// getRDAP GETs url and decodes its JSON answer into out.
+func getRDAP(url string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+	resp, err := (&http.Client{Timeout: checkTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
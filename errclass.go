@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+)
+
+// errorClass is the kind of failure a poll ran into, which decides what to do
+// about it: a timeout wants a look at load or the network path, a refused
+// connection at the service, a DNS failure at the zone.
+type errorClass string
+
+// Classes of failure, by how far a poll got.
+const (
+	classDNS       errorClass = "dns"       // the name did not resolve
+	classConnect   errorClass = "connect"   // no TCP connection, or it broke
+	classTLS       errorClass = "tls"       // handshake or certificate failure
+	classTimeout   errorClass = "timeout"   // no answer in time
+	classHTTP      errorClass = "http"      // an HTTP status of 400 or more
+	classAssertion errorClass = "assertion" // an answer, but not the one wanted
+	classOther     errorClass = "other"
+)
+
+// classify returns the class of err, the failure of a request that got no
+// answer, by its type or else by its text.
+func classify(err error) errorClass {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &dnsErr):
+		return classDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return classTimeout
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return classTLS
+	case errors.As(err, &opErr):
+		return classConnect
+	}
+	return classifyText(err.Error(), classOther)
+}
+
+// classifyText returns the class of a failure known only by its description,
+// as checks and snapshots give them, or def when nothing in it tells.
+func classifyText(s string, def errorClass) errorClass {
+	s = strings.ToLower(s)
+	has := func(subs ...string) bool {
+		for _, sub := range subs {
+			if strings.Contains(s, sub) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("no such host", "server misbehaving", "lookup "):
+		return classDNS
+	case has("timeout", "timed out", "deadline exceeded"):
+		return classTimeout
+	case has("x509:", "tls:", "certificate"):
+		return classTLS
+	case has("refused", "unreachable", "no route to host", "connection reset", "broken pipe", "dial "):
+		return classConnect
+	}
+	return def
+}
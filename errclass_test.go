@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want errorClass
+	}{
+		{&net.DNSError{Err: "no such host", Name: "x.invalid", IsNotFound: true}, classDNS},
+		{fmt.Errorf("get: %w", &net.DNSError{Err: "server misbehaving", Name: "x"}), classDNS},
+		{context.DeadlineExceeded, classTimeout},
+		{&net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, classTimeout},
+		{x509.UnknownAuthorityError{}, classTLS},
+		{x509.HostnameError{Certificate: &x509.Certificate{}, Host: "x"}, classTLS},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, classConnect},
+		{errors.New("remote error: tls: handshake failure"), classTLS},
+		{errors.New("read: connection reset by peer"), classConnect},
+		{errors.New("unexpected EOF"), classOther},
+	} {
+		if got := classify(tc.err); got != tc.want {
+			t.Errorf("classify(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// escalationConfig is the file read by -escalation-policies, for example
+//
+//	{"policies": [{
+//	  "name": "production",
+//	  "match": ["env=prod"],
+//	  "steps": [
+//	    {"after": "0s", "notify": "webhook", "url": "https://hooks.internal/alerts"},
+//	    {"after": "5m", "notify": "slack", "url": "https://hooks.slack.com/services/…"},
+//	    {"after": "15m", "notify": "pagerduty", "routing_key": "…"}
+//	  ]
+//	}]}
+//
+// match takes the matchers of silences; a policy without any matches every
+// target, and the first policy to match a target applies to it.
+type escalationConfig struct {
+	Policies []struct {
+		Name  string   `json:"name"`
+		Match []string `json:"match"`
+		Steps []struct {
+			After      string `json:"after"`
+			Notify     string `json:"notify"`
+			URL        string `json:"url"`
+			RoutingKey string `json:"routing_key"`
+		} `json:"steps"`
+	} `json:"policies"`
+}
+
+type escalationPolicy struct {
+	name     string
+	matchers []matcher
+	steps    []escalationStep
+}
+
+type escalationStep struct {
+	after time.Duration // how long the target has to be down before this step
+	to    notifier
+}
+
+func (p escalationPolicy) applies(t Target) bool {
+	for _, m := range p.matchers {
+		if !m.matches(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func loadEscalationPolicies(path string) ([]escalationPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c escalationConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var policies []escalationPolicy
+	for i, cp := range c.Policies {
+		p := escalationPolicy{name: cp.Name}
+		if p.name == "" {
+			p.name = fmt.Sprint("#", i+1)
+		}
+		for _, s := range cp.Match {
+			m, err := parseMatcher(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: policy %s: %v", path, p.name, err)
+			}
+			p.matchers = append(p.matchers, m)
+		}
+		var last time.Duration
+		for j, cs := range cp.Steps {
+			var after time.Duration
+			if cs.After != "" {
+				if after, err = time.ParseDuration(cs.After); err != nil {
+					return nil, fmt.Errorf("%s: policy %s step %d: %v", path, p.name, j+1, err)
+				}
+			}
+			if after < last {
+				return nil, fmt.Errorf("%s: policy %s step %d: steps must be in order of after", path, p.name, j+1)
+			}
+			last = after
+			n := notifier{kind: cs.Notify, url: cs.URL, routingKey: cs.RoutingKey}
+			switch {
+			case n.kind == "pagerduty" && n.routingKey == "":
+				return nil, fmt.Errorf("%s: policy %s step %d: pagerduty needs a routing_key", path, p.name, j+1)
+			case (n.kind == "webhook" || n.kind == "slack") && n.url == "":
+				return nil, fmt.Errorf("%s: policy %s step %d: %s needs a url", path, p.name, j+1, n.kind)
+			case n.kind != "webhook" && n.kind != "slack" && n.kind != "pagerduty":
+				return nil, fmt.Errorf("%s: policy %s step %d: unknown notify %q", path, p.name, j+1, n.kind)
+			}
+			p.steps = append(p.steps, escalationStep{after: after, to: n})
+		}
+		if len(p.steps) == 0 {
+			return nil, fmt.Errorf("%s: policy %s has no steps", path, p.name)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// outage is an escalation in progress.
+type outage struct {
+	policy *escalationPolicy
+	event  Event           // the DOWN that started it
+	down   map[string]bool // its targets still down
+	next   int             // the next step to take
+}
+
+/*
+Escalator notifies the steps of an escalation policy in turn for as long as an outage lasts:
+the first DOWN of a target, or correlated group, starts it, and each step is notified once the
+outage has lasted its after. When all the targets are back up, every step notified so far is
+told of the recovery. A DEGRADING event goes to the first step only. A target paused or retired
+while down, as live from WatchTargets says, no longer counts, and an outage left with none stops
+escalating without a recovery being sent.
+The policy is chosen by the first target of the event, among policies read from path.
+It returns the channel on which it wants to hear about events; it belongs behind the Silencer.
+Notifications are sent on their own goroutines so a slow destination never holds it up.
+*/
+func Escalator(path string, live <-chan map[string]bool) (chan<- Event, error) {
+	policies, err := loadEscalationPolicies(path)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event)
+	outages := make(map[string]*outage) // by event key
+	policyFor := func(t Target) *escalationPolicy {
+		for i := range policies {
+			if policies[i].applies(t) {
+				return &policies[i]
+			}
+		}
+		return nil
+	}
+	send := func(n notifier, e Event, step int) {
+		go func() {
+			if err := n.notify(e, step); err != nil {
+				logger.Println("Error", "escalation", err)
+			}
+		}()
+	}
+	escalate := func(o *outage, now time.Time) {
+		for o.next < len(o.policy.steps) && now.Sub(o.event.time) >= o.policy.steps[o.next].after {
+			step := o.policy.steps[o.next]
+			o.next++
+			logger.Printf("Escalating %s to step %d (%s) of policy %s", o.event.key(), o.next, step.to.kind, o.policy.name)
+			send(step.to, o.event, o.next)
+		}
+	}
+	ticker := time.NewTicker(10 * time.Second)
+	go func() {
+		for {
+			select {
+			case e := <-events:
+				switch e.kind {
+				case eventDown:
+					if _, ok := outages[e.key()]; ok {
+						continue
+					}
+					p := policyFor(e.state.target)
+					if p == nil {
+						continue
+					}
+					o := &outage{policy: p, event: e, down: map[string]bool{e.state.url: true}}
+					for _, s := range e.related {
+						o.down[s.url] = true
+					}
+					outages[e.key()] = o
+					escalate(o, e.time)
+				case eventRecovered:
+					for key, o := range outages {
+						if !o.down[e.state.url] {
+							continue
+						}
+						delete(o.down, e.state.url)
+						if len(o.down) > 0 {
+							continue
+						}
+						delete(outages, key)
+						r := e
+						r.group, r.related = o.event.group, o.event.related
+						for i := 0; i < o.next; i++ {
+							send(o.policy.steps[i].to, r, i+1)
+						}
+					}
+				case eventDegrading:
+					if p := policyFor(e.state.target); p != nil {
+						send(p.steps[0].to, e, 1)
+					}
+				}
+			case now := <-ticker.C:
+				for _, o := range outages {
+					escalate(o, now)
+				}
+			case targets := <-live:
+				for key, o := range outages {
+					for u := range o.down {
+						if withdrawn(targets, u) {
+							delete(o.down, u)
+						}
+					}
+					if len(o.down) == 0 {
+						logger.Printf("Escalation of %s stopped: its targets are paused or gone", key)
+						delete(outages, key)
+					}
+				}
+			}
+		}
+	}()
+	return events, nil
+}
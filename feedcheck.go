@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedPrefix marks a target that is an RSS or Atom feed, checked for being
+// valid and fresh, as in feed+https://upstream.example.com/releases.atom.
+const feedPrefix = "feed+"
+
+// feedMaxBytes bounds the size of a feed.
+const feedMaxBytes = 10 << 20
+
+// feedXML is an RSS 2.0, RSS 1.0 or Atom feed, whichever it turns out to be.
+type feedXML struct {
+	XMLName xml.Name
+	Channel struct {
+		Items []feedItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []feedItem `xml:"item"`  // RSS 1.0 items are outside the channel
+	Entries []feedItem `xml:"entry"` // Atom
+}
+
+type feedItem struct {
+	Title     string `xml:"title"`
+	PubDate   string `xml:"pubDate"`
+	Date      string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Updated   string `xml:"updated"`
+	Published string `xml:"published"`
+}
+
+// feedTimeLayouts are the ways feeds write dates: RFC 822 in RSS, in all its
+// variations, and RFC 3339 in Atom and Dublin Core.
+var feedTimeLayouts = []string{
+	time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700", time.RFC822Z, time.RFC822, time.RFC3339Nano, "2006-01-02T15:04Z07:00",
+}
+
+/*
+feedCheck fetches the feed at url, an RSS or Atom feed that ingestion pipelines read, and checks
+that it parses and has items. With a max-age label it also checks that the newest item is younger
+than that, as upstream feeds tend to go stale without anything failing. Its status gives the
+number of items and the age and title of the newest.
+*/
+func feedCheck(url string, labels map[string]string) string {
+	maxAge, err := time.ParseDuration(labels[labelMaxAge])
+	if err != nil && labels[labelMaxAge] != "" {
+		return checkFail("feed: %s: %v", labelMaxAge, err)
+	}
+	client, err := probeClient(labels)
+	if err != nil {
+		return checkFail("feed: %v", err)
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return checkFail("feed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return checkFail("feed: %s", resp.Status)
+	}
+	var feed feedXML
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, feedMaxBytes)).Decode(&feed); err != nil {
+		return checkFail("feed: does not parse: %v", err)
+	}
+	var items []feedItem
+	switch feed.XMLName.Local {
+	case "rss":
+		items = feed.Channel.Items
+	case "RDF":
+		items = feed.Items
+	case "feed":
+		items = feed.Entries
+	default:
+		return checkFail("feed: <%s> is neither RSS nor Atom", feed.XMLName.Local)
+	}
+	if len(items) == 0 {
+		return checkFail("feed: no items")
+	}
+	var newest time.Time
+	var title string
+	for _, it := range items {
+		if t, ok := it.time(); ok && t.After(newest) {
+			newest, title = t, strings.TrimSpace(it.Title)
+		}
+	}
+	if newest.IsZero() {
+		if maxAge > 0 {
+			return checkFail("feed: %s, none with a date", feedItems(len(items)))
+		}
+		return checkOK("%s", feedItems(len(items)))
+	}
+	age := time.Since(newest).Round(time.Second)
+	report := fmt.Sprintf("%s, newest %s ago: %q", feedItems(len(items)), age, title)
+	if maxAge > 0 && age > maxAge {
+		return checkFail("feed stale, more than %s: %s", maxAge, report)
+	}
+	return checkOK("%s", report)
+}
+
+func feedItems(n int) string {
+	if n == 1 {
+		return "1 item"
+	}
+	return strconv.Itoa(n) + " items"
+}
+
+// time returns when the item was published or last updated.
+func (it feedItem) time() (time.Time, bool) {
+	for _, s := range []string{it.Updated, it.Published, it.PubDate, it.Date} {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		for _, layout := range feedTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
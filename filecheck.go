@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+fileCheck watches a file that something else is meant to keep producing, such as the output a
+batch job drops on a share: file:///data/exports/daily.csv?max-age=26h passes while the file
+exists, is at least min-size bytes (default 1, i.e. not empty) and, given max-age, was modified
+that recently. A path with glob characters, /data/exports/*.csv, checks the newest match.
+The path may be on an NFS or SMB mount. As a hung mount can block the stat for ever, the check
+gives up after its timeout, leaving the stat to finish, or not, on its own.
+*/
+func fileCheck(u *url.URL) string {
+	q := u.Query()
+	var maxAge time.Duration
+	if s := q.Get("max-age"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return checkFail("file: max-age: %v", err)
+		}
+		maxAge = d
+	}
+	minSize := int64(1)
+	if s := q.Get("min-size"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return checkFail("file: min-size: %v", err)
+		}
+		minSize = n
+	}
+	ctx, cancel, err := checkDeadline(u)
+	if err != nil {
+		return checkFail("file: %v", err)
+	}
+	defer cancel()
+
+	result := make(chan string, 1)
+	go func() { result <- checkFile(u.Path, minSize, maxAge) }()
+	select {
+	case s := <-result:
+		return s
+	case <-ctx.Done():
+		return checkFail("file: %s: timed out; is the mount hung?", u.Path)
+	}
+}
+
+func checkFile(path string, minSize int64, maxAge time.Duration) string {
+	matches := []string{path}
+	if strings.ContainsAny(path, "*?[") {
+		var err error
+		if matches, err = filepath.Glob(path); err != nil {
+			return checkFail("file: %v", err)
+		}
+		if len(matches) == 0 {
+			return checkFail("no file matches %s", path)
+		}
+	}
+	var newest os.FileInfo
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			return checkFail("%v", err)
+		}
+		if newest == nil || fi.ModTime().After(newest.ModTime()) {
+			newest, path = fi, m
+		}
+	}
+	age := time.Since(newest.ModTime()).Round(time.Second)
+	switch {
+	case newest.IsDir():
+		return checkFail("%s is a directory", path)
+	case newest.Size() < minSize:
+		return checkFail("%s is %d bytes, under %d", path, newest.Size(), minSize)
+	case maxAge > 0 && age > maxAge:
+		return checkFail("%s was last modified %s ago, over %s", path, age, maxAge)
+	}
+	return checkOK("%s: %d bytes, modified %s ago", path, newest.Size(), age)
+}
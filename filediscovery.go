@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileSettle is how long FileDiscovery waits after the last change in the
+// directory before rescanning, so a sync tool rewriting many files causes one
+// update rather than dozens.
+const fileSettle = 250 * time.Millisecond
+
+/*
+FileDiscovery keeps the Scheduler's "files" targets in sync with the files in dir.
+Each file lists one target per line, optionally followed by space-separated key=value labels,
+a runbook link and, after a " #", notes for whoever gets paged:
+
+	http://checkout.internal/healthz team=shop env=prod runbook=https://wiki/checkout # card payments; page shop on-call
+
+Blank lines, lines starting with # and files whose names start with a dot are ignored.
+Every change in the directory triggers a rescan of the whole directory rather than of the single
+file named in the event: config-sync sidecars (and Kubernetes ConfigMap volumes) publish a new
+revision by swapping a symlink, which only a full rescan sees consistently.
+*/
+func FileDiscovery(dir string, targets chan<- TargetUpdate) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(dir); err != nil {
+		w.Close()
+		return err
+	}
+	go func() {
+		scan := func() {
+			ts, err := readTargetDir(dir)
+			if err != nil {
+				logger.Println("Error", dir, err)
+				return
+			}
+			targets <- TargetUpdate{source: "files", targets: ts}
+		}
+		scan()
+		settle := time.NewTimer(fileSettle)
+		settle.Stop()
+		for {
+			select {
+			case <-w.Events:
+				settle.Reset(fileSettle)
+			case err := <-w.Errors:
+				logger.Println("Error", dir, err)
+			case <-settle.C:
+				scan()
+			}
+		}
+	}()
+	return nil
+}
+
+// readTargetDir parses every target file in dir.
+func readTargetDir(dir string) ([]Target, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ts []Target
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		// Stat follows symlinks, which is how ConfigMap volumes expose files.
+		if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		fts, err := readTargetFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, fts...)
+	}
+	return sortTargets(ts), nil
+}
+
+// readTargetFile parses one target file, labelling each target with the
+// file's name.
+func readTargetFile(path string) ([]Target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ts []Target
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// URLs may carry #fragments, so notes need the space before them.
+		line, notes, _ := strings.Cut(line, " #")
+		fields := strings.Fields(line)
+		t := Target{url: fields[0], labels: map[string]string{"file": filepath.Base(path)}, notes: strings.TrimSpace(notes)}
+		for _, kv := range fields[1:] {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || k == "" {
+				logger.Printf("Error %s:%d: label %q is not key=value", path, n, kv)
+				continue
+			}
+			if k == "runbook" {
+				t.runbook = v
+				continue
+			}
+			t.labels[k] = v
+		}
+		ts = append(ts, t)
+	}
+	return ts, sc.Err()
+}
@@ -1,3 +1,20 @@
 module example/concurrent
 
-go 1.19
+go 1.23
+
+require (
+	github.com/antchfx/xmlquery v1.4.3
+	github.com/antchfx/xpath v1.3.3
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.33.0
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+)
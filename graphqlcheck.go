@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// graphqlPrefix marks a GraphQL endpoint, checked with a query rather than a
+// HEAD, as in graphql+https://api.example.com/graphql.
+const graphqlPrefix = "graphql+"
+
+// Target labels of graphql+ targets: the query to send and the headers to
+// send it with, both named files in -graphql-dir, and what the answer must hold.
+const (
+	labelQuery   = "query"
+	labelHeaders = "headers"
+	labelExpect  = "expect"
+)
+
+// graphqlDefaultQuery is sent to targets without a query label; any working
+// endpoint answers it.
+const graphqlDefaultQuery = "{ __typename }"
+
+/*
+graphqlCheck checks a GraphQL endpoint by posting a query to it: the file query.graphql in
+-graphql-dir for a target labelled query=name, or else { __typename }. It is down if the answer
+has errors, and, with expect=viewer.login,status.db=ok, unless each dotted path leads to a value in
+data, or to that value. Auth headers are per target: headers=name sends the Name: value lines of
+name.headers in -graphql-dir. Queries and secrets stay in files rather than labels, which end up
+in metrics and alerts, and as targets can come from any discovery source, labels may only name
+files in -graphql-dir.
+*/
+func graphqlCheck(endpoint string, labels map[string]string) string {
+	query := graphqlDefaultQuery
+	if name := labels[labelQuery]; name != "" {
+		b, err := readCheckFile(*graphqlDir, "-graphql-dir", name+".graphql")
+		if err != nil {
+			return checkFail("graphql: %v", err)
+		}
+		query = string(b)
+	}
+	body, _ := json.Marshal(map[string]string{"query": query})
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return checkFail("graphql: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if err := setHeaders(req, *graphqlDir, "-graphql-dir", labels[labelHeaders]); err != nil {
+		return checkFail("graphql: %v", err)
+	}
+	client, err := probeClient(labels)
+	if err != nil {
+		return checkFail("graphql: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return checkFail("graphql: %v", err)
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		Data   interface{} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&answer); err != nil {
+		return checkFail("graphql: %s: %v", resp.Status, err)
+	}
+	if len(answer.Errors) > 0 {
+		more := ""
+		if len(answer.Errors) > 1 {
+			more = fmt.Sprintf(" (and %d more)", len(answer.Errors)-1)
+		}
+		return checkFail("graphql: %s%s", answer.Errors[0].Message, more)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return checkFail("graphql: %s", resp.Status)
+	}
+	if answer.Data == nil {
+		return checkFail("graphql: no data")
+	}
+	var expects []string
+	if e := labels[labelExpect]; e != "" {
+		expects = strings.Split(e, ",")
+	}
+	for _, e := range expects {
+		path, want, hasWant := strings.Cut(e, "=")
+		got, ok := jsonPath(answer.Data, path)
+		switch {
+		case !ok:
+			return checkFail("graphql: no %s in data", path)
+		case hasWant && fmt.Sprint(got) != want:
+			return checkFail("graphql: %s is %v, not %s", path, got, want)
+		}
+	}
+	if len(expects) == 0 {
+		return checkOK("%s", resp.Status)
+	}
+	return checkOK("%s, %s as expected", resp.Status, strings.Join(expects, ", "))
+}
+
+// jsonPath follows a dotted path of object keys through decoded JSON,
+// returning the non-null value it leads to.
+func jsonPath(v interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, v != nil
+}
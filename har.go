@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// harMaxBody bounds how much of each response body a HAR file keeps.
+const harMaxBody = 1 << 20
+
+// The HAR 1.2 structures, as far as this poller fills them in.
+type (
+	harFile struct {
+		Log harLog `json:"log"`
+	}
+	harLog struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	}
+	harCreator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	harEntry struct {
+		StartedDateTime time.Time   `json:"startedDateTime"`
+		Time            float64     `json:"time"`
+		Request         harRequest  `json:"request"`
+		Response        harResponse `json:"response"`
+		Cache           struct{}    `json:"cache"`
+		Timings         harTimings  `json:"timings"`
+		ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+		Error           string      `json:"_error,omitempty"` // why no response was received
+	}
+	harRequest struct {
+		Method      string  `json:"method"`
+		URL         string  `json:"url"`
+		HTTPVersion string  `json:"httpVersion"`
+		Cookies     []harNV `json:"cookies"`
+		Headers     []harNV `json:"headers"`
+		QueryString []harNV `json:"queryString"`
+		HeadersSize int     `json:"headersSize"`
+		BodySize    int     `json:"bodySize"`
+	}
+	harResponse struct {
+		Status      int        `json:"status"`
+		StatusText  string     `json:"statusText"`
+		HTTPVersion string     `json:"httpVersion"`
+		Cookies     []harNV    `json:"cookies"`
+		Headers     []harNV    `json:"headers"`
+		Content     harContent `json:"content"`
+		RedirectURL string     `json:"redirectURL"`
+		HeadersSize int        `json:"headersSize"`
+		BodySize    int        `json:"bodySize"`
+	}
+	harContent struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text,omitempty"`
+	}
+	harNV struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	harTimings struct {
+		Blocked float64 `json:"blocked"`
+		DNS     float64 `json:"dns"`
+		Connect float64 `json:"connect"`
+		SSL     float64 `json:"ssl"`
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	}
+)
+
+/*
+harRecorder is an http.RoundTripper that remembers every exchange made through it, with its
+timings, so that a failed poll can be written out as a HAR file and replayed in a browser's
+developer tools or any other HAR viewer.
+A recorder belongs to the one poll that made it; it is not safe for concurrent polls.
+The httptrace hooks may fire on the dialer's goroutines, so they report their moments on a
+channel rather than writing to the entry, and RoundTrip collects them.
+*/
+type harRecorder struct {
+	next    http.RoundTripper
+	entries []*harEntry
+}
+
+func newHARRecorder(next http.RoundTripper) *harRecorder {
+	return &harRecorder{next: next}
+}
+
+// traceEvent is one moment of a request reported by an httptrace hook.
+type traceEvent struct {
+	what string
+	at   time.Time
+	addr string
+}
+
+func (h *harRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	moments := make(chan traceEvent, 16)
+	note := func(what, addr string) {
+		select {
+		case moments <- traceEvent{what, time.Now(), addr}:
+		default:
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { note("dnsStart", "") },
+		DNSDone:              func(httptrace.DNSDoneInfo) { note("dnsDone", "") },
+		ConnectStart:         func(string, string) { note("connectStart", "") },
+		ConnectDone:          func(_, addr string, _ error) { note("connectDone", addr) },
+		TLSHandshakeStart:    func() { note("tlsStart", "") },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { note("tlsDone", "") },
+		GotConn:              func(httptrace.GotConnInfo) { note("gotConn", "") },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { note("wrote", "") },
+		GotFirstResponseByte: func() { note("firstByte", "") },
+	}
+	start := time.Now()
+	e := &harEntry{StartedDateTime: start, Request: harRequest{
+		Method:      req.Method,
+		URL:         req.URL.Redacted(),
+		HTTPVersion: req.Proto,
+		Cookies:     []harNV{},
+		Headers:     harHeaders(req.Header),
+		QueryString: harQuery(req.URL.Query()),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}}
+	h.entries = append(h.entries, e)
+
+	resp, err := h.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	end := time.Now()
+	at := make(map[string]time.Time)
+	for len(moments) > 0 {
+		m := <-moments
+		if _, seen := at[m.what]; !seen {
+			at[m.what] = m.at
+		}
+		if m.addr != "" {
+			e.ServerIPAddress = m.addr
+			if host, _, err := net.SplitHostPort(m.addr); err == nil {
+				e.ServerIPAddress = host
+			}
+		}
+	}
+	between := func(from, to string) float64 {
+		a, ok1 := at[from]
+		b, ok2 := at[to]
+		if !ok1 || !ok2 {
+			return -1
+		}
+		return ms(b.Sub(a))
+	}
+	e.Timings = harTimings{
+		DNS:     between("dnsStart", "dnsDone"),
+		Connect: between("connectStart", "connectDone"),
+		SSL:     between("tlsStart", "tlsDone"),
+		Send:    0,
+		Wait:    between("wrote", "firstByte"),
+	}
+	if first, ok := at["dnsStart"]; ok {
+		e.Timings.Blocked = ms(first.Sub(start))
+	} else if first, ok := at["connectStart"]; ok {
+		e.Timings.Blocked = ms(first.Sub(start))
+	}
+	if got, ok := at["gotConn"]; ok {
+		if wrote, ok := at["wrote"]; ok {
+			e.Timings.Send = ms(wrote.Sub(got))
+		}
+	}
+	e.Time = ms(end.Sub(start))
+	if err != nil {
+		e.Error = err.Error()
+		e.Response = harResponse{Cookies: []harNV{}, Headers: []harNV{}, HeadersSize: -1, BodySize: -1}
+		return nil, err
+	}
+	e.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNV{},
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent{MimeType: resp.Header.Get("Content-Type")},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	resp.Body = &harBody{ReadCloser: resp.Body, entry: e, since: end}
+	return resp, nil
+}
+
+// harBody records a response body as it is read, and the time spent reading it.
+type harBody struct {
+	io.ReadCloser
+	entry *harEntry
+	since time.Time
+	buf   bytes.Buffer
+	n     int
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += n
+	if room := harMaxBody - b.buf.Len(); room > 0 {
+		if n < room {
+			room = n
+		}
+		b.buf.Write(p[:room])
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	b.entry.Response.Content.Size = b.n
+	b.entry.Response.BodySize = b.n
+	b.entry.Response.Content.Text = b.buf.String()
+	b.entry.Timings.Receive = ms(time.Since(b.since))
+	b.entry.Time += b.entry.Timings.Receive
+	return b.ReadCloser.Close()
+}
+
+// write saves the exchanges so far as a HAR file in dir, named after the
+// target and the time, and returns its path.
+func (h *harRecorder) write(dir, target string) (string, error) {
+	f := harFile{Log: harLog{Version: "1.2", Creator: harCreator{Name: "concurrent", Version: "1"}}}
+	for _, e := range h.entries {
+		f.Log.Entries = append(f.Log.Entries, *e)
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(strings.TrimPrefix(target, "http://"), "https://"))
+	path := filepath.Join(dir, strings.Trim(name, "_")+"-"+time.Now().UTC().Format("20060102T150405.000")+".har")
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, b, 0o644)
+}
+
+// recordHAR writes rec to -har-dir for a poll of target that failed and logs
+// where it went.
+func recordHAR(rec *harRecorder, target string) {
+	path, err := rec.write(*harDir, target)
+	if err != nil {
+		logger.Println("Error", "har", err)
+		return
+	}
+	logger.Println("HAR", target, path)
+}
+
+// secretHeaders are the headers whose values are credentials, such as those
+// of the graphql+ and soap+ header files, or session tokens. What is written
+// or sent out about an exchange masks them.
+var secretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// redactHeader returns v, the value of header k, masked if it is a secret.
+func redactHeader(k, v string) string {
+	if secretHeaders[http.CanonicalHeaderKey(k)] {
+		return "xxxxx"
+	}
+	return v
+}
+
+func harHeaders(h http.Header) []harNV {
+	nv := []harNV{}
+	for k, vs := range h {
+		for _, v := range vs {
+			nv = append(nv, harNV{k, redactHeader(k, v)})
+		}
+	}
+	return nv
+}
+
+func harQuery(q url.Values) []harNV {
+	nv := []harNV{}
+	for k, vs := range q {
+		for _, v := range vs {
+			nv = append(nv, harNV{k, v})
+		}
+	}
+	return nv
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
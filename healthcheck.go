@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthcheck implements the "healthcheck <url>" subcommand so a container
+// image can use this binary for its HEALTHCHECK instead of installing curl.
+// It returns the process exit code: 0 when url answers with a 2xx or 3xx
+// status and 1 otherwise (Docker reserves 2, so it is never used).
+func healthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 5*time.Second, "give up on the request after this long")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s healthcheck [-timeout d] <url>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unhealthy:", err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		fmt.Fprintln(os.Stderr, "unhealthy:", resp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyRecord is one poll result as the history store keeps it.
+type historyRecord struct {
+	Time      time.Time  `json:"time"`
+	URL       string     `json:"url"`
+	Status    string     `json:"status"`
+	Up        bool       `json:"up"`
+	Class     errorClass `json:"error_class,omitempty"`
+	LatencyMS float64    `json:"latency_ms"`
+}
+
+// Raw results are appended to one JSON-lines segment file per UTC hour, so a
+// time-range query only opens the segments it overlaps.
+const (
+	rawSegmentPrefix = "raw-"
+	rawSegmentLayout = "20060102T15"
+	historyFlush     = time.Second
+)
+
+func rawSegment(t time.Time) string {
+	return rawSegmentPrefix + t.UTC().Format(rawSegmentLayout) + ".jsonl"
+}
+
+// History reads the results recorded by HistoryStore.
+type History struct {
+	dir     string
+	flushes chan chan error
+}
+
+/*
+HistoryStore records every poll result under dir and returns the channel on which it wants to
+hear about them, to be passed to StateMonitor as a listener, together with a History for
+reading them back.
+Writes are buffered and flushed every second by the store's goroutine, which owns the open
+segment. Readers ask it to flush first, then read the segment files themselves; segments are
+only ever appended to, so a reader can at worst see a partly written last line, which it skips.
+*/
+func HistoryStore(dir string) (chan<- State, *History, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	states := make(chan State)
+	h := &History{dir: dir, flushes: make(chan chan error)}
+	go func() {
+		var (
+			name string
+			f    *os.File
+			w    *bufio.Writer
+		)
+		ticker := time.NewTicker(historyFlush)
+		flush := func() error {
+			if w == nil {
+				return nil
+			}
+			return w.Flush()
+		}
+		for {
+			select {
+			case s := <-states:
+				now := time.Now()
+				if seg := rawSegment(now); seg != name || f == nil {
+					if f != nil {
+						flush()
+						f.Close()
+					}
+					var err error
+					f, err = os.OpenFile(filepath.Join(dir, seg), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+					if err != nil {
+						logger.Println("Error", "history", err)
+						f, w, name = nil, nil, ""
+						continue
+					}
+					name, w = seg, bufio.NewWriter(f)
+				}
+				b, _ := json.Marshal(historyRecord{
+					Time:      now.UTC(),
+					URL:       s.url,
+					Status:    s.result.String(),
+					Up:        s.result.Up,
+					Class:     s.result.Class,
+					LatencyMS: float64(s.result.Latency) / float64(time.Millisecond),
+				})
+				w.Write(append(b, '\n'))
+			case <-ticker.C:
+				if err := flush(); err != nil {
+					logger.Println("Error", "history", err)
+				}
+			case reply := <-h.flushes:
+				reply <- flush()
+			}
+		}
+	}()
+	return states, h, nil
+}
+
+// historyQuery selects records from the history.
+type historyQuery struct {
+	url      string // empty: every target
+	from, to time.Time
+	up       *bool // nil: both
+	limit    int
+	after    historyCursor
+}
+
+// historyCursor is the position of the last record of a page; results are
+// ordered by time, then URL.
+type historyCursor struct {
+	time time.Time
+	url  string
+}
+
+func (c historyCursor) before(r historyRecord) bool {
+	return c.time.Before(r.Time) || c.time.Equal(r.Time) && c.url < r.URL
+}
+
+func (c historyCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.time.UnixNano(), 10) + "|" + c.url))
+}
+
+func parseCursor(s string) (historyCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return historyCursor{}, errors.New("bad cursor")
+	}
+	ns, url, ok := strings.Cut(string(b), "|")
+	n, err := strconv.ParseInt(ns, 10, 64)
+	if !ok || err != nil {
+		return historyCursor{}, errors.New("bad cursor")
+	}
+	return historyCursor{time: time.Unix(0, n).UTC(), url: url}, nil
+}
+
+// Query returns up to q.limit matching records and, when there are more, the
+// cursor to pass as q.after for the next page. It reads no further than it
+// must to find the record after the page: segments are read in order, as are
+// the records in each, which are appended as they come.
+func (h *History) Query(q historyQuery) ([]historyRecord, string, error) {
+	reply := make(chan error)
+	h.flushes <- reply
+	if err := <-reply; err != nil {
+		return nil, "", err
+	}
+
+	var out []historyRecord
+	for hour := q.from.UTC().Truncate(time.Hour); len(out) <= q.limit && !hour.After(q.to); hour = hour.Add(time.Hour) {
+		var seg []historyRecord
+		err := readRecords(filepath.Join(h.dir, rawSegment(hour)), func(r historyRecord) bool {
+			// With the record after the page in hand, only ties with the
+			// last one read can still sort before it.
+			if len(out)+len(seg) > q.limit && r.Time.After(seg[len(seg)-1].Time) {
+				return false
+			}
+			if r.Time.Before(q.from) || r.Time.After(q.to) ||
+				q.url != "" && r.URL != q.url ||
+				q.up != nil && (r.Up != *q.up || r.Status == statusPaused) ||
+				!q.after.time.IsZero() && !q.after.before(r) {
+				return true
+			}
+			seg = append(seg, r)
+			return true
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Slice(seg, func(i, j int) bool {
+			return seg[i].Time.Before(seg[j].Time) || seg[i].Time.Equal(seg[j].Time) && seg[i].URL < seg[j].URL
+		})
+		out = append(out, seg...)
+	}
+	next := ""
+	if len(out) > q.limit {
+		out = out[:q.limit]
+		last := out[len(out)-1]
+		next = historyCursor{time: last.Time, url: last.URL}.String()
+	}
+	return out, next, nil
+}
+
+// readRecords calls fn for every record in the segment at path, in order,
+// until fn returns false. A missing segment holds no records.
+func readRecords(path string, fn func(historyRecord) bool) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var r historyRecord
+		if json.Unmarshal(sc.Bytes(), &r) == nil && !fn(r) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+const (
+	historyDefaultRange = 24 * time.Hour
+	historyDefaultLimit = 100
+	historyMaxLimit     = 1000
+)
+
+// ServeHTTP answers GET /history?target=&from=&to=&status=up|down&limit=&cursor=.
+// from and to are RFC 3339 times or Unix seconds and default to the last day.
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	v := r.URL.Query()
+	q := historyQuery{url: v.Get("target"), to: time.Now().UTC(), limit: historyDefaultLimit}
+	var err error
+	if s := v.Get("to"); s != "" {
+		if q.to, err = parseTime(s); err != nil {
+			http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	q.from = q.to.Add(-historyDefaultRange)
+	if s := v.Get("from"); s != "" {
+		if q.from, err = parseTime(s); err != nil {
+			http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	switch v.Get("status") {
+	case "":
+	case "up", "down":
+		up := v.Get("status") == "up"
+		q.up = &up
+	default:
+		http.Error(w, "status: want up or down", http.StatusBadRequest)
+		return
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.limit, err = strconv.Atoi(s); err != nil || q.limit <= 0 || q.limit > historyMaxLimit {
+			http.Error(w, fmt.Sprintf("limit: want 1 to %d", historyMaxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := v.Get("cursor"); s != "" {
+		if q.after, err = parseCursor(s); err != nil {
+			http.Error(w, "cursor: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, next, err := h.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []historyRecord{}
+	}
+	for i := range results {
+		results[i].Time = displayed(results[i].Time)
+	}
+	writeJSON(w, map[string]interface{}{"results": results, "next": next})
+}
+
+// parseTime accepts Unix seconds, RFC 3339, or a date with an optional time
+// of day but no offset, read in displayLocation.
+func parseTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return parseLocalTime(s)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
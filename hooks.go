@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target labels naming a target's own hook scripts, found in -hook-dir.
+const (
+	labelOnFailure = "on-failure"
+	labelOnSuccess = "on-success"
+)
+
+/*
+Hooks runs local commands when targets go down and come back up, so that small remediation
+scripts, restarting a wedged service say, can run without a full alerting stack.
+A target's labels on-failure and on-success name its scripts, to be found in dir; as labels come
+from discovery sources anyone able to annotate a Service could set, they may only name files in
+dir, never a path or a command line. The onFailure and onSuccess commands, given on the command
+line, run through sh for every target that has no script of its own.
+Each hook runs on a goroutine of its own, killed after timeout, with details of the transition in
+SHAREMEM_* environment variables; what it prints is logged if it fails.
+It returns the channel on which it wants to hear of events, to be passed to the Alerter as a
+listener: per-target events, uncorrelated and unsilenced, since a silence mutes people, not
+remediation.
+*/
+func Hooks(dir, onFailure, onSuccess string, timeout time.Duration) chan<- Event {
+	events := make(chan Event)
+	go func() {
+		for e := range events {
+			var label, command string
+			switch e.kind {
+			case eventDown:
+				label, command = labelOnFailure, onFailure
+			case eventRecovered:
+				label, command = labelOnSuccess, onSuccess
+			default:
+				continue
+			}
+			var cmd []string
+			if script := e.state.target.labels[label]; script != "" {
+				if dir == "" || script != filepath.Base(script) || strings.HasPrefix(script, ".") {
+					logger.Printf("Hook %s for %s: %s=%q must name a script in -hook-dir", e.kind, e.state.url, label, script)
+					continue
+				}
+				cmd = []string{filepath.Join(dir, script)}
+			} else if command != "" {
+				cmd = []string{"/bin/sh", "-c", command}
+			} else {
+				continue
+			}
+			go runHook(cmd, hookEnv(e), timeout, e)
+		}
+	}()
+	return events
+}
+
+// runHook runs the hook cmd for e with env added to the environment.
+func runHook(cmd, env []string, timeout time.Duration, e Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Env = append(os.Environ(), env...)
+	out, err := c.CombinedOutput()
+	if err != nil {
+		logger.Printf("Hook %s for %s failed: %v: %s", e.kind, e.state.url, err, strings.TrimSpace(string(out)))
+		return
+	}
+	logger.Printf("Hook %s for %s ran", e.kind, e.state.url)
+}
+
+// hookEnv returns the environment variables describing e to a hook: the
+// target, the event, the status before and after, the class of a failure,
+// the latency in seconds and the target's labels as SHAREMEM_LABEL_<NAME>.
+func hookEnv(e Event) []string {
+	env := []string{
+		"SHAREMEM_URL=" + e.state.url,
+		"SHAREMEM_EVENT=" + e.kind,
+		"SHAREMEM_STATUS=" + e.state.result.String(),
+		"SHAREMEM_PREV_STATUS=" + e.state.prev.String(),
+		"SHAREMEM_ERROR_CLASS=" + string(e.state.result.Class),
+		"SHAREMEM_LATENCY=" + strconv.FormatFloat(e.state.result.Latency.Seconds(), 'f', -1, 64),
+		"SHAREMEM_DETAIL=" + e.detail,
+		"SHAREMEM_TIME=" + e.time.UTC().Format(time.RFC3339),
+	}
+	names := make([]string, 0, len(e.state.target.labels))
+	for k := range e.state.target.labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		env = append(env, fmt.Sprintf("SHAREMEM_LABEL_%s=%s", strings.ToUpper(promName(k)), e.state.target.labels[k]))
+	}
+	return env
+}
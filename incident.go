@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Incident statuses.
+const (
+	incidentOpen         = "open"
+	incidentAcknowledged = "acknowledged"
+	incidentResolved     = "resolved"
+)
+
+// incidentKeep is how many resolved incidents are kept.
+const incidentKeep = 1000
+
+// Incident is a sustained outage of one or more targets.
+type Incident struct {
+	ID             string     `json:"id"`
+	Status         string     `json:"status"`
+	Title          string     `json:"title"`
+	Group          string     `json:"group,omitempty"` // what the targets have in common, for correlated failures
+	Targets        []string   `json:"targets"`         // every target affected
+	Down           []string   `json:"down"`            // the targets still down
+	Opened         time.Time  `json:"opened"`
+	Acknowledged   *time.Time `json:"acknowledged,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	Recovered      *time.Time `json:"recovered,omitempty"` // when the last target came back, while waiting to resolve
+	Resolved       *time.Time `json:"resolved,omitempty"`
+	ResolvedBy     string     `json:"resolved_by,omitempty"` // empty when resolved automatically
+	Duration       float64    `json:"duration_seconds"`      // from opening to resolution, or to now
+}
+
+func (inc *Incident) active() bool { return inc.Status != incidentResolved }
+
+func (inc *Incident) affects(url string) bool {
+	for _, t := range inc.Targets {
+		if t == url {
+			return true
+		}
+	}
+	return false
+}
+
+// incidentStore is what the incident store file holds.
+type incidentStore struct {
+	Next      int        `json:"next"`
+	Incidents []Incident `json:"incidents"`
+}
+
+/*
+Incidents tracks outages from open to resolution.
+An incident is opened by a DOWN event, for its target or for all the targets of a correlated one,
+unless an active incident already covers one of them, in which case they join it. A RECOVERED
+event takes its target off the incident's down list; once none are left and none has gone down
+again for resolveAfter, the incident resolves itself. Incidents can also be acknowledged and
+resolved by hand through the API. A target paused or retired while down is taken off the down
+list as it comes on live from WatchTargets, and an incident left with none resolves at once,
+since no RECOVERED will ever come for it.
+One goroutine owns the incidents; with a file, it saves them there after every change and they
+are restored on startup.
+*/
+type Incidents struct {
+	controls chan incidentControl
+}
+
+type incidentControl struct {
+	op    string // "list", "ack" or "resolve"
+	id    string
+	by    string
+	reply chan incidentReply
+}
+
+type incidentReply struct {
+	incidents []Incident
+	before    Incident
+	after     Incident
+	err       error
+}
+
+// IncidentTracker starts tracking incidents, persisted in path unless it is
+// empty. It returns the channel on which it wants to hear about events, to be
+// passed on to the Alerter.
+func IncidentTracker(path string, resolveAfter time.Duration, live <-chan map[string]bool) (chan<- Event, *Incidents, error) {
+	var saved incidentStore
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &saved); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+	events := make(chan Event)
+	in := &Incidents{controls: make(chan incidentControl)}
+	incidents := saved.Incidents
+	next := saved.Next
+	if next == 0 {
+		next = 1
+	}
+	save := func() {
+		if path == "" {
+			return
+		}
+		if err := writeFileAtomic(path, incidentStore{Next: next, Incidents: incidents}); err != nil {
+			logger.Println("Error", "incidents", err)
+		}
+	}
+	find := func(id string) *Incident {
+		for i := range incidents {
+			if incidents[i].ID == id {
+				return &incidents[i]
+			}
+		}
+		return nil
+	}
+	covering := func(url string) *Incident {
+		for i := range incidents {
+			if incidents[i].active() && incidents[i].affects(url) {
+				return &incidents[i]
+			}
+		}
+		return nil
+	}
+	resolve := func(inc *Incident, at time.Time, by string) {
+		inc.Status, inc.Resolved, inc.ResolvedBy, inc.Down = incidentResolved, &at, by, []string{}
+		logger.Printf("Incident %s resolved after %v", inc.ID, at.Sub(inc.Opened).Round(time.Second))
+	}
+	ticker := time.NewTicker(resolveAfter/4 + time.Second)
+	go func() {
+		for {
+			select {
+			case e := <-events:
+				urls := []string{e.state.url}
+				if len(e.related) > 0 {
+					urls = urls[:0]
+					for _, s := range e.related {
+						urls = append(urls, s.url)
+					}
+				}
+				switch e.kind {
+				case eventDown:
+					var inc *Incident
+					for _, u := range urls {
+						if inc = covering(u); inc != nil {
+							break
+						}
+					}
+					if inc == nil {
+						incidents = append(incidents, Incident{
+							ID:     fmt.Sprintf("INC-%d", next),
+							Status: incidentOpen,
+							Title:  e.title(),
+							Group:  e.group,
+							Opened: e.time.UTC(),
+						})
+						next++
+						inc = &incidents[len(incidents)-1]
+						logger.Printf("Incident %s opened: %s", inc.ID, inc.Title)
+					}
+					for _, u := range urls {
+						if !inc.affects(u) {
+							inc.Targets = append(inc.Targets, u)
+						}
+						if !contains(inc.Down, u) {
+							inc.Down = append(inc.Down, u)
+						}
+					}
+					inc.Recovered = nil
+				case eventRecovered:
+					inc := covering(e.state.url)
+					if inc == nil {
+						continue
+					}
+					inc.Down = remove(inc.Down, e.state.url)
+					if len(inc.Down) == 0 {
+						at := e.time.UTC()
+						inc.Recovered = &at
+					}
+				default:
+					continue
+				}
+				save()
+			case <-ticker.C:
+				changed := false
+				for i := range incidents {
+					inc := &incidents[i]
+					if inc.active() && inc.Recovered != nil && time.Since(*inc.Recovered) >= resolveAfter {
+						resolve(inc, *inc.Recovered, "")
+						changed = true
+					}
+				}
+				if changed {
+					incidents = pruneIncidents(incidents)
+					save()
+				}
+			case targets := <-live:
+				changed := false
+				for i := range incidents {
+					inc := &incidents[i]
+					if !inc.active() || len(inc.Down) == 0 {
+						continue
+					}
+					down := inc.Down[:0:0]
+					for _, u := range inc.Down {
+						if !withdrawn(targets, u) {
+							down = append(down, u)
+						}
+					}
+					if len(down) == len(inc.Down) {
+						continue
+					}
+					changed = true
+					inc.Down = down
+					if len(down) == 0 {
+						resolve(inc, time.Now().UTC(), "")
+					}
+				}
+				if changed {
+					incidents = pruneIncidents(incidents)
+					save()
+				}
+			case c := <-in.controls:
+				if c.op == "list" {
+					out := make([]Incident, len(incidents))
+					copy(out, incidents)
+					c.reply <- incidentReply{incidents: out}
+					continue
+				}
+				inc := find(c.id)
+				if inc == nil {
+					c.reply <- incidentReply{err: fmt.Errorf("no incident %s", c.id)}
+					continue
+				}
+				before := *inc
+				if !inc.active() {
+					c.reply <- incidentReply{before: before, after: before, err: fmt.Errorf("%s is already resolved", c.id)}
+					continue
+				}
+				switch c.op {
+				case "ack":
+					if inc.Acknowledged == nil {
+						now := time.Now().UTC()
+						inc.Status, inc.Acknowledged, inc.AcknowledgedBy = incidentAcknowledged, &now, c.by
+						logger.Printf("Incident %s acknowledged by %s", inc.ID, c.by)
+					}
+				case "resolve":
+					resolve(inc, time.Now().UTC(), c.by)
+				}
+				after := *inc
+				incidents = pruneIncidents(incidents)
+				save()
+				c.reply <- incidentReply{before: before, after: after}
+			}
+		}
+	}()
+	return events, in, nil
+}
+
+// pruneIncidents drops the oldest resolved incidents beyond incidentKeep.
+func pruneIncidents(incidents []Incident) []Incident {
+	resolved := 0
+	for _, inc := range incidents {
+		if !inc.active() {
+			resolved++
+		}
+	}
+	if resolved <= incidentKeep {
+		return incidents
+	}
+	out := incidents[:0]
+	for _, inc := range incidents {
+		if !inc.active() && resolved > incidentKeep {
+			resolved--
+			continue
+		}
+		out = append(out, inc)
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// List returns every incident, with durations computed as of now.
+func (in *Incidents) List() []Incident {
+	reply := make(chan incidentReply)
+	in.controls <- incidentControl{op: "list", reply: reply}
+	list := (<-reply).incidents
+	for i := range list {
+		list[i] = list[i].timed()
+	}
+	return list
+}
+
+// timed returns inc with its duration computed as of now and its times in
+// displayLocation, as the API shows it.
+func (inc Incident) timed() Incident {
+	end := time.Now()
+	if inc.Resolved != nil {
+		end = *inc.Resolved
+	}
+	inc.Duration = end.Sub(inc.Opened).Seconds()
+	inc.Opened = displayed(inc.Opened)
+	inc.Acknowledged = displayedPtr(inc.Acknowledged)
+	inc.Recovered = displayedPtr(inc.Recovered)
+	inc.Resolved = displayedPtr(inc.Resolved)
+	return inc
+}
+
+// incidentsAPI serves the incident endpoints:
+//
+//	GET  /incidents/?status=&target=&from=&to=  incidents, newest first; from and to bound when they were opened
+//	GET  /incidents/ID                          one incident
+//	POST /incidents/ack?id=ID                   acknowledge an incident
+//	POST /incidents/resolve?id=ID               resolve an incident by hand
+//
+// Acknowledgements and manual resolutions are recorded in audit.
+func incidentsAPI(in *Incidents, audit *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/incidents/")
+		if r.Method == http.MethodGet {
+			list := in.List()
+			if rest != "" {
+				for _, inc := range list {
+					if inc.ID == rest {
+						writeJSON(w, inc)
+						return
+					}
+				}
+				http.NotFound(w, r)
+				return
+			}
+			v := r.URL.Query()
+			var from, to time.Time
+			var err error
+			if s := v.Get("from"); s != "" {
+				if from, err = parseTime(s); err != nil {
+					http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if s := v.Get("to"); s != "" {
+				if to, err = parseTime(s); err != nil {
+					http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			out := []Incident{}
+			for _, inc := range list {
+				switch {
+				case v.Get("status") != "" && inc.Status != v.Get("status"):
+				case v.Get("target") != "" && !inc.affects(v.Get("target")):
+				case !from.IsZero() && inc.Opened.Before(from):
+				case !to.IsZero() && !inc.Opened.Before(to):
+				default:
+					out = append(out, inc)
+				}
+			}
+			sort.SliceStable(out, func(i, j int) bool { return out[i].Opened.After(out[j].Opened) })
+			writeJSON(w, map[string]interface{}{"incidents": out})
+			return
+		}
+		if rest != "ack" && rest != "resolve" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.FormValue("id")
+		if id == "" {
+			http.Error(w, "id: missing", http.StatusBadRequest)
+			return
+		}
+		reply := make(chan incidentReply)
+		in.controls <- incidentControl{op: rest, id: id, by: principal(r), reply: reply}
+		rep := <-reply
+		if rep.err != nil {
+			code := http.StatusNotFound
+			if rep.before.ID != "" {
+				code = http.StatusConflict
+			}
+			http.Error(w, rep.err.Error(), code)
+			return
+		}
+		audit.Record(r, "incident-"+rest, id, map[string]string{"status": rep.before.Status}, map[string]string{"status": rep.after.Status})
+		writeJSON(w, rep.after.timed())
+	})
+}
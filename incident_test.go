@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPruneIncidents(t *testing.T) {
+	// incidents returns n incidents, the ith resolved unless open says it is active.
+	incidents := func(n int, open map[int]bool) []Incident {
+		out := make([]Incident, n)
+		for i := range out {
+			out[i] = Incident{ID: fmt.Sprintf("INC-%d", i+1), Status: incidentResolved}
+			if open[i] {
+				out[i].Status = incidentOpen
+			}
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name  string
+		in    []Incident
+		first string // the ID of the first incident kept
+		n     int
+	}{
+		{"none", nil, "", 0},
+		{"under the limit", incidents(10, nil), "INC-1", 10},
+		{"at the limit", incidents(incidentKeep, nil), "INC-1", incidentKeep},
+		{"over the limit", incidents(incidentKeep+5, nil), "INC-6", incidentKeep},
+		{"active ones are kept", incidents(incidentKeep+5, map[int]bool{0: true, 2: true}), "INC-1", incidentKeep + 2},
+		{"active ones do not count", incidents(incidentKeep+2, map[int]bool{0: true, 1: true}), "INC-1", incidentKeep + 2},
+	} {
+		out := pruneIncidents(tc.in)
+		if len(out) != tc.n || tc.n > 0 && out[0].ID != tc.first {
+			t.Errorf("%s: kept %d starting with %+v, want %d starting with %s", tc.name, len(out), out[:min(len(out), 1)], tc.n, tc.first)
+			continue
+		}
+		resolved := 0
+		for _, inc := range out {
+			if !inc.active() {
+				resolved++
+			}
+		}
+		if resolved > incidentKeep {
+			t.Errorf("%s: %d resolved kept", tc.name, resolved)
+		}
+	}
+
+	// The oldest go first, and the order is kept.
+	out := pruneIncidents(incidents(incidentKeep+3, map[int]bool{1: true}))
+	if out[0].ID != "INC-2" || out[1].ID != "INC-4" || out[len(out)-1].ID != fmt.Sprintf("INC-%d", incidentKeep+3) {
+		t.Errorf("kept %s, %s … %s", out[0].ID, out[1].ID, out[len(out)-1].ID)
+	}
+}
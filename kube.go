@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient is the small slice of a Kubernetes API client this program needs:
+// authenticated JSON requests and watch streams against the API server.
+type kubeClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// newKubeClient returns a client for the API server at apiURL. An empty apiURL
+// selects the in-cluster configuration: the service host from the environment
+// and the pod's service account token and CA bundle.
+func newKubeClient(apiURL string) (*kubeClient, error) {
+	if apiURL != "" {
+		return &kubeClient{base: strings.TrimSuffix(apiURL, "/"), client: &http.Client{}}, nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kube: not running in a cluster and no API server URL given")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("kube: %w", err)
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("kube: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("kube: no certificates in service account CA bundle")
+	}
+	return &kubeClient{
+		base:  "https://" + net.JoinHostPort(host, port),
+		token: strings.TrimSpace(string(token)),
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+func (c *kubeClient) request(method, path string, query url.Values, contentType string, body interface{}) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(b)
+	}
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, &kubeError{code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// get decodes the object at path into out.
+func (c *kubeClient) get(path string, query url.Values, out interface{}) error {
+	resp, err := c.request(http.MethodGet, path, query, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// mergePatch applies a JSON merge patch to the object at path.
+func (c *kubeClient) mergePatch(path string, patch interface{}) error {
+	resp, err := c.request(http.MethodPatch, path, nil, "application/merge-patch+json", patch)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// kubeEvent is one entry of a watch stream.
+type kubeEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// watch streams the events for the collection at path, starting after
+// resourceVersion, to fn until the server ends the stream or fn returns an
+// error.
+func (c *kubeClient) watch(path, resourceVersion string, fn func(kubeEvent) error) error {
+	q := url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {"300"},
+	}
+	resp, err := c.request(http.MethodGet, path, q, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e kubeEvent
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// kubeError is a non-2xx answer from the API server.
+type kubeError struct {
+	code int
+	msg  string
+}
+
+func (e *kubeError) Error() string { return fmt.Sprintf("kube: HTTP %d: %s", e.code, e.msg) }
+
+// kubeObjectMeta holds the metadata fields common to every object we read.
+type kubeObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Generation      int64             `json:"generation"`
+}
+
+// kubeListMeta is the metadata of a list response.
+type kubeListMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// syncCollection keeps a copy of the collection at path up to date with a
+// list followed by a watch, relisting whenever the watch fails. After the
+// initial list and after every change it calls fn with the complete set of
+// objects, keyed by namespace/name; fn owns the map it is given. It never
+// returns, so run it in its own goroutine.
+func (c *kubeClient) syncCollection(path string, fn func(map[string]json.RawMessage)) {
+	for {
+		var list struct {
+			Metadata kubeListMeta      `json:"metadata"`
+			Items    []json.RawMessage `json:"items"`
+		}
+		if err := c.get(path, nil, &list); err != nil {
+			logger.Println("Error", path, err)
+			time.Sleep(errTimeout)
+			continue
+		}
+		objs := make(map[string]json.RawMessage)
+		for _, raw := range list.Items {
+			if meta, err := decodeMeta(raw); err == nil {
+				objs[meta.Namespace+"/"+meta.Name] = raw
+			}
+		}
+		fn(copyObjects(objs))
+
+		rv := list.Metadata.ResourceVersion
+		for {
+			err := c.watch(path, rv, func(e kubeEvent) error {
+				if e.Type == "ERROR" {
+					// Usually 410 Gone: our resourceVersion is too old and
+					// only a fresh list can recover.
+					return fmt.Errorf("kube: watch error: %s", e.Object)
+				}
+				meta, err := decodeMeta(e.Object)
+				if err != nil {
+					return err
+				}
+				rv = meta.ResourceVersion
+				switch e.Type {
+				case "ADDED", "MODIFIED":
+					objs[meta.Namespace+"/"+meta.Name] = e.Object
+				case "DELETED":
+					delete(objs, meta.Namespace+"/"+meta.Name)
+				default: // BOOKMARK
+					return nil
+				}
+				fn(copyObjects(objs))
+				return nil
+			})
+			if err != nil {
+				logger.Println("Error", path, err)
+				break
+			}
+		}
+	}
+}
+
+func decodeMeta(raw json.RawMessage) (kubeObjectMeta, error) {
+	var obj struct {
+		Metadata kubeObjectMeta `json:"metadata"`
+	}
+	err := json.Unmarshal(raw, &obj)
+	return obj.Metadata, err
+}
+
+func copyObjects(objs map[string]json.RawMessage) map[string]json.RawMessage {
+	c := make(map[string]json.RawMessage, len(objs))
+	for k, v := range objs {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Annotations that opt a Service or Ingress in to being polled.
+const (
+	annotationPath    = "sharemem.poll/path"    // required: the path to poll, e.g. /healthz
+	annotationPort    = "sharemem.poll/port"    // Services only: port name or number (default: first port)
+	annotationScheme  = "sharemem.poll/scheme"  // http or https (default: http, or https for Ingress hosts with TLS)
+	annotationNotes   = "sharemem.poll/notes"   // free-form notes carried to alerts
+	annotationRunbook = "sharemem.poll/runbook" // runbook URL carried to alerts
+)
+
+// kubeService is the part of a Service the discovery reads.
+type kubeService struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+// kubeIngress is the part of a networking.k8s.io/v1 Ingress the discovery reads.
+type kubeIngress struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		TLS []struct {
+			Hosts []string `json:"hosts"`
+		} `json:"tls"`
+		Rules []struct {
+			Host string `json:"host"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// KubeDiscovery keeps the Scheduler's "kube-services" and "kube-ingresses"
+// targets in sync with the annotated Services and Ingresses in the cluster (or
+// in namespace, when it is not empty). Each target is labelled with the kind,
+// namespace and name of the object it came from.
+func KubeDiscovery(kube *kubeClient, namespace string, targets chan<- TargetUpdate) {
+	prefix := ""
+	if namespace != "" {
+		prefix = "/namespaces/" + namespace
+	}
+	go kube.syncCollection("/api/v1"+prefix+"/services", func(objs map[string]json.RawMessage) {
+		var ts []Target
+		for _, raw := range objs {
+			var svc kubeService
+			if json.Unmarshal(raw, &svc) == nil {
+				ts = append(ts, serviceTargets(svc)...)
+			}
+		}
+		targets <- TargetUpdate{source: "kube-services", targets: sortTargets(ts)}
+	})
+	go kube.syncCollection("/apis/networking.k8s.io/v1"+prefix+"/ingresses", func(objs map[string]json.RawMessage) {
+		var ts []Target
+		for _, raw := range objs {
+			var ing kubeIngress
+			if json.Unmarshal(raw, &ing) == nil {
+				ts = append(ts, ingressTargets(ing)...)
+			}
+		}
+		targets <- TargetUpdate{source: "kube-ingresses", targets: sortTargets(ts)}
+	})
+}
+
+// serviceTargets returns the in-cluster URL of an annotated Service.
+func serviceTargets(svc kubeService) []Target {
+	m := svc.Metadata
+	path, ok := m.Annotations[annotationPath]
+	if !ok || len(svc.Spec.Ports) == 0 {
+		return nil
+	}
+	port := svc.Spec.Ports[0].Port
+	if want := m.Annotations[annotationPort]; want != "" {
+		port = 0
+		for _, p := range svc.Spec.Ports {
+			if p.Name == want || strconv.Itoa(p.Port) == want {
+				port = p.Port
+			}
+		}
+		if port == 0 {
+			return nil
+		}
+	}
+	scheme := m.Annotations[annotationScheme]
+	if scheme == "" {
+		scheme = "http"
+	}
+	host := net.JoinHostPort(m.Name+"."+m.Namespace+".svc", strconv.Itoa(port))
+	return []Target{{
+		url:     scheme + "://" + host + joinPath(path),
+		labels:  map[string]string{"kind": "service", "namespace": m.Namespace, "name": m.Name},
+		notes:   m.Annotations[annotationNotes],
+		runbook: m.Annotations[annotationRunbook],
+	}}
+}
+
+// ingressTargets returns one URL per host of an annotated Ingress.
+func ingressTargets(ing kubeIngress) []Target {
+	m := ing.Metadata
+	path, ok := m.Annotations[annotationPath]
+	if !ok {
+		return nil
+	}
+	tlsHosts := make(map[string]bool)
+	for _, t := range ing.Spec.TLS {
+		for _, h := range t.Hosts {
+			tlsHosts[h] = true
+		}
+	}
+	var ts []Target
+	hosts := make(map[string]bool)
+	for _, r := range ing.Spec.Rules {
+		if r.Host == "" || strings.Contains(r.Host, "*") || hosts[r.Host] {
+			continue
+		}
+		hosts[r.Host] = true
+		scheme := m.Annotations[annotationScheme]
+		if scheme == "" {
+			scheme = "http"
+			if tlsHosts[r.Host] {
+				scheme = "https"
+			}
+		}
+		ts = append(ts, Target{
+			url:     scheme + "://" + r.Host + joinPath(path),
+			labels:  map[string]string{"kind": "ingress", "namespace": m.Namespace, "name": m.Name},
+			notes:   m.Annotations[annotationNotes],
+			runbook: m.Annotations[annotationRunbook],
+		})
+	}
+	return ts
+}
+
+func joinPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return "/" + p
+	}
+	return p
+}
+
+// sortTargets orders ts by URL so that updates from map iteration are stable
+// in logs.
+func sortTargets(ts []Target) []Target {
+	sort.Slice(ts, func(i, j int) bool { return ts[i].url < ts[j].url })
+	return ts
+}
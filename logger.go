@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// logger is where the goroutines report what they do and what went wrong:
+// the standard log package unless main, with -log-format, or a program
+// built around these parts sets another before starting any of them.
+var logger Logger = log.Default()
+
+/*
+Logger is what the Pollers, the StateMonitor, the sinks and the rest log through. A *log.Logger
+is one; SlogLogger makes one of any slog.Handler. Lines reporting a failure begin with "Error",
+as they always have, so that a handler can tell them apart.
+*/
+type Logger interface {
+	Printf(format string, v ...any)
+	Println(v ...any)
+}
+
+// SlogLogger returns a Logger passing each line to h as a record's message,
+// at slog.LevelError if it begins with "Error" and slog.LevelInfo if not.
+func SlogLogger(h slog.Handler) Logger {
+	return slogLogger{h}
+}
+
+type slogLogger struct {
+	h slog.Handler
+}
+
+func (l slogLogger) Printf(format string, v ...any) {
+	l.log(fmt.Sprintf(format, v...))
+}
+
+func (l slogLogger) Println(v ...any) {
+	l.log(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func (l slogLogger) log(msg string) {
+	level := slog.LevelInfo
+	if strings.HasPrefix(msg, "Error") {
+		level = slog.LevelError
+	}
+	ctx := context.Background()
+	if !l.h.Enabled(ctx, level) {
+		return
+	}
+	l.h.Handle(ctx, slog.NewRecord(time.Now(), level, msg, 0))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+)
+
+// labelPolicy decides which labels a target's metrics are exported with, to
+// keep the number of distinct series in the metrics backend under control.
+// The url label is always present but can be coarsened.
+type labelPolicy struct {
+	allow   map[string]bool // if non-nil, only these target labels are exported
+	deny    map[string]bool // these target labels are never exported
+	urlMode string          // "full", "host" (drop path and query) or "hash" (replace them by a short hash)
+}
+
+func newLabelPolicy(allow, deny []string, urlMode string) (labelPolicy, error) {
+	p := labelPolicy{urlMode: urlMode}
+	switch urlMode {
+	case "full", "host", "hash":
+	default:
+		return p, fmt.Errorf("metrics: unknown url label mode %q (want full, host or hash)", urlMode)
+	}
+	if len(allow) > 0 {
+		p.allow = make(map[string]bool)
+		for _, l := range allow {
+			p.allow[l] = true
+		}
+	}
+	p.deny = make(map[string]bool)
+	for _, l := range deny {
+		p.deny[l] = true
+	}
+	return p, nil
+}
+
+// url returns the value of the url label for rawURL.
+func (p labelPolicy) url(rawURL string) string {
+	if p.urlMode == "full" {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	rest := u.RequestURI()
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = "", "", "", ""
+	if p.urlMode == "host" || rest == "/" {
+		return u.String()
+	}
+	h := sha1.Sum([]byte(rest))
+	return u.String() + "/" + hex.EncodeToString(h[:4])
+}
+
+// reservedLabels are the label names the exporters set themselves. A target
+// label of one of these names is exported as exported_<name>, as Prometheus
+// renames the labels of a scraped target that clash with its own.
+var reservedLabels = map[string]bool{"url": true, "error_class": true}
+
+// labels returns the labels a State's metrics are exported with.
+func (p labelPolicy) labels(s State) map[string]string {
+	out := map[string]string{"url": p.url(s.url)}
+	for k, v := range s.target.labels {
+		if p.deny[k] || p.allow != nil && !p.allow[k] {
+			continue
+		}
+		if reservedLabels[k] {
+			k = "exported_" + k
+		}
+		out[k] = v
+	}
+	return out
+}
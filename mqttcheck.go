@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, in the high nibble of a packet's first byte.
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttDisconnect = 14
+)
+
+// mqttConnackErrors are the reasons a broker gives for refusing a connection.
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+/*
+mqttCheck checks an MQTT broker, mqtt://monitor@broker.internal:1883?password_file=mqtt with the
+password in that file of -secret-dir, or mqtts:// over TLS on 8883; a password written in the URL
+works too, and is masked wherever the target is named. It connects, which without more is the check; with topic=canary/sharemem it also
+subscribes to that topic, publishes a message to it and waits for the broker to deliver it
+back, reporting the round trip. The message is published at QoS 0 and not retained, so only
+subscribers of the canary topic see it.
+*/
+func mqttCheck(u *url.URL) string {
+	ctx, cancel, err := checkDeadline(u)
+	if err != nil {
+		return checkFail("mqtt: %v", err)
+	}
+	defer cancel()
+	useTLS := u.Scheme == "mqtts"
+	port := "1883"
+	if useTLS {
+		port = "8883"
+	}
+	start := time.Now()
+	conn, err := dialCheck(ctx, u, port, useTLS)
+	if err != nil {
+		return checkFail("mqtt: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 36)
+	var connect bytes.Buffer
+	mqttString(&connect, "MQTT")
+	flags := byte(0x02) // clean session
+	password, hasPassword := u.User.Password()
+	if u.User.Username() != "" {
+		flags |= 0x80
+		if hasPassword {
+			flags |= 0x40
+		}
+	}
+	connect.Write([]byte{4, flags, 0, 30}) // protocol level 4, keep alive 30s
+	mqttString(&connect, "sharemem-"+nonce)
+	if u.User.Username() != "" {
+		mqttString(&connect, u.User.Username())
+		if hasPassword {
+			mqttString(&connect, password)
+		}
+	}
+	if err := mqttWrite(conn, mqttConnect<<4, connect.Bytes()); err != nil {
+		return checkFail("mqtt: CONNECT: %v", err)
+	}
+	kind, body, err := mqttRead(r)
+	if err != nil {
+		return checkFail("mqtt: CONNACK: %v", err)
+	}
+	if kind != mqttConnack || len(body) != 2 {
+		return checkFail("mqtt: expected CONNACK, got packet type %d", kind)
+	}
+	if body[1] != 0 {
+		why, ok := mqttConnackErrors[body[1]]
+		if !ok {
+			why = fmt.Sprintf("return code %d", body[1])
+		}
+		return checkFail("mqtt: connection refused: %s", why)
+	}
+	connected := time.Since(start).Round(time.Millisecond)
+	defer mqttWrite(conn, mqttDisconnect<<4, nil)
+
+	topic := u.Query().Get("topic")
+	if topic == "" {
+		return checkOK("%s: connected in %s", u.Host, connected)
+	}
+	var subscribe bytes.Buffer
+	subscribe.Write([]byte{0, 1}) // packet identifier
+	mqttString(&subscribe, topic)
+	subscribe.WriteByte(0) // QoS 0
+	if err := mqttWrite(conn, mqttSubscribe<<4|0x02, subscribe.Bytes()); err != nil {
+		return checkFail("mqtt: SUBSCRIBE: %v", err)
+	}
+	kind, body, err = mqttRead(r)
+	if err != nil {
+		return checkFail("mqtt: SUBACK: %v", err)
+	}
+	if kind != mqttSuback || len(body) != 3 {
+		return checkFail("mqtt: expected SUBACK, got packet type %d", kind)
+	}
+	if body[2] == 0x80 {
+		return checkFail("mqtt: subscription to %s refused", topic)
+	}
+
+	sent := time.Now()
+	var publish bytes.Buffer
+	mqttString(&publish, topic)
+	publish.WriteString(nonce)
+	if err := mqttWrite(conn, mqttPublish<<4, publish.Bytes()); err != nil {
+		return checkFail("mqtt: PUBLISH: %v", err)
+	}
+	for {
+		kind, body, err := mqttRead(r)
+		if err != nil {
+			return checkFail("mqtt: no canary on %s: %v", topic, err)
+		}
+		if kind == mqttPublish && bytes.HasSuffix(body, []byte(nonce)) {
+			break
+		}
+	}
+	return checkOK("%s: connected in %s, canary on %s back in %s", u.Host, connected, topic,
+		time.Since(sent).Round(time.Millisecond))
+}
+
+// mqttString appends s as MQTT encodes strings, after its length.
+func mqttString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+// mqttWrite writes a control packet: its first byte, the remaining length as
+// a variable length integer, and body.
+func mqttWrite(w io.Writer, first byte, body []byte) error {
+	packet := []byte{first}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+// mqttRead reads a control packet, returning its type and what follows its
+// fixed header.
+func mqttRead(r *bufio.Reader) (kind byte, body []byte, err error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return first >> 4, body, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notifier delivers alerts to one destination: a webhook, a Slack channel or a
+// PagerDuty service.
+type notifier struct {
+	kind       string // "webhook", "slack" or "pagerduty"
+	url        string
+	routingKey string // PagerDuty's integration key
+}
+
+// pagerDutyURL is where PagerDuty's Events API v2 accepts events.
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+var notifyClient = &http.Client{Timeout: errTimeout}
+
+// webhookPayload is what a webhook notifier posts: the event in its wire
+// form, and how far its escalation has gone.
+type webhookPayload struct {
+	eventJSON
+	Step int `json:"escalation_step"`
+}
+
+// notify delivers e, raised at escalation step (counting from 1), or its
+// resolution when e is a RECOVERED event. It is meant to run on its own
+// goroutine.
+func (n notifier) notify(e Event, step int) error {
+	var body interface{}
+	url := n.url
+	switch n.kind {
+	case "slack":
+		body = map[string]string{"text": "*" + e.title() + "*\n" + e.text()}
+	case "pagerduty":
+		url = pagerDutyURL
+		action := "trigger"
+		if e.kind == eventRecovered {
+			action = "resolve"
+		}
+		severity := "critical"
+		if e.kind == eventDegrading {
+			severity = "warning"
+		}
+		body = map[string]interface{}{
+			"routing_key":  n.routingKey,
+			"event_action": action,
+			"dedup_key":    datadogAggregationKey(e.key()),
+			"payload": map[string]interface{}{
+				"summary":        e.title(),
+				"source":         e.key(),
+				"severity":       severity,
+				"timestamp":      e.time.UTC().Format(time.RFC3339),
+				"custom_details": map[string]string{"status": e.state.result.String(), "error_class": string(e.state.result.Class), "text": e.text()},
+			},
+		}
+	default:
+		body = webhookPayload{e.wire(), step}
+	}
+	return notifier{kind: n.kind, url: url}.post(body)
+}
+
+// post sends body as JSON to n's url.
+func (n notifier) post(body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(n.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s: %s", n.kind, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// ntpEpoch is where NTP timestamps start, 1900-01-01, in Unix time.
+const ntpEpoch = -2208988800
+
+/*
+ntpCheck asks an NTP server for the time, ntp://ntp.internal?warn=100ms&crit=1s, and reports its
+stratum and how far the local clock is off from it. Certificates and Kerberos tickets are only as
+good as the clocks checking them, so past crit (1s) the check is down, and past warn (100ms) it
+warns in its status. A server that is unsynchronized, or answers with a kiss-o'-death, is down.
+*/
+func ntpCheck(u *url.URL) string {
+	ctx, cancel, err := checkDeadline(u)
+	if err != nil {
+		return checkFail("ntp: %v", err)
+	}
+	defer cancel()
+	threshold := func(name string, def time.Duration) (time.Duration, error) {
+		if s := u.Query().Get(name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %v", name, err)
+			}
+			return d, nil
+		}
+		return def, nil
+	}
+	warn, err := threshold("warn", 100*time.Millisecond)
+	if err != nil {
+		return checkFail("ntp: %v", err)
+	}
+	crit, err := threshold("crit", time.Second)
+	if err != nil {
+		return checkFail("ntp: %v", err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "123")
+	}
+	d, err := checkDialer("udp", u)
+	if err != nil {
+		return checkFail("ntp: %v", err)
+	}
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return checkFail("ntp: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0<<6 | 4<<3 | 3 // no leap warning, version 4, client
+	sent := time.Now()
+	binary.BigEndian.PutUint64(req[40:], ntpTime(sent))
+	if _, err := conn.Write(req); err != nil {
+		return checkFail("ntp: %v", err)
+	}
+	resp := make([]byte, 48)
+	for {
+		n, err := conn.Read(resp)
+		if err != nil {
+			return checkFail("ntp: %v", err)
+		}
+		// The server echoes our transmit time; anything else is not our answer.
+		if n >= 48 && binary.BigEndian.Uint64(resp[24:]) == binary.BigEndian.Uint64(req[40:]) {
+			break
+		}
+	}
+	received := time.Now()
+
+	leap, stratum := resp[0]>>6, resp[1]
+	switch {
+	case stratum == 0:
+		return checkFail("ntp: %s: kiss-o'-death %q", addr, resp[12:16])
+	case leap == 3 || stratum >= 16:
+		return checkFail("ntp: %s: server is not synchronized", addr)
+	}
+	t2 := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	offset := (t2.Sub(sent) + t3.Sub(received)) / 2
+	rtt := received.Sub(sent) - t3.Sub(t2)
+	report := fmt.Sprintf("%s: stratum %d, offset %s, round trip %s", addr, stratum,
+		offset.Round(time.Microsecond), rtt.Round(time.Microsecond))
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs >= crit:
+		return checkFail("%s", report)
+	case abs >= warn:
+		return checkOK("warning: %s", report)
+	}
+	return checkOK("%s", report)
+}
+
+// ntpTime and fromNTPTime convert to and from NTP's 64-bit timestamps,
+// seconds since 1900 in the high half and the fraction in the low.
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() - ntpEpoch)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+func fromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) + ntpEpoch
+	nanos := int64((ts & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// The PollTarget custom resource, see deploy/polltarget-crd.yaml.
+const (
+	pollTargetGroup   = "sharemem.poll"
+	pollTargetVersion = "v1alpha1"
+)
+
+// pollTarget is the part of a PollTarget resource the operator reads.
+type pollTarget struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		URL     string `json:"url"`
+		Notes   string `json:"notes"`
+		Runbook string `json:"runbook"`
+	} `json:"spec"`
+}
+
+func (pt pollTarget) statusPath() string {
+	return "/apis/" + pollTargetGroup + "/" + pollTargetVersion + "/namespaces/" +
+		pt.Metadata.Namespace + "/polltargets/" + pt.Metadata.Name + "/status"
+}
+
+// kubeCondition is a standard metav1.Condition.
+type kubeCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+}
+
+// observation is what the operator last heard from the StateMonitor about a URL.
+type observation struct {
+	status string
+	since  time.Time // when the URL last went up or down
+}
+
+/*
+Operator keeps the Scheduler's "operator" targets in sync with the PollTarget resources in the
+cluster (or in namespace, when it is not empty) and writes each URL's state back to the status
+of every PollTarget that names it.
+It returns the channel on which it wants to hear about state changes; pass it to StateMonitor
+as a listener.
+One goroutine owns both the current set of PollTargets and the last observation for each URL,
+so the two can be joined without locking. The status writes themselves happen on a goroutine
+per PollTarget, because a slow API server must not hold up the StateMonitor: each waits on a
+slot holding only the latest status to write, so the writes to one resource never overtake one
+another and a backlog collapses into the newest.
+*/
+func Operator(kube *kubeClient, namespace string, targets chan<- TargetUpdate) chan<- State {
+	states := make(chan State)
+	snapshots := make(chan []pollTarget)
+
+	path := "/apis/" + pollTargetGroup + "/" + pollTargetVersion + "/polltargets"
+	if namespace != "" {
+		path = "/apis/" + pollTargetGroup + "/" + pollTargetVersion + "/namespaces/" + namespace + "/polltargets"
+	}
+	go kube.syncCollection(path, func(objs map[string]json.RawMessage) {
+		var pts []pollTarget
+		for key, raw := range objs {
+			var pt pollTarget
+			if err := json.Unmarshal(raw, &pt); err != nil || pt.Spec.URL == "" {
+				logger.Println("Error", "ignoring PollTarget", key, "without spec.url")
+				continue
+			}
+			pts = append(pts, pt)
+		}
+		sort.Slice(pts, func(i, j int) bool {
+			return pts[i].Metadata.Namespace+"/"+pts[i].Metadata.Name < pts[j].Metadata.Namespace+"/"+pts[j].Metadata.Name
+		})
+		snapshots <- pts
+	})
+
+	go func() {
+		var current []pollTarget
+		seen := make(map[string]int64) // namespace/name -> generation last reported on
+		observed := make(map[string]observation)
+		writers := make(map[string]chan statusWrite) // namespace/name -> the slot of its writer
+		write := func(pt pollTarget, o observation) {
+			key := pt.Metadata.Namespace + "/" + pt.Metadata.Name
+			slot, ok := writers[key]
+			if !ok {
+				slot = make(chan statusWrite, 1)
+				writers[key] = slot
+				go func() {
+					for w := range slot {
+						writeStatus(kube, w.pt, w.o)
+					}
+				}()
+			}
+			// Only this goroutine sends on slot, so once it is emptied
+			// the send cannot block.
+			select {
+			case <-slot:
+			default:
+			}
+			slot <- statusWrite{pt, o}
+		}
+		for {
+			select {
+			case pts := <-snapshots:
+				current = pts
+				ts := make([]Target, len(pts))
+				for i, pt := range pts {
+					ts[i] = Target{url: pt.Spec.URL, labels: pt.Metadata.Labels, notes: pt.Spec.Notes, runbook: pt.Spec.Runbook}
+				}
+				targets <- TargetUpdate{source: "operator", targets: ts}
+
+				// Report on resources that are new or whose spec changed.
+				live := make(map[string]int64)
+				for _, pt := range pts {
+					key := pt.Metadata.Namespace + "/" + pt.Metadata.Name
+					live[key] = pt.Metadata.Generation
+					if gen, ok := seen[key]; !ok || gen != pt.Metadata.Generation {
+						write(pt, observed[pt.Spec.URL])
+					}
+				}
+				seen = live
+				for key, slot := range writers {
+					if _, ok := live[key]; !ok {
+						select {
+						case <-slot:
+						default:
+						}
+						close(slot)
+						delete(writers, key)
+					}
+				}
+			case s := <-states:
+				if o, ok := observed[s.url]; ok && o.status == s.result.String() {
+					continue
+				}
+				o := observation{status: s.result.String(), since: s.since}
+				observed[s.url] = o
+				for _, pt := range current {
+					if (Target{url: pt.Spec.URL}).redacted().url == s.url {
+						write(pt, o)
+					}
+				}
+			}
+		}
+	}()
+	return states
+}
+
+// statusWrite is a status waiting to be written to a PollTarget.
+type statusWrite struct {
+	pt pollTarget
+	o  observation
+}
+
+// writeStatus sets the Available condition of pt from o. A zero observation
+// means the URL has not been polled yet.
+func writeStatus(kube *kubeClient, pt pollTarget, o observation) {
+	cond := kubeCondition{
+		Type:               "Available",
+		Status:             "Unknown",
+		ObservedGeneration: pt.Metadata.Generation,
+		LastTransitionTime: time.Now().UTC().Format(time.RFC3339),
+		Reason:             "Pending",
+		Message:            "not polled yet",
+	}
+	switch {
+	case o.status == statusPaused:
+		cond.Reason, cond.Message = "Paused", "polling is paused"
+		cond.LastTransitionTime = o.since.UTC().Format(time.RFC3339)
+	case o.status != "":
+		cond.Status, cond.Reason = "False", "Down"
+		if statusUp(o.status) {
+			cond.Status, cond.Reason = "True", "Up"
+		}
+		cond.LastTransitionTime = o.since.UTC().Format(time.RFC3339)
+		cond.Message = o.status
+	}
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{
+			"observedGeneration": pt.Metadata.Generation,
+			"lastStatus":         o.status,
+			"conditions":         []kubeCondition{cond},
+		},
+	}
+	if err := kube.mergePatch(pt.statusPath(), patch); err != nil {
+		logger.Println("Error", pt.Metadata.Namespace+"/"+pt.Metadata.Name, err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+portsCheck checks several TCP ports of one host in one go: ports://db.internal:5432,6432,9100 is
+up only if every port accepts a connection, and when some do not its status names those, and why.
+The ports are dialled in parallel, all within the check's timeout.
+*/
+func portsCheck(u *url.URL) string {
+	i := strings.LastIndex(u.Host, ":")
+	if i < 0 {
+		return checkFail("ports: no ports in %q", u.Host)
+	}
+	host := strings.Trim(u.Host[:i], "[]")
+	var ports []string
+	for _, p := range strings.Split(u.Host[i+1:], ",") {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return checkFail("ports: bad port %q", p)
+		}
+		ports = append(ports, p)
+	}
+	ctx, cancel, err := checkDeadline(u)
+	if err != nil {
+		return checkFail("ports: %v", err)
+	}
+	defer cancel()
+
+	type result struct {
+		port string
+		err  error
+	}
+	results := make(chan result, len(ports))
+	d, err := checkDialer("tcp", u)
+	if err != nil {
+		return checkFail("ports: %v", err)
+	}
+	for _, p := range ports {
+		go func(p string) {
+			c, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, p))
+			if err == nil {
+				c.Close()
+			}
+			results <- result{p, err}
+		}(p)
+	}
+	failed := make(map[string]string)
+	for range ports {
+		r := <-results
+		if r.err != nil {
+			failed[r.port] = dialFailure(r.err)
+		}
+	}
+	if len(failed) == 0 {
+		return checkOK("%s: %s open", host, strings.Join(ports, ", "))
+	}
+	var bad, open []string
+	for _, p := range ports {
+		if why, ok := failed[p]; ok {
+			bad = append(bad, p+" "+why)
+		} else {
+			open = append(open, p)
+		}
+	}
+	if len(open) == 0 {
+		return checkFail("%s: %s", host, strings.Join(bad, ", "))
+	}
+	return checkFail("%s: %s; %s open", host, strings.Join(bad, ", "), strings.Join(open, ", "))
+}
+
+// dialFailure describes briefly why a connection could not be made.
+func dialFailure(err error) string {
+	var dns *net.DNSError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded):
+		return "timed out"
+	case errors.As(err, &dns):
+		return "unresolvable"
+	case strings.Contains(err.Error(), "connection refused"):
+		return "refused"
+	}
+	return err.Error()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of process start times in /proc; 100 on
+// every Linux architecture that matters.
+const clockTicks = 100
+
+// listProcesses reads the process table from /proc. Processes that exit
+// while it is being read are left out.
+func listProcesses() ([]procInfo, error) {
+	boot, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	var procs []procInfo
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid ...: comm may itself hold spaces and
+		// parentheses, so the fields are counted from the last ')'.
+		open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		fields := strings.Fields(string(stat[end+1:]))
+		if len(fields) < 20 {
+			continue
+		}
+		ticks, _ := strconv.ParseUint(fields[19], 10, 64) // starttime, field 22
+		cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+		procs = append(procs, procInfo{
+			pid:     pid,
+			name:    string(stat[open+1 : end]),
+			cmdline: strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '}))),
+			started: boot.Add(time.Duration(ticks) * time.Second / clockTicks),
+			rss:     residentBytes(filepath.Join(dir, "status")),
+		})
+	}
+	return procs, nil
+}
+
+// bootTime reads when the system booted from /proc/stat.
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			secs, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(secs, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("no btime in /proc/stat")
+}
+
+// residentBytes returns the VmRSS of a /proc/<pid>/status file, 0 for kernel
+// threads and processes that have gone.
+func residentBytes(status string) uint64 {
+	b, err := os.ReadFile(status)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "VmRSS:"); ok {
+			kb, _ := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
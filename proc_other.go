@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// listProcesses is not implemented here.
+func listProcesses() ([]procInfo, error) {
+	return nil, errors.New("process checks are not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// procInfo is what the process table says of one process.
+type procInfo struct {
+	pid     int
+	name    string // the command name, as ps -o comm shows it
+	cmdline string // the arguments, space-separated
+	started time.Time
+	rss     uint64 // resident memory, in bytes
+}
+
+/*
+processCheck asks whether a process is running on this host, for the lightweight host-agent use:
+process:nginx looks for processes named nginx, process:?pattern=java.*kafka for ones whose
+command line matches a regexp, and process:?pidfile=/run/sshd.pid for the one whose PID a pidfile
+holds. It is down when fewer than min (1) processes match, and reports the uptime of the oldest
+and the resident memory of them all. Linux only.
+*/
+func processCheck(u *url.URL) string {
+	q := u.Query()
+	name := opaque(u)
+	var pattern *regexp.Regexp
+	if s := q.Get("pattern"); s != "" {
+		var err error
+		if pattern, err = regexp.Compile(s); err != nil {
+			return checkFail("process: pattern: %v", err)
+		}
+	}
+	pidfile := q.Get("pidfile")
+	min := 1
+	if s := q.Get("min"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return checkFail("process: min: %v", err)
+		}
+		min = n
+	}
+	what := name
+	wantPID := 0
+	switch {
+	case pidfile != "":
+		b, err := os.ReadFile(pidfile)
+		if err != nil {
+			return checkFail("process: %v", err)
+		}
+		if wantPID, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return checkFail("process: %s holds no PID", pidfile)
+		}
+		what = fmt.Sprintf("pid %d from %s", wantPID, pidfile)
+	case pattern != nil:
+		what = "/" + pattern.String() + "/"
+	case name == "":
+		return checkFail("process: want a name, pattern= or pidfile=")
+	}
+
+	procs, err := listProcesses()
+	if err != nil {
+		return checkFail("process: %v", err)
+	}
+	var matched []procInfo
+	for _, p := range procs {
+		switch {
+		case wantPID != 0 && p.pid != wantPID:
+		case pattern != nil && !pattern.MatchString(p.cmdline):
+		case wantPID == 0 && pattern == nil && p.name != name:
+		default:
+			matched = append(matched, p)
+		}
+	}
+	if len(matched) < min {
+		return checkFail("%s: %s running, want at least %d", what, processes(len(matched)), min)
+	}
+	if len(matched) == 0 {
+		return checkOK("%s: no process running", what)
+	}
+	oldest := matched[0]
+	var rss uint64
+	for _, p := range matched {
+		rss += p.rss
+		if p.started.Before(oldest.started) {
+			oldest = p
+		}
+	}
+	return checkOK("%s: %s, pid %d up %s, RSS %s", what, processes(len(matched)), oldest.pid,
+		time.Since(oldest.started).Round(time.Second), byteSize(rss))
+}
+
+func processes(n int) string {
+	if n == 1 {
+		return "1 process"
+	}
+	return strconv.Itoa(n) + " processes"
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"example/concurrent/chanutil"
+)
+
+// promSample is the latest poll of one URL, as PrometheusSink keeps it.
+type promSample struct {
+	labels  map[string]string
+	up      bool
+	latency time.Duration
+	class   errorClass // of a failed poll
+}
+
+/*
+PrometheusSink keeps the latest poll result of every target and serves them in the Prometheus
+text format as the gauges sharemem_poll_up and sharemem_poll_latency_seconds, with labels chosen
+by policy, and for a target that is down, sharemem_poll_failure with the class of its failure.
+It returns the channel on which it wants to hear about state changes, to be passed to
+StateMonitor as a listener, and the Prometheus whose Handler serves /metrics.
+When the policy coarsens the url label several targets can share a series; such a series
+reports the worst of them, i.e. 0 if any is down and the highest latency.
+The handler asks the sink's goroutine for the rendered page, so the samples are never shared.
+*/
+func PrometheusSink(policy labelPolicy) (chan<- State, *Prometheus) {
+	states := make(chan State)
+	p := &Prometheus{scrapes: make(chan promScrape)}
+	go func() {
+		samples := make(map[string]promSample)
+		for {
+			select {
+			case s := <-states:
+				// Paused targets drop out rather than showing up as down.
+				if s.result.Paused() {
+					delete(samples, s.url)
+					continue
+				}
+				samples[s.url] = promSample{labels: policy.labels(s), up: s.result.Up, latency: s.result.Latency, class: s.result.Class}
+			case sc := <-p.scrapes:
+				for url := range samples {
+					if !sc.live[url] {
+						delete(samples, url)
+					}
+				}
+				sc.reply <- renderPrometheus(samples)
+			}
+		}
+	}()
+	return states, p
+}
+
+// Prometheus is a running PrometheusSink.
+type Prometheus struct {
+	scrapes chan promScrape
+}
+
+type promScrape struct {
+	live  map[string]bool // the targets the Scheduler has; the samples of any other are dropped
+	reply chan string
+}
+
+// Handler returns the handler to mount at /metrics. Every scrape asks the
+// Scheduler on controls which targets it has, and the samples of those it
+// has retired, by discovery or a reload, are dropped then rather than
+// exported for ever. Asking at scrape time, rather than being told of each
+// retirement, means a late result of a retired target is gone again by the
+// next scrape.
+func (p *Prometheus) Handler(controls chan<- schedulerControl) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := make(chan controlReply)
+		controls <- schedulerControl{op: "list", reply: list}
+		rep := <-list
+		live := make(map[string]bool, len(rep.targets))
+		for _, t := range rep.targets {
+			live[t.target.url] = true
+		}
+		reply := make(chan string)
+		p.scrapes <- promScrape{live: live, reply: reply}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, <-reply)
+	})
+}
+
+func renderPrometheus(samples map[string]promSample) string {
+	type series struct {
+		up      bool
+		latency time.Duration
+		labels  map[string]string
+		classes map[errorClass]bool
+	}
+	merged := make(map[string]series)
+	for _, s := range samples {
+		key := promLabels(s.labels)
+		m, ok := merged[key]
+		if !ok {
+			m = series{up: true, labels: s.labels}
+		}
+		m.up = m.up && s.up
+		if s.latency > m.latency {
+			m.latency = s.latency
+		}
+		if s.class != "" {
+			if m.classes == nil {
+				m.classes = make(map[errorClass]bool)
+			}
+			m.classes[s.class] = true
+		}
+		merged[key] = m
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("# HELP sharemem_poll_up Whether the last poll found the target up.\n")
+	b.WriteString("# TYPE sharemem_poll_up gauge\n")
+	for _, k := range keys {
+		v := 0
+		if merged[k].up {
+			v = 1
+		}
+		fmt.Fprintf(&b, "sharemem_poll_up%s %d\n", k, v)
+	}
+	b.WriteString("# HELP sharemem_poll_latency_seconds How long the last poll of the target took.\n")
+	b.WriteString("# TYPE sharemem_poll_latency_seconds gauge\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "sharemem_poll_latency_seconds%s %g\n", k, merged[k].latency.Seconds())
+	}
+	b.WriteString("# HELP sharemem_poll_failure Why the last poll of a target that is down failed, by class: dns, connect, tls, timeout, http, assertion or other.\n")
+	b.WriteString("# TYPE sharemem_poll_failure gauge\n")
+	for _, k := range keys {
+		classes := make([]string, 0, len(merged[k].classes))
+		for c := range merged[k].classes {
+			classes = append(classes, string(c))
+		}
+		sort.Strings(classes)
+		for _, c := range classes {
+			labels := map[string]string{"error_class": c}
+			for name, v := range merged[k].labels {
+				labels[name] = v
+			}
+			fmt.Fprintf(&b, "sharemem_poll_failure%s 1\n", promLabels(labels))
+		}
+	}
+	return b.String()
+}
+
+// promLabels renders labels as {k="v",...} in a stable order, turning label
+// names Prometheus would reject into valid ones.
+func promLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, k := range names {
+		parts[i] = promName(k) + `="` + promEscaper.Replace(labels[k]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		ok := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9'
+		if !ok {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// dropCounter is a channel that discards values rather than wait for its
+// reader, and the way to ask how many it has discarded so far.
+type dropCounter struct {
+	channel string
+	dropped func() uint64
+}
+
+/*
+withChannelMetrics follows the page h serves with the metrics of the channels between the
+stages: how many values each of drops has discarded, as sharemem_channel_dropped_total, so that
+monitoring data is never lost silently, and the state of the queue in front of the Pollers, if
+there is one.
+*/
+func withChannelMetrics(h http.Handler, q *chanutil.Queue[*Resource], drops []dropCounter) http.Handler {
+	if q == nil && len(drops) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		if len(drops) > 0 {
+			fmt.Fprint(w, "# HELP sharemem_channel_dropped_total Values a channel discarded because its reader was not keeping up.\n# TYPE sharemem_channel_dropped_total counter\n")
+			for _, d := range drops {
+				fmt.Fprintf(w, "sharemem_channel_dropped_total{channel=%q} %d\n", d.channel, d.dropped())
+			}
+		}
+		if q == nil {
+			return
+		}
+		s := q.Stats()
+		fmt.Fprintf(w, "# HELP sharemem_queue_depth Resources waiting for a Poller.\n# TYPE sharemem_queue_depth gauge\nsharemem_queue_depth %d\n", s.Depth)
+		fmt.Fprintf(w, "# HELP sharemem_queue_capacity How many Resources the queue holds.\n# TYPE sharemem_queue_capacity gauge\nsharemem_queue_capacity %d\n", s.Capacity)
+		fmt.Fprintf(w, "# HELP sharemem_queue_enqueued_total Resources queued for a Poller.\n# TYPE sharemem_queue_enqueued_total counter\nsharemem_queue_enqueued_total %d\n", s.Enqueued)
+		fmt.Fprintf(w, "# HELP sharemem_queue_rejected_total Polls skipped because the queue was full.\n# TYPE sharemem_queue_rejected_total counter\nsharemem_queue_rejected_total %d\n", s.Rejected)
+		fmt.Fprintf(w, "# HELP sharemem_queue_enqueue_wait_seconds_total Time spent waiting for room in the queue.\n# TYPE sharemem_queue_enqueue_wait_seconds_total counter\nsharemem_queue_enqueue_wait_seconds_total %g\n", s.EnqueueWait.Seconds())
+		fmt.Fprintf(w, "# HELP sharemem_queue_dequeued_total Resources taken from the queue by a Poller.\n# TYPE sharemem_queue_dequeued_total counter\nsharemem_queue_dequeued_total %d\n", s.Dequeued)
+		fmt.Fprintf(w, "# HELP sharemem_queue_wait_seconds_total Time Resources spent in the queue before a Poller took them.\n# TYPE sharemem_queue_wait_seconds_total counter\nsharemem_queue_wait_seconds_total %g\n", s.QueueWait.Seconds())
+	})
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// quiesceOnSignal pauses all polling on SIGUSR1 and resumes it on SIGUSR2,
+// recording each change in audit.
+func quiesceOnSignal(controls chan<- schedulerControl, audit *AuditLog) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			op := "quiesce"
+			if sig == syscall.SIGUSR2 {
+				op = "unquiesce"
+			}
+			reply := make(chan controlReply)
+			controls <- schedulerControl{op: op, reply: reply}
+			rep := <-reply
+			audit.add(auditEntry{
+				Principal: "signal:" + sig.String(),
+				Action:    op,
+				Before:    map[string]bool{"quiesced": (op == "quiesce") != rep.changed},
+				After:     map[string]bool{"quiesced": op == "quiesce"},
+			})
+		}
+	}()
+}
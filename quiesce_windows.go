@@ -0,0 +1,5 @@
+package main
+
+// quiesceOnSignal does nothing: Windows has no SIGUSR1 or SIGUSR2, so
+// polling is quiesced only through the API there.
+func quiesceOnSignal(controls chan<- schedulerControl, audit *AuditLog) {}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"unsafe"
+)
+
+// Rough costs, in bytes, of what the recent store holds besides the text in
+// it: a result with its list element, and a target's entry in the map.
+const (
+	recentResultCost = int64(unsafe.Sizeof(Result{})+unsafe.Sizeof(list.Element{})) + 16
+	recentTargetCost = 128
+)
+
+// recentTarget is the recent results of one target, oldest first, as
+// elements of the store's list.
+type recentTarget struct {
+	results []*list.Element
+}
+
+// recentEntry is what the store's list holds.
+type recentEntry struct {
+	url    string
+	result Result
+	cost   int64
+}
+
+// recentStats are the recent store's numbers, for /metrics.
+type recentStats struct {
+	targets, results int
+	bytes            int64
+	evictedTarget    uint64 // results dropped for the per-target cap
+	evictedMemory    uint64 // results dropped for the memory cap
+}
+
+type recentQuery struct {
+	url   string
+	reply chan []Result
+}
+
+/*
+Recent keeps the last results of every target in memory, so that the API can show how a target
+has been doing without -history-dir. Both what it keeps per target and what it keeps in all are
+capped, so that a poller watching fifty thousand targets has a predictable footprint: a target's
+oldest result goes once it has perTarget of them, and the oldest results of all, whichever
+targets they belong to, go while the estimated size of the store is over maxBytes. Evictions are
+counted on /metrics.
+One goroutine owns the results; a list of all of them in arrival order tells it which is oldest.
+*/
+type Recent struct {
+	queries chan recentQuery
+	stats   chan chan recentStats
+}
+
+// RecentStore starts a Recent and returns the channel on which it wants to
+// hear about results, to be passed to StateMonitor as a listener.
+func RecentStore(perTarget int, maxBytes int64) (chan<- State, *Recent) {
+	states := make(chan State)
+	rc := &Recent{queries: make(chan recentQuery), stats: make(chan chan recentStats)}
+	go func() {
+		targets := make(map[string]*recentTarget)
+		all := list.New()
+		var st recentStats
+		// drop removes its target's oldest result, which e must be.
+		drop := func(e *list.Element) {
+			en := all.Remove(e).(recentEntry)
+			st.bytes -= en.cost
+			t := targets[en.url]
+			t.results[0] = nil
+			t.results = t.results[1:]
+			if len(t.results) == 0 {
+				delete(targets, en.url)
+				st.bytes -= recentTargetCost + int64(len(en.url))
+			}
+		}
+		for {
+			select {
+			case s := <-states:
+				if s.result.Paused() {
+					continue
+				}
+				t, ok := targets[s.url]
+				if !ok {
+					t = &recentTarget{}
+					targets[s.url] = t
+					st.bytes += recentTargetCost + int64(len(s.url))
+				}
+				en := recentEntry{url: s.url, result: s.result, cost: recentResultCost + int64(len(s.result.text))}
+				if s.result.Err != nil && s.result.Err.Error() != s.result.text {
+					en.cost += int64(len(s.result.Err.Error()))
+				}
+				t.results = append(t.results, all.PushBack(en))
+				st.bytes += en.cost
+				for len(t.results) > perTarget {
+					drop(t.results[0])
+					st.evictedTarget++
+				}
+				for st.bytes > maxBytes && all.Len() > 1 {
+					drop(all.Front())
+					st.evictedMemory++
+				}
+			case q := <-rc.queries:
+				var rs []Result
+				if t, ok := targets[q.url]; ok {
+					rs = make([]Result, len(t.results))
+					for i, e := range t.results {
+						rs[i] = e.Value.(recentEntry).result
+					}
+				}
+				q.reply <- rs
+			case reply := <-rc.stats:
+				st.targets, st.results = len(targets), all.Len()
+				reply <- st
+			}
+		}
+	}()
+	return states, rc
+}
+
+// Results returns the results Recent holds for url, oldest first.
+func (rc *Recent) Results(url string) []Result {
+	reply := make(chan []Result)
+	rc.queries <- recentQuery{url: url, reply: reply}
+	return <-reply
+}
+
+// ServeHTTP answers GET /recent?target=URL with the results held for the
+// target, newest first, and since when they go back.
+func (rc *Recent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	url := r.FormValue("target")
+	if url == "" {
+		http.Error(w, "target: missing", http.StatusBadRequest)
+		return
+	}
+	rs := rc.Results(url)
+	if rs == nil {
+		http.Error(w, url+": no results held", http.StatusNotFound)
+		return
+	}
+	out := make([]Result, len(rs))
+	for i, res := range rs {
+		res.Timestamp = displayed(res.Timestamp)
+		out[len(rs)-1-i] = res
+	}
+	writeJSON(w, map[string]interface{}{"target": url, "since": displayed(rs[0].Timestamp), "results": out})
+}
+
+// withRecentMetrics follows the page h serves with the size of rc and what
+// it has evicted.
+func withRecentMetrics(h http.Handler, rc *Recent) http.Handler {
+	if rc == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		reply := make(chan recentStats)
+		rc.stats <- reply
+		st := <-reply
+		fmt.Fprintf(w, "# HELP sharemem_recent_results Poll results held in memory.\n# TYPE sharemem_recent_results gauge\nsharemem_recent_results %d\n", st.results)
+		fmt.Fprintf(w, "# HELP sharemem_recent_targets Targets with results held in memory.\n# TYPE sharemem_recent_targets gauge\nsharemem_recent_targets %d\n", st.targets)
+		fmt.Fprintf(w, "# HELP sharemem_recent_bytes Estimated size of the results held in memory.\n# TYPE sharemem_recent_bytes gauge\nsharemem_recent_bytes %d\n", st.bytes)
+		fmt.Fprint(w, "# HELP sharemem_recent_evicted_total Results dropped from memory, by the cap that dropped them.\n# TYPE sharemem_recent_evicted_total counter\n")
+		fmt.Fprintf(w, "sharemem_recent_evicted_total{cap=\"target\"} %d\nsharemem_recent_evicted_total{cap=\"memory\"} %d\n", st.evictedTarget, st.evictedMemory)
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Report formats.
+const (
+	formatText     = "text"
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+)
+
+// reportFuncs are the functions available to report templates.
+var reportFuncs = map[string]interface{}{
+	"pct":  func(f float64) string { return fmt.Sprintf("%.3f%%", f) },
+	"ms":   func(f float64) string { return fmt.Sprintf("%.0fms", f) },
+	"time": func(t time.Time) string { return t.In(displayLocation).Format("2006-01-02 15:04 MST") },
+	"dur":  func(secs float64) string { return (time.Duration(secs) * time.Second).String() },
+	"join": strings.Join,
+}
+
+const markdownReport = `# Uptime report
+
+{{time .From}} to {{time .To}}
+
+## Uptime
+
+| Target | Uptime | Polls | Avg latency | Max latency |
+|---|---:|---:|---:|---:|
+{{range .Targets}}| {{.URL}} | {{pct .Uptime}} | {{.Polls}} | {{ms .LatencyAvgMS}} | {{ms .LatencyMaxMS}} |
+{{else}}| no polls recorded | | | | |
+{{end}}
+## Slowest targets
+
+{{range .Slowest}}- {{.URL}}: {{ms .LatencyMaxMS}}
+{{end}}{{if .Flapping}}
+## Flapping
+
+{{range .Flapping}}- {{.URL}} changed state {{.Flips}} times
+{{end}}{{end}}
+## Incidents
+
+{{len .Opened}} opened, {{len .Resolved}} resolved, {{len .StillOpen}} still open.
+
+{{range .Opened}}- **{{.ID}}** {{.Title}}: opened {{time .Opened}}, {{.Status}}, {{dur .Duration}} ({{join .Targets ", "}})
+{{end}}`
+
+const htmlReport = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Uptime report</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:4px 8px}td.n{text-align:right}.down{color:#b00}</style>
+</head><body>
+<h1>Uptime report</h1>
+<p>{{time .From}} to {{time .To}}</p>
+<h2>Uptime</h2>
+<table><tr><th>Target</th><th>Uptime</th><th>Polls</th><th>Avg latency</th><th>Max latency</th></tr>
+{{range .Targets}}<tr><td>{{.URL}}</td><td class="n{{if lt .Uptime 99.0}} down{{end}}">{{pct .Uptime}}</td><td class="n">{{.Polls}}</td><td class="n">{{ms .LatencyAvgMS}}</td><td class="n">{{ms .LatencyMaxMS}}</td></tr>
+{{else}}<tr><td colspan="5">no polls recorded</td></tr>
+{{end}}</table>
+<h2>Slowest targets</h2>
+<ol>{{range .Slowest}}<li>{{.URL}}: {{ms .LatencyMaxMS}}</li>{{end}}</ol>
+{{if .Flapping}}<h2>Flapping</h2>
+<ul>{{range .Flapping}}<li>{{.URL}} changed state {{.Flips}} times</li>{{end}}</ul>
+{{end}}<h2>Incidents</h2>
+<p>{{len .Opened}} opened, {{len .Resolved}} resolved, {{len .StillOpen}} still open.</p>
+<ul>{{range .Opened}}<li><b>{{.ID}}</b> {{.Title}}: opened {{time .Opened}}, {{.Status}}, {{dur .Duration}} ({{join .Targets ", "}})</li>{{end}}</ul>
+</body></html>
+`
+
+// reportRenderer renders summaries in one format, from the built-in template
+// for it or from a template file.
+type reportRenderer struct {
+	format string
+	md     *texttemplate.Template
+	html   *htmltemplate.Template
+}
+
+// newReportRenderer returns the renderer for format, using the template in
+// the file at path instead of the built-in one unless path is empty. Templates
+// are executed with a summary and can use the functions pct, ms, time, dur and
+// join.
+func newReportRenderer(format, path string) (*reportRenderer, error) {
+	r := &reportRenderer{format: format}
+	src := ""
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		src = string(b)
+	}
+	var err error
+	switch format {
+	case formatText:
+		if path != "" {
+			return nil, fmt.Errorf("text reports have no template; use %s or %s", formatMarkdown, formatHTML)
+		}
+	case formatMarkdown:
+		if src == "" {
+			src = markdownReport
+		}
+		r.md, err = texttemplate.New("report").Funcs(reportFuncs).Parse(src)
+	case formatHTML:
+		if src == "" {
+			src = htmlReport
+		}
+		r.html, err = htmltemplate.New("report").Funcs(reportFuncs).Parse(src)
+	default:
+		return nil, fmt.Errorf("unknown report format %q: want %s, %s or %s", format, formatText, formatMarkdown, formatHTML)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("report template: %v", err)
+	}
+	return r, nil
+}
+
+// render returns sum in r's format.
+func (r *reportRenderer) render(sum summary) ([]byte, error) {
+	var b bytes.Buffer
+	var err error
+	switch r.format {
+	case formatMarkdown:
+		err = r.md.Execute(&b, sum)
+	case formatHTML:
+		err = r.html.Execute(&b, sum)
+	default:
+		b.WriteString(sum.text())
+	}
+	return b.Bytes(), err
+}
+
+// contentType is the MIME type of r's output.
+func (r *reportRenderer) contentType() string {
+	switch r.format {
+	case formatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case formatHTML:
+		return "text/html; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// extension is the file name extension of r's output.
+func (r *reportRenderer) extension() string {
+	switch r.format {
+	case formatMarkdown:
+		return ".md"
+	case formatHTML:
+		return ".html"
+	}
+	return ".txt"
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	reportFlapFlips = 4 // up/down changes in a period that make a target flapping
+	reportWorst     = 5 // how many of the slowest targets a summary names
+)
+
+// targetSummary is how one target did over a report's period.
+type targetSummary struct {
+	URL          string  `json:"url"`
+	Polls        int     `json:"polls"`
+	Up           int     `json:"up"`
+	Uptime       float64 `json:"uptime_percent"`
+	LatencyAvgMS float64 `json:"latency_avg_ms"`
+	LatencyMaxMS float64 `json:"latency_max_ms"`
+	Flips        int     `json:"flips"` // changes between up and down, from raw history only
+}
+
+// summary is the report of how every target did between From and To.
+type summary struct {
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Targets   []targetSummary `json:"targets"`
+	Slowest   []targetSummary `json:"slowest"`
+	Flapping  []targetSummary `json:"flapping"`
+	Opened    []Incident      `json:"incidents_opened"`
+	Resolved  []Incident      `json:"incidents_resolved"`
+	StillOpen []Incident      `json:"incidents_open"`
+}
+
+/*
+buildSummary summarises the history between from and to, using the raw results where they are
+still kept and the hourly rollups before that, together with the incidents opened and resolved in
+that time. Paused polls do not count. in may be nil.
+*/
+func buildSummary(h *History, in *Incidents, from, to time.Time) (summary, error) {
+	sum := summary{From: from, To: to, Targets: []targetSummary{}}
+	byURL := make(map[string]*targetSummary)
+	get := func(url string) *targetSummary {
+		ts, ok := byURL[url]
+		if !ok {
+			ts = &targetSummary{URL: url}
+			byURL[url] = ts
+		}
+		return ts
+	}
+	rolled := make(map[string]bool) // url and hour already counted from a rollup
+	rollups, err := h.Rollups("", from, to)
+	if err != nil {
+		return sum, err
+	}
+	for _, ru := range rollups {
+		ts := get(ru.URL)
+		ts.LatencyAvgMS += ru.LatencyAvgMS * float64(ru.Polls) // summed here, divided below
+		ts.Polls += ru.Polls
+		ts.Up += ru.Up
+		if ru.LatencyMaxMS > ts.LatencyMaxMS {
+			ts.LatencyMaxMS = ru.LatencyMaxMS
+		}
+		rolled[ru.URL+"|"+ru.Hour.UTC().Format(rawSegmentLayout)] = true
+	}
+	records, _, err := h.Query(historyQuery{from: from, to: to, limit: int(^uint(0) >> 1)})
+	if err != nil {
+		return sum, err
+	}
+	last := make(map[string]bool)
+	for _, r := range records {
+		if r.Status == statusPaused || rolled[r.URL+"|"+r.Time.UTC().Format(rawSegmentLayout)] {
+			continue
+		}
+		ts := get(r.URL)
+		ts.Polls++
+		ts.LatencyAvgMS += r.LatencyMS
+		if r.Up {
+			ts.Up++
+		}
+		if r.LatencyMS > ts.LatencyMaxMS {
+			ts.LatencyMaxMS = r.LatencyMS
+		}
+		if prev, ok := last[r.URL]; ok && prev != r.Up {
+			ts.Flips++
+		}
+		last[r.URL] = r.Up
+	}
+	for _, ts := range byURL {
+		if ts.Polls > 0 {
+			ts.LatencyAvgMS /= float64(ts.Polls)
+			ts.Uptime = 100 * float64(ts.Up) / float64(ts.Polls)
+		}
+		sum.Targets = append(sum.Targets, *ts)
+	}
+	sort.Slice(sum.Targets, func(i, j int) bool { return sum.Targets[i].URL < sum.Targets[j].URL })
+
+	sum.Slowest = append([]targetSummary{}, sum.Targets...)
+	sort.SliceStable(sum.Slowest, func(i, j int) bool { return sum.Slowest[i].LatencyMaxMS > sum.Slowest[j].LatencyMaxMS })
+	if len(sum.Slowest) > reportWorst {
+		sum.Slowest = sum.Slowest[:reportWorst]
+	}
+	sum.Flapping = []targetSummary{}
+	for _, ts := range sum.Targets {
+		if ts.Flips >= reportFlapFlips {
+			sum.Flapping = append(sum.Flapping, ts)
+		}
+	}
+
+	sum.Opened, sum.Resolved, sum.StillOpen = []Incident{}, []Incident{}, []Incident{}
+	if in != nil {
+		for _, inc := range in.List() {
+			if !inc.Opened.Before(from) && inc.Opened.Before(to) {
+				sum.Opened = append(sum.Opened, inc)
+			}
+			if inc.Resolved != nil && !inc.Resolved.Before(from) && inc.Resolved.Before(to) {
+				sum.Resolved = append(sum.Resolved, inc)
+			}
+			if inc.Opened.Before(to) && (inc.Resolved == nil || !inc.Resolved.Before(to)) {
+				sum.StillOpen = append(sum.StillOpen, inc)
+			}
+		}
+	}
+	return sum, nil
+}
+
+// text renders sum as plain text, with times in displayLocation.
+func (sum summary) text() string {
+	var b strings.Builder
+	layout := "2006-01-02 15:04 MST"
+	fmt.Fprintf(&b, "Summary for %s to %s\n", sum.From.In(displayLocation).Format(layout), sum.To.In(displayLocation).Format(layout))
+	b.WriteString("\nUptime:\n")
+	for _, ts := range sum.Targets {
+		fmt.Fprintf(&b, "  %7.3f%%  %s (%d polls, %.0fms avg)\n", ts.Uptime, ts.URL, ts.Polls, ts.LatencyAvgMS)
+	}
+	if len(sum.Targets) == 0 {
+		b.WriteString("  no polls recorded\n")
+	}
+	b.WriteString("\nSlowest:\n")
+	for _, ts := range sum.Slowest {
+		fmt.Fprintf(&b, "  %8.0fms  %s\n", ts.LatencyMaxMS, ts.URL)
+	}
+	if len(sum.Flapping) > 0 {
+		b.WriteString("\nFlapping:\n")
+		for _, ts := range sum.Flapping {
+			fmt.Fprintf(&b, "  %s changed state %d times\n", ts.URL, ts.Flips)
+		}
+	}
+	fmt.Fprintf(&b, "\nIncidents: %d opened, %d resolved, %d still open\n", len(sum.Opened), len(sum.Resolved), len(sum.StillOpen))
+	for _, inc := range sum.Opened {
+		fmt.Fprintf(&b, "  %s %s, opened %s, %s\n", inc.ID, inc.Title, inc.Opened.In(displayLocation).Format(layout), inc.Status)
+	}
+	return b.String()
+}
+
+// reportDestination is where a scheduled summary is delivered: "file:DIR",
+// "slack:WEBHOOK-URL" or "mailto:ADDRESS".
+type reportDestination struct {
+	kind, to string
+}
+
+func parseReportDestinations(list []string) ([]reportDestination, error) {
+	var out []reportDestination
+	for _, s := range list {
+		kind, to, ok := strings.Cut(s, ":")
+		if !ok || to == "" || kind != "file" && kind != "slack" && kind != "mailto" {
+			return nil, fmt.Errorf("bad report destination %q: want file:DIR, slack:URL or mailto:ADDRESS", s)
+		}
+		if kind == "mailto" && *smtpAddr == "" {
+			return nil, errors.New("mailing reports needs -smtp")
+		}
+		out = append(out, reportDestination{kind, to})
+	}
+	return out, nil
+}
+
+// deliver sends sum rendered by r to d. Slack gets plain text in place of HTML.
+func (d reportDestination) deliver(sum summary, r *reportRenderer) error {
+	body, err := r.render(sum)
+	if err != nil {
+		return err
+	}
+	switch d.kind {
+	case "file":
+		name := "summary-" + sum.To.In(displayLocation).Format("20060102T1504") + r.extension()
+		return os.WriteFile(filepath.Join(d.to, name), body, 0o644)
+	case "slack":
+		text := string(body)
+		switch r.format {
+		case formatHTML:
+			text = "```\n" + sum.text() + "```"
+		case formatText:
+			text = "```\n" + text + "```"
+		}
+		return notifier{kind: "slack", url: d.to}.post(map[string]string{"text": text})
+	}
+	return sendMail(d.to, "Uptime summary to "+sum.To.In(displayLocation).Format("2006-01-02"), r.contentType(), string(body))
+}
+
+// sendMail mails a message through the -smtp relay, authenticating with
+// $SMTP_USERNAME and $SMTP_PASSWORD when they are set.
+func sendMail(to, subject, contentType, body string) error {
+	from := *smtpFrom
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: %s\r\n\r\n",
+		from, to, subject, time.Now().Format(time.RFC1123Z), contentType)
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USERNAME"); user != "" {
+		host := strings.Split(*smtpAddr, ":")[0]
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return smtp.SendMail(*smtpAddr, auth, from, []string{to}, msg.Bytes())
+}
+
+// period returns when the report due at to starts: one day, week or month
+// before it, by the schedule's wall clock.
+func (sc schedule) period(to time.Time) time.Time {
+	t := to.In(sc.loc)
+	switch sc.every {
+	case "weekly":
+		return t.AddDate(0, 0, -7)
+	case "monthly":
+		return t.AddDate(0, -1, 0)
+	}
+	return t.AddDate(0, 0, -1)
+}
+
+/*
+Reporter delivers a summary of the period just ended, rendered by r, to each of the destinations
+every time sc comes round. It sleeps on its own goroutine until the next occurrence, so it needs nothing from
+the rest of the program but the history and incidents it reads.
+*/
+func Reporter(sc schedule, h *History, in *Incidents, r *reportRenderer, destinations []reportDestination) {
+	go func() {
+		for {
+			due := sc.next(time.Now())
+			time.Sleep(time.Until(due))
+			sum, err := buildSummary(h, in, sc.period(due), due)
+			if err != nil {
+				logger.Println("Error", "report", err)
+				continue
+			}
+			for _, d := range destinations {
+				if err := d.deliver(sum, r); err != nil {
+					logger.Println("Error", "report", d.kind+":"+d.to, err)
+				}
+			}
+			logger.Printf("Report for %s sent to %d destinations", sc, len(destinations))
+		}
+	}()
+}
+
+// reportAPI answers GET /report?from=&to=&format= with the summary of that
+// range rendered with the built-in template for text, markdown or html (text
+// by default), the range defaulting to the last day as for /history.
+func reportAPI(h *History, in *Incidents) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v := r.URL.Query()
+		to := time.Now().UTC()
+		var err error
+		if s := v.Get("to"); s != "" {
+			if to, err = parseTime(s); err != nil {
+				http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		from := to.Add(-historyDefaultRange)
+		if s := v.Get("from"); s != "" {
+			if from, err = parseTime(s); err != nil {
+				http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		format := v.Get("format")
+		if format == "" {
+			format = formatText
+		}
+		renderer, err := newReportRenderer(format, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum, err := buildSummary(h, in, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body, err := renderer.render(sum)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", renderer.contentType())
+		w.Write(body)
+	})
+}
+
+// reportCommand implements "report [-from t] [-to t] [-format f]", which
+// prints the report of a running poller for a time range. It returns the
+// process exit code.
+func reportCommand(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	api := fs.String("api", defaultAPI, "`URL` of the running poller's API")
+	from := fs.String("from", "", "start of the range, RFC 3339, Unix seconds or YYYY-MM-DD[THH:MM] (default: a day before -to)")
+	to := fs.String("to", "", "end of the range (default: now)")
+	format := fs.String("format", formatText, "report `format`: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	q := url.Values{"format": {*format}}
+	for k, v := range map[string]string{"from": *from, "to": *to} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	return apiGet(*api, "/report?"+q.Encode(), os.Stdout)
+}
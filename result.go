@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Result is the outcome of one poll of a target, as it travels from the Poller through the
+StateMonitor to its listeners. Up says whether the target counts as reachable; Code is the HTTP
+status code it answered with, 0 for checks and for targets that did not answer; Err is why it is
+not up, nil when it is, and Class what kind of failure that was. String gives the status text
+the poller has always logged, such as "200 OK", "OK 3 items" or the error of a failed request,
+which is also what the API, history and snapshots store.
+*/
+type Result struct {
+	Up        bool
+	Code      int
+	Err       error
+	Class     errorClass    // empty when up
+	Latency   time.Duration // how long the poll took, successful or not
+	Timestamp time.Time     // when the poll started, to the nanosecond; when the target was paused
+	Attempt   int           // which poll of the target this was, counting from 1
+	text      string
+}
+
+func (r Result) String() string { return r.text }
+
+// Paused reports whether r stands for a paused target rather than a poll.
+func (r Result) Paused() bool { return r.text == statusPaused }
+
+// statusResult makes a Result of a status string as Poll has always
+// returned them and snapshots keep them: an HTTP status such as "200 OK", a
+// check's statusOK or statusFail line, or an error.
+func statusResult(status string) Result {
+	res := Result{Up: statusUp(status), text: status}
+	if code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0]); err == nil && code >= 100 && code < 600 {
+		res.Code = code
+	}
+	switch {
+	case res.Up, status == "", status == statusPaused:
+	case strings.HasPrefix(status, statusFail+" "):
+		res.Err = errors.New(strings.TrimPrefix(status, statusFail+" "))
+		res.Class = classifyText(res.Err.Error(), classAssertion)
+	case res.Code != 0:
+		res.Err, res.Class = errors.New(status), classHTTP
+	default:
+		res.Err = errors.New(status)
+		res.Class = classifyText(status, classOther)
+	}
+	return res
+}
+
+// failedResult is the Result of a poll that got no answer.
+func failedResult(err error) Result {
+	return Result{Err: err, Class: classify(err), text: err.Error()}
+}
+
+// httpResult is the Result of a poll answered with resp, its status followed
+// by what else Poll found, if anything.
+func httpResult(resp *http.Response, extra string) Result {
+	res := Result{Up: resp.StatusCode < 400, Code: resp.StatusCode, text: resp.Status + extra}
+	if !res.Up {
+		res.Err, res.Class = errors.New(resp.Status), classHTTP
+	}
+	return res
+}
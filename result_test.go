@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestStatusResult(t *testing.T) {
+	for _, tc := range []struct {
+		status string
+		up     bool
+		code   int
+		class  errorClass
+	}{
+		{"200 OK", true, 200, ""},
+		{"301 Moved Permanently", true, 301, ""},
+		{"404 Not Found", false, 404, classHTTP},
+		{"503 Service Unavailable", false, 503, classHTTP},
+		{"OK", true, 0, ""},
+		{"OK 3 items", true, 0, ""},
+		{"FAIL body has no \"ready\"", false, 0, classAssertion},
+		{"FAIL dial tcp 10.0.0.1:5432: connect: connection refused", false, 0, classConnect},
+		{"Head \"http://x/\": dial tcp: lookup x: no such host", false, 0, classDNS},
+		{"Head \"http://x/\": context deadline exceeded", false, 0, classTimeout},
+		{"something odd", false, 0, classOther},
+		{"999 Nonsense", false, 0, classOther},
+		{statusPaused, false, 0, ""},
+		{"", false, 0, ""},
+	} {
+		res := statusResult(tc.status)
+		if res.Up != tc.up || res.Code != tc.code || res.Class != tc.class {
+			t.Errorf("statusResult(%q) = up %v, code %d, class %q; want %v, %d, %q", tc.status, res.Up, res.Code, res.Class, tc.up, tc.code, tc.class)
+		}
+		if (res.Err == nil) != (tc.class == "") {
+			t.Errorf("statusResult(%q).Err = %v", tc.status, res.Err)
+		}
+		if res.String() != tc.status {
+			t.Errorf("statusResult(%q).String() = %q", tc.status, res.String())
+		}
+		if res.Paused() != (tc.status == statusPaused) {
+			t.Errorf("statusResult(%q).Paused() = %v", tc.status, res.Paused())
+		}
+	}
+}
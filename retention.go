@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Raw segments past their retention are compacted into one rollup segment per
+// hour, holding one historyRollup per URL.
+const (
+	rollupSegmentPrefix = "hourly-"
+	compactInterval     = time.Hour
+)
+
+func rollupSegment(hour time.Time) string {
+	return rollupSegmentPrefix + hour.UTC().Format(rawSegmentLayout) + ".jsonl"
+}
+
+// historyRollup summarises one URL's polls during one hour.
+type historyRollup struct {
+	Hour         time.Time `json:"hour"`
+	URL          string    `json:"url"`
+	Polls        int       `json:"polls"`
+	Up           int       `json:"up"`
+	LatencyMinMS float64   `json:"latency_min_ms"`
+	LatencyMaxMS float64   `json:"latency_max_ms"`
+	LatencyAvgMS float64   `json:"latency_avg_ms"`
+}
+
+/*
+CompactHistory starts the background job that keeps the history store bounded.
+Every hour it turns raw segments older than rawRetention into hourly rollups and deletes rollups
+older than rollupRetention.
+Compaction only touches segments the HistoryStore has long stopped writing, so it runs on its
+own goroutine without coordinating with it. Each rollup is written to a temporary file and
+renamed into place before its raw segment is removed, so a crash at any point leaves either the
+raw data or its rollup, and the next run simply redoes the work.
+*/
+func CompactHistory(h *History, rawRetention, rollupRetention time.Duration) {
+	go func() {
+		for {
+			h.compact(time.Now(), rawRetention, rollupRetention)
+			time.Sleep(compactInterval)
+		}
+	}()
+}
+
+func (h *History) compact(now time.Time, rawRetention, rollupRetention time.Duration) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		logger.Println("Error", "history compaction", err)
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasPrefix(name, rawSegmentPrefix):
+			hour, ok := segmentHour(name, rawSegmentPrefix)
+			if !ok || now.Sub(hour.Add(time.Hour)) < rawRetention {
+				continue
+			}
+			if err := h.rollup(hour); err != nil {
+				logger.Println("Error", "history compaction", name, err)
+				continue
+			}
+			os.Remove(filepath.Join(h.dir, name))
+		case strings.HasPrefix(name, rollupSegmentPrefix):
+			hour, ok := segmentHour(name, rollupSegmentPrefix)
+			if ok && now.Sub(hour.Add(time.Hour)) >= rollupRetention {
+				os.Remove(filepath.Join(h.dir, name))
+			}
+		}
+	}
+}
+
+// segmentHour parses the hour out of a segment file name.
+func segmentHour(name, prefix string) (time.Time, bool) {
+	s := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".jsonl")
+	t, err := time.Parse(rawSegmentLayout, s)
+	return t, err == nil
+}
+
+// rollup writes the rollup segment for the raw segment of hour. Paused
+// records are left out, as the reports leave them out of raw data: a paused
+// target was not polled, so it was neither up nor down.
+func (h *History) rollup(hour time.Time) error {
+	byURL := make(map[string]*historyRollup)
+	err := readRecords(filepath.Join(h.dir, rawSegment(hour)), func(r historyRecord) bool {
+		if r.Status == statusPaused {
+			return true
+		}
+		ru, ok := byURL[r.URL]
+		if !ok {
+			ru = &historyRollup{Hour: hour, URL: r.URL, LatencyMinMS: r.LatencyMS}
+			byURL[r.URL] = ru
+		}
+		ru.Polls++
+		if r.Up {
+			ru.Up++
+		}
+		if r.LatencyMS < ru.LatencyMinMS {
+			ru.LatencyMinMS = r.LatencyMS
+		}
+		if r.LatencyMS > ru.LatencyMaxMS {
+			ru.LatencyMaxMS = r.LatencyMS
+		}
+		ru.LatencyAvgMS += r.LatencyMS // summed here, divided below
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	urls := make([]string, 0, len(byURL))
+	for u := range byURL {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	final := filepath.Join(h.dir, rollupSegment(hour))
+	f, err := os.Create(final + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, u := range urls {
+		ru := byURL[u]
+		ru.LatencyAvgMS /= float64(ru.Polls)
+		enc.Encode(ru)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(final+".tmp", final)
+}
+
+// Rollups returns the hourly rollups for url (every URL when empty) whose hour
+// starts within [from, to].
+func (h *History) Rollups(url string, from, to time.Time) ([]historyRollup, error) {
+	var out []historyRollup
+	for hour := from.UTC().Truncate(time.Hour); !hour.After(to); hour = hour.Add(time.Hour) {
+		f, err := os.Open(filepath.Join(h.dir, rollupSegment(hour)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(f)
+		for {
+			var ru historyRollup
+			if dec.Decode(&ru) != nil {
+				break
+			}
+			if (url == "" || ru.URL == url) && !ru.Hour.Before(from.Truncate(time.Hour)) && !ru.Hour.After(to) {
+				out = append(out, ru)
+			}
+		}
+		f.Close()
+	}
+	return out, nil
+}
+
+// rollupsHandler answers GET /history/rollups?target=&from=&to=, with the same
+// defaults as /history.
+func (h *History) rollupsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query()
+		to := time.Now().UTC()
+		var err error
+		if s := v.Get("to"); s != "" {
+			if to, err = parseTime(s); err != nil {
+				http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		from := to.Add(-historyDefaultRange)
+		if s := v.Get("from"); s != "" {
+			if from, err = parseTime(s); err != nil {
+				http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		rollups, err := h.Rollups(v.Get("target"), from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rollups == nil {
+			rollups = []historyRollup{}
+		}
+		for i := range rollups {
+			rollups[i].Hour = displayed(rollups[i].Hour)
+		}
+		writeJSON(w, map[string]interface{}{"rollups": rollups})
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// labelRevocation is the target label asking for the certificate of an https
+// target to be checked for revocation: ocsp, crl, or both as ocsp,crl.
+const labelRevocation = "revocation"
+
+// maxCRLBytes bounds the size of a CRL that will be downloaded.
+const maxCRLBytes = 20 << 20
+
+// oidTLSFeature is the X.509 extension listing the TLS features a
+// certificate requires; status_request (5) in it means OCSP must-staple.
+var oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// revocationClient fetches OCSP responses and CRLs, which may be slow but
+// should not take longer than a poll.
+var revocationClient = &http.Client{Timeout: errTimeout}
+
+/*
+checkRevocation checks whether the leaf certificate of a TLS connection has been revoked, as
+modes, the value of the revocation label, asks: with ocsp, by the OCSP response the server stapled
+or else by asking the certificate's responder; with crl, by the certificate's CRL. A certificate
+that requires a stapled response (must-staple) and came without one fails either way.
+It returns an error saying what is wrong with the certificate. A responder or CRL that cannot be
+reached is logged rather than failing the target, as browsers soft-fail too.
+*/
+func checkRevocation(state *tls.ConnectionState, modes string) error {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) < 2 {
+		return nil // nothing to check against without an issuer
+	}
+	leaf, issuer := state.VerifiedChains[0][0], state.VerifiedChains[0][1]
+	var useOCSP, useCRL bool
+	for _, m := range strings.Split(modes, ",") {
+		switch strings.TrimSpace(m) {
+		case "ocsp":
+			useOCSP = true
+		case "crl":
+			useCRL = true
+		default:
+			return fmt.Errorf("%s: unknown mode %q", labelRevocation, m)
+		}
+	}
+	if mustStaple(leaf) && len(state.OCSPResponse) == 0 {
+		return errors.New("certificate requires OCSP stapling but none was stapled")
+	}
+	if useOCSP {
+		raw, source := state.OCSPResponse, "stapled OCSP"
+		if len(raw) == 0 {
+			var err error
+			if raw, err = fetchOCSP(leaf, issuer); err != nil {
+				logger.Printf("OCSP for %s: %v", leaf.Subject, err)
+			}
+			source = "OCSP responder"
+		}
+		if len(raw) > 0 {
+			resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+			switch {
+			case err != nil:
+				return fmt.Errorf("%s: %v", source, err)
+			case resp.Status == ocsp.Revoked:
+				return fmt.Errorf("certificate %s revoked on %s (%s)", leaf.SerialNumber,
+					resp.RevokedAt.Format(time.DateOnly), source)
+			}
+		}
+	}
+	if useCRL {
+		revoked, err := crlRevoked(leaf, issuer)
+		if err != nil {
+			logger.Printf("CRL for %s: %v", leaf.Subject, err)
+		} else if revoked != nil {
+			return fmt.Errorf("certificate %s revoked on %s (CRL)", leaf.SerialNumber,
+				revoked.RevocationTime.Format(time.DateOnly))
+		}
+	}
+	return nil
+}
+
+// mustStaple reports whether c carries the TLS feature status_request.
+func mustStaple(c *x509.Certificate) bool {
+	for _, ext := range c.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, f := range features {
+			if f == 5 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchOCSP asks the first OCSP responder of leaf for its status.
+func fetchOCSP(leaf, issuer *x509.Certificate) ([]byte, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := revocationClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", leaf.OCSPServer[0], resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// crlRevoked looks leaf up in its first http CRL and returns the entry
+// revoking it, or nil if there is none.
+func crlRevoked(leaf, issuer *x509.Certificate) (*x509.RevocationListEntry, error) {
+	var point string
+	for _, p := range leaf.CRLDistributionPoints {
+		if strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") {
+			point = p
+			break
+		}
+	}
+	if point == "" {
+		return nil, nil
+	}
+	crl, err := crls.fetch(point, func() (*x509.RevocationList, time.Time, error) {
+		crl, err := downloadCRL(point, issuer)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if crl.NextUpdate.IsZero() {
+			return crl, time.Now().Add(time.Hour), nil
+		}
+		return crl, crl.NextUpdate, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range crl.RevokedCertificateEntries {
+		if e.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+			return &crl.RevokedCertificateEntries[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// crls keeps the CRLs downloaded, which can run to megabytes, until their
+// next update is due, rather than downloading them again on every poll.
+var crls = newExpiringCache[*x509.RevocationList]()
+
+// downloadCRL downloads the CRL at point and checks it was signed by issuer.
+func downloadCRL(point string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	resp, err := revocationClient.Get(point)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", point, resp.Status)
+	}
+	der, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLBytes))
+	if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", point, err)
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("%s: %v", point, err)
+	}
+	return crl, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// crawlerAgent is the User-Agent the crawler sends, and the robots.txt group
+// it obeys before the * one.
+const crawlerAgent = "sharemem"
+
+// robotsRules are the rules of a robots.txt that apply to crawlerAgent.
+type robotsRules struct {
+	rules []robotsRule
+	delay time.Duration // Crawl-delay, if any
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int            // of the path as written, for precedence
+	pattern *regexp.Regexp // the path, with its * and $ wildcards
+}
+
+// allowAll and disallowAll stand for a site without a robots.txt and for one
+// whose robots.txt failed with a server error, which RFC 9309 says to treat
+// as disallowing everything.
+var (
+	allowAll    = &robotsRules{}
+	disallowAll = &robotsRules{rules: []robotsRule{robotsPath(false, "/")}}
+)
+
+// fetchRobots fetches and parses the robots.txt of the host of u. A host that
+// does not answer at all is taken to allow everything, so that links to it
+// are still checked and reported broken.
+func fetchRobots(client *http.Client, u *url.URL) *robotsRules {
+	robots := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	req, err := http.NewRequest(http.MethodGet, robots.String(), nil)
+	if err != nil {
+		return disallowAll
+	}
+	req.Header.Set("User-Agent", crawlerAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return allowAll
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return disallowAll
+	case resp.StatusCode >= 400:
+		return allowAll
+	}
+	return parseRobots(io.LimitReader(resp.Body, 500<<10))
+}
+
+/*
+parseRobots parses a robots.txt, keeping the rules of the groups for crawlerAgent or, if there are
+none, those for *. Groups start with one or more User-agent lines; Allow and Disallow rules take
+paths with * and $ wildcards, and Crawl-delay a number of seconds.
+*/
+func parseRobots(r io.Reader) *robotsRules {
+	var mine, others robotsRules
+	var haveMine bool
+	var agents []string
+	inRules := false
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		if k == "user-agent" {
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(v))
+			continue
+		}
+		inRules = true
+		for _, agent := range agents {
+			var group *robotsRules
+			switch {
+			case agent == "*":
+				group = &others
+			case agent == crawlerAgent:
+				group, haveMine = &mine, true
+			default:
+				continue
+			}
+			switch k {
+			case "allow", "disallow":
+				if v != "" {
+					group.rules = append(group.rules, robotsPath(k == "allow", v))
+				}
+			case "crawl-delay":
+				if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+					group.delay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+	if haveMine {
+		return &mine
+	}
+	return &others
+}
+
+// allowed reports whether the rules let the crawler fetch u: the longest
+// matching rule decides, an Allow winning a tie, and with none it may.
+func (r *robotsRules) allowed(u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	allow, best := true, -1
+	for _, rule := range r.rules {
+		if rule.length >= best && rule.pattern.MatchString(path) {
+			if rule.length > best || rule.allow {
+				allow = rule.allow
+			}
+			best = rule.length
+		}
+	}
+	return allow
+}
+
+// robotsPath returns the rule for path, a prefix in which * stands for any
+// characters and a final $ anchors the end.
+func robotsPath(allow bool, path string) robotsRule {
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSuffix(path, "$")), `\*`, ".*")
+	if strings.HasSuffix(path, "$") {
+		expr += "$"
+	}
+	return robotsRule{allow: allow, length: len(path), pattern: regexp.MustCompile(expr)}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsAllowed(t *testing.T) {
+	const txt = `# comments are ignored
+User-agent: *
+Disallow: /
+
+User-agent: other
+User-agent: SHAREMEM
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /tmp
+Allow: /tmp
+Crawl-delay: 2.5
+`
+	rules := parseRobots(strings.NewReader(txt))
+	if rules.delay != 2500*time.Millisecond {
+		t.Errorf("delay = %v, want 2.5s", rules.delay)
+	}
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/about", true},
+		{"/private/", false},
+		{"/private/keys", false},
+		{"/private/public", true}, // the longer rule wins
+		{"/private/publication", true},
+		{"/docs/manual.pdf", false},
+		{"/docs/manual.pdf?page=2", true}, // $ anchors the end
+		{"/tmp/x", true},                  // an Allow wins a tie
+	} {
+		u, _ := url.Parse("https://example.com" + tc.path)
+		if got := rules.allowed(u); got != tc.want {
+			t.Errorf("allowed(%s) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRobotsFallsBackToStar(t *testing.T) {
+	for _, tc := range []struct {
+		txt  string
+		path string
+		want bool
+	}{
+		{"User-agent: *\nDisallow: /admin\n", "/admin/users", false},
+		{"User-agent: *\nDisallow: /admin\n", "/shop", true},
+		{"User-agent: googlebot\nDisallow: /\n", "/shop", true},
+		{"User-agent: *\nDisallow:\n", "/anything", true},
+		{"", "/", true},
+	} {
+		u, _ := url.Parse("https://example.com" + tc.path)
+		if got := parseRobots(strings.NewReader(tc.txt)).allowed(u); got != tc.want {
+			t.Errorf("%q: allowed(%s) = %v, want %v", tc.txt, tc.path, got, tc.want)
+		}
+	}
+	if u, _ := url.Parse("https://example.com/"); disallowAll.allowed(u) || !allowAll.allowed(u) {
+		t.Error("disallowAll or allowAll is wrong about /")
+	}
+}
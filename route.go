@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// labelSource sends the probes of an HTTP target from a local address or
+// interface, as in source=10.0.2.15 or source=eth1, and labelProxy through a
+// SOCKS5 proxy, as in proxy=socks5://127.0.0.1:1080; checks take them as
+// parameters, ports://db.internal:5432,6432?source=eth1.
+const (
+	labelSource = "source"
+	labelProxy  = "proxy"
+)
+
+// routeTransports keeps an HTTP transport for each route, so that probes
+// taking it reuse their connections, for as long as an interface is taken to
+// keep its address.
+var routeTransports = newExpiringCache[*http.Transport]()
+
+const routeRefresh = 5 * time.Minute
+
+// probeRoute is the way the probes of a target take: from which local address
+// or interface, and through which SOCKS5 proxy. The zero probeRoute is the
+// host's default.
+type probeRoute struct {
+	source, proxy string
+}
+
+// dialer is what probes connect with: a net.Dialer, or a SOCKS5 client.
+type dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// targetRoute returns the route of the HTTP target with labels.
+func targetRoute(labels map[string]string) probeRoute {
+	return newRoute(labels[labelSource], labels[labelProxy])
+}
+
+// checkRoute returns the route of a check of u, from its parameters.
+func checkRoute(u *url.URL) probeRoute {
+	q := u.Query()
+	return newRoute(q.Get(labelSource), q.Get(labelProxy))
+}
+
+func newRoute(source, proxy string) probeRoute {
+	if source == "" {
+		source = *sourceFlag
+	}
+	return probeRoute{source, proxy}
+}
+
+func (r probeRoute) String() string {
+	return r.source + " " + r.proxy
+}
+
+// sourceAddr resolves spec, a local IP address or the name of an interface,
+// to the address to send from: for an interface, its first IPv4 address, or
+// else its first address of any kind.
+func sourceAddr(spec string) (net.IP, error) {
+	if ip := net.ParseIP(spec); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(spec)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: not an IP address or interface", spec)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %v", spec, err)
+	}
+	var first net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if first == nil {
+			first = ipnet.IP
+		}
+	}
+	if first == nil {
+		return nil, fmt.Errorf("source %s: interface has no address", spec)
+	}
+	return first, nil
+}
+
+// dialer returns a dialer for network, tcp or udp, taking route r. A proxy
+// only carries tcp.
+func (r probeRoute) dialer(network string) (dialer, error) {
+	d := &net.Dialer{}
+	if r.source != "" {
+		ip, err := sourceAddr(r.source)
+		if err != nil {
+			return nil, err
+		}
+		if network == "udp" {
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	if r.proxy == "" {
+		return d, nil
+	}
+	u, err := url.Parse(r.proxy)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %v", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("proxy %s: not a socks5:// proxy", u.Redacted())
+	}
+	if network != "tcp" {
+		return nil, fmt.Errorf("proxy %s: SOCKS5 proxies carry only TCP", u.Redacted())
+	}
+	p, err := proxy.FromURL(u, d)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %v", u.Redacted(), err)
+	}
+	cd, ok := p.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("proxy: dialer does not take a context")
+	}
+	return cd, nil
+}
+
+// transport returns the transport of HTTP probes taking route r; for the
+// default route, http.DefaultTransport.
+func (r probeRoute) transport() (http.RoundTripper, error) {
+	if r == (probeRoute{}) {
+		return http.DefaultTransport, nil
+	}
+	t, err := routeTransports.fetch(r.String(), func() (*http.Transport, time.Time, error) {
+		d, err := r.dialer("tcp")
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = d.DialContext
+		if r.proxy != "" {
+			t.Proxy = nil
+		}
+		return t, time.Now().Add(routeRefresh), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// checkDialer returns the dialer of a check of u, taking its route.
+func checkDialer(network string, u *url.URL) (dialer, error) {
+	return checkRoute(u).dialer(network)
+}
+
+// probeClient returns the client the HTTP checks of a target with labels
+// make their requests with, timing out after checkTimeout.
+func probeClient(labels map[string]string) (*http.Client, error) {
+	t, err := targetRoute(labels).transport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t, Timeout: checkTimeout}, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"time"
+)
+
+// route53Metric is the CloudWatch metric Route53Sync publishes: 1 while a
+// target is up and 0 while it is down. A CloudWatch alarm on it, in turn
+// watched by a Route53 health check of type CLOUDWATCH_METRIC, lets DNS
+// failover follow this poller's verdict.
+const route53Metric = "TargetHealthy"
+
+/*
+Route53Sync publishes every target's up/down status to CloudWatch under namespace, once per
+interval, with the target URL as the only dimension.
+It returns the channel on which it wants to hear about state changes; pass it to StateMonitor
+as a listener.
+Only the latest status per URL is kept between flushes, and each flush is made on its own
+goroutine, so neither the StateMonitor nor the Pollers ever wait on AWS. A URL the Scheduler
+has retired, as live from WatchTargets says, is forgotten and no longer published.
+Status is re-published every interval even when nothing changed: an alarm without data points
+would otherwise turn INSUFFICIENT_DATA and the health check would follow its missing-data rule.
+*/
+func Route53Sync(aws *awsClient, namespace string, interval time.Duration, live <-chan map[string]bool) chan<- State {
+	states := make(chan State)
+	latest := make(map[string]bool)
+	ticker := time.NewTicker(interval)
+	go func() {
+		for {
+			select {
+			case s := <-states:
+				// A paused target keeps its last verdict rather than
+				// failing DNS over.
+				if !s.result.Paused() {
+					latest[s.url] = s.result.Up
+				}
+			case targets := <-live:
+				for u := range latest {
+					if _, ok := targets[u]; !ok {
+						delete(latest, u)
+					}
+				}
+			case now := <-ticker.C:
+				data := make([]cloudWatchDatum, 0, len(latest))
+				for u, up := range latest {
+					v := 0.0
+					if up {
+						v = 1
+					}
+					data = append(data, cloudWatchDatum{
+						name:       route53Metric,
+						dimensions: map[string]string{"URL": u},
+						value:      v,
+						unit:       "None",
+						timestamp:  now,
+					})
+				}
+				go func() {
+					if err := aws.putMetricData(namespace, data); err != nil {
+						logger.Println("Error", "route53 sync", err)
+					}
+				}()
+			}
+		}
+	}()
+	return states
+}
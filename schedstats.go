@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// schedStats counts what the Scheduler and Pollers are doing, for /metrics;
+// set by main with -listen, nil otherwise.
+var schedStats *SchedulerStats
+
+// delayBuckets are the upper bounds, in seconds, of the histogram of how late
+// polls start.
+var delayBuckets = []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+/*
+SchedulerStats keeps the numbers that show whether the monitor itself is keeping up: how many
+due Resources are waiting to be handed to the Pollers, how many polls are in flight, and how late
+polls start compared with when they were due. A poll is due when its Resource wakes from its
+sleep, or when it is first sent out; a poll that starts late means the Pollers, or the queue in
+front of them, are the bottleneck, which no target's latency would show.
+One goroutine owns the counts; the methods only send to it.
+*/
+type SchedulerStats struct {
+	events chan schedEvent
+	reads  chan chan schedulerSnapshot
+}
+
+type schedEvent struct {
+	waiting, inFlight int
+	started           bool
+	delay             time.Duration
+}
+
+type schedulerSnapshot struct {
+	waiting, inFlight int
+	started           uint64
+	buckets           []uint64 // cumulative, by delayBuckets
+	delaySum          time.Duration
+}
+
+// NewSchedulerStats starts a SchedulerStats.
+func NewSchedulerStats() *SchedulerStats {
+	s := &SchedulerStats{events: make(chan schedEvent), reads: make(chan chan schedulerSnapshot)}
+	go func() {
+		snap := schedulerSnapshot{buckets: make([]uint64, len(delayBuckets))}
+		for {
+			select {
+			case e := <-s.events:
+				snap.waiting += e.waiting
+				snap.inFlight += e.inFlight
+				if e.started {
+					snap.started++
+					snap.delaySum += e.delay
+					for i, b := range delayBuckets {
+						if e.delay.Seconds() <= b {
+							snap.buckets[i]++
+						}
+					}
+				}
+			case reply := <-s.reads:
+				c := snap
+				c.buckets = append([]uint64(nil), snap.buckets...)
+				reply <- c
+			}
+		}
+	}()
+	return s
+}
+
+// Waiting records that n more due Resources, or -n fewer, wait to be sent on
+// pending. Like the other methods, it does nothing on a nil SchedulerStats.
+func (s *SchedulerStats) Waiting(n int) {
+	if s != nil {
+		s.events <- schedEvent{waiting: n}
+	}
+}
+
+// Started records that a Poller has started polling r, and how long after it
+// was due.
+func (s *SchedulerStats) Started(r *Resource) {
+	if s == nil {
+		return
+	}
+	s.events <- schedEvent{inFlight: 1, started: !r.due.IsZero(), delay: max(time.Since(r.due), 0)}
+}
+
+// Finished records that a Poller is done with its Resource.
+func (s *SchedulerStats) Finished() {
+	if s != nil {
+		s.events <- schedEvent{inFlight: -1}
+	}
+}
+
+func (s *SchedulerStats) snapshot() schedulerSnapshot {
+	reply := make(chan schedulerSnapshot)
+	s.reads <- reply
+	return <-reply
+}
+
+/*
+withSchedulerMetrics follows the page h serves with the Scheduler's numbers from s, as
+sharemem_pending_waiting, sharemem_polls_in_flight and the histogram
+sharemem_poll_schedule_delay_seconds, and with those of the Go runtime under the names the
+Prometheus Go client gives them, so that dashboards made for other Go services work unchanged.
+*/
+func withSchedulerMetrics(h http.Handler, s *SchedulerStats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		snap := s.snapshot()
+		fmt.Fprintf(w, "# HELP sharemem_pending_waiting Due Resources waiting to be handed to a Poller or the queue.\n# TYPE sharemem_pending_waiting gauge\nsharemem_pending_waiting %d\n", snap.waiting)
+		fmt.Fprintf(w, "# HELP sharemem_polls_in_flight Polls under way.\n# TYPE sharemem_polls_in_flight gauge\nsharemem_polls_in_flight %d\n", snap.inFlight)
+		fmt.Fprint(w, "# HELP sharemem_poll_schedule_delay_seconds How long after it was due a poll started.\n# TYPE sharemem_poll_schedule_delay_seconds histogram\n")
+		for i, b := range delayBuckets {
+			fmt.Fprintf(w, "sharemem_poll_schedule_delay_seconds_bucket{le=\"%g\"} %d\n", b, snap.buckets[i])
+		}
+		fmt.Fprintf(w, "sharemem_poll_schedule_delay_seconds_bucket{le=\"+Inf\"} %d\n", snap.started)
+		fmt.Fprintf(w, "sharemem_poll_schedule_delay_seconds_sum %g\nsharemem_poll_schedule_delay_seconds_count %d\n", snap.delaySum.Seconds(), snap.started)
+
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		fmt.Fprintf(w, "# HELP go_goroutines Number of goroutines that currently exist.\n# TYPE go_goroutines gauge\ngo_goroutines %d\n", runtime.NumGoroutine())
+		fmt.Fprintf(w, "# HELP go_memstats_heap_alloc_bytes Bytes of allocated heap objects.\n# TYPE go_memstats_heap_alloc_bytes gauge\ngo_memstats_heap_alloc_bytes %d\n", m.HeapAlloc)
+		fmt.Fprintf(w, "# HELP go_memstats_sys_bytes Bytes obtained from the system.\n# TYPE go_memstats_sys_bytes gauge\ngo_memstats_sys_bytes %d\n", m.Sys)
+		fmt.Fprintf(w, "# HELP go_gc_duration_seconds Pauses for GC, one per completed cycle.\n# TYPE go_gc_duration_seconds summary\ngo_gc_duration_seconds_sum %g\ngo_gc_duration_seconds_count %d\n", float64(m.PauseTotalNs)/1e9, m.NumGC)
+		fmt.Fprintf(w, "# HELP go_memstats_gc_cpu_fraction Fraction of CPU time used by the GC since the program started.\n# TYPE go_memstats_gc_cpu_fraction gauge\ngo_memstats_gc_cpu_fraction %g\n", m.GCCPUFraction)
+		if m.NumGC > 0 {
+			fmt.Fprintf(w, "# HELP go_memstats_last_gc_time_seconds When the last GC finished, in seconds since the epoch.\n# TYPE go_memstats_last_gc_time_seconds gauge\ngo_memstats_last_gc_time_seconds %g\n", float64(m.LastGC)/1e9)
+		}
+	})
+}
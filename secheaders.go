@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// labelSecurityHeaders is the target label, security-headers=true, asking for
+// the security headers of an HTTP target's responses to be graded.
+const labelSecurityHeaders = "security-headers"
+
+// hstsMinAge is the shortest Strict-Transport-Security max-age, 180 days,
+// that is not reported as too short.
+const hstsMinAge = 180 * 24 * 60 * 60
+
+/*
+securityHeaders grades the security headers of resp: Strict-Transport-Security on https, of at
+least 180 days; a Content-Security-Policy; X-Content-Type-Options: nosniff; and every cookie set
+being HttpOnly, SameSite and, on https, Secure. It returns a grade from A, nothing missing, down
+to F, with what is missing. Findings are warnings only: they are added to the status of a target
+that is up, and do not take it down.
+*/
+func securityHeaders(resp *http.Response) (grade string, missing []string) {
+	h := resp.Header
+	https := resp.Request != nil && resp.Request.URL.Scheme == "https"
+	if https {
+		hsts := h.Get("Strict-Transport-Security")
+		if hsts == "" {
+			missing = append(missing, "no Strict-Transport-Security")
+		} else if age := hstsMaxAge(hsts); age < hstsMinAge {
+			missing = append(missing, fmt.Sprintf("Strict-Transport-Security max-age %d under 180 days", age))
+		}
+	}
+	if h.Get("Content-Security-Policy") == "" {
+		missing = append(missing, "no Content-Security-Policy")
+	}
+	if !strings.EqualFold(strings.TrimSpace(h.Get("X-Content-Type-Options")), "nosniff") {
+		missing = append(missing, "no X-Content-Type-Options: nosniff")
+	}
+	for _, c := range resp.Cookies() {
+		var flags []string
+		if https && !c.Secure {
+			flags = append(flags, "Secure")
+		}
+		if !c.HttpOnly {
+			flags = append(flags, "HttpOnly")
+		}
+		if c.SameSite == 0 || c.SameSite == http.SameSiteDefaultMode {
+			flags = append(flags, "SameSite")
+		}
+		if len(flags) > 0 {
+			missing = append(missing, "cookie "+c.Name+" without "+strings.Join(flags, ", "))
+		}
+	}
+	grades := "ABCDF"
+	return string(grades[min(len(missing), len(grades)-1)]), missing
+}
+
+// hstsMaxAge returns the max-age directive of a Strict-Transport-Security
+// header, 0 if it has none.
+func hstsMaxAge(hsts string) int {
+	for _, d := range strings.Split(hsts, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if strings.EqualFold(k, "max-age") {
+			n, _ := strconv.Atoi(strings.Trim(v, `"`))
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// silenceRetention is how long expired silences are still listed.
+const silenceRetention = 5 * 24 * time.Hour
+
+// matcher is one condition of a silence on a target label; the pseudo-label
+// "url" is the target's URL. Its syntax is that of amtool: name=value,
+// name!=value, name=~regexp or name!~regexp, the regexp anchored at both ends.
+type matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+	re      *regexp.Regexp
+}
+
+func parseMatcher(s string) (matcher, error) {
+	bad := fmt.Errorf("bad matcher %q: want name=value, name!=value, name=~re or name!~re", s)
+	// The operator starts at the first = or !, which no label name holds;
+	// the value, a URL say, may hold any of them.
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return matcher{}, bad
+	}
+	op := s[i : i+1]
+	if rest := s[i+1:]; strings.HasPrefix(rest, "~") || op == "!" && strings.HasPrefix(rest, "=") {
+		op += rest[:1]
+	}
+	if op == "!" {
+		return matcher{}, bad
+	}
+	m := matcher{Name: strings.TrimSpace(s[:i]), Value: strings.Trim(strings.TrimSpace(s[i+len(op):]), `"`),
+		IsRegex: strings.HasSuffix(op, "~"), IsEqual: op[0] != '!'}
+	return m, m.compile()
+}
+
+func (m *matcher) compile() error {
+	if !m.IsRegex {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		return fmt.Errorf("matcher %s: %v", m.Name, err)
+	}
+	m.re = re
+	return nil
+}
+
+func (m matcher) matches(t Target) bool {
+	v := t.labels[m.Name]
+	if m.Name == "url" {
+		v = t.url
+	}
+	ok := v == m.Value
+	if m.IsRegex {
+		ok = m.re.MatchString(v)
+	}
+	return ok == m.IsEqual
+}
+
+func (m matcher) String() string {
+	var op string
+	switch {
+	case m.IsRegex && m.IsEqual:
+		op = "=~"
+	case m.IsRegex:
+		op = "!~"
+	case m.IsEqual:
+		op = "="
+	default:
+		op = "!="
+	}
+	return m.Name + op + m.Value
+}
+
+// Silence mutes the notifications about the targets matching all its matchers
+// between StartsAt and EndsAt.
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  []matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+func (s Silence) activeAt(t time.Time) bool { return !t.Before(s.StartsAt) && t.Before(s.EndsAt) }
+
+func (s Silence) mutes(t Target) bool {
+	for _, m := range s.Matchers {
+		if !m.matches(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// status is "pending", "active" or "expired", as in Alertmanager.
+func (s Silence) status(now time.Time) string {
+	switch {
+	case now.Before(s.StartsAt):
+		return "pending"
+	case now.Before(s.EndsAt):
+		return "active"
+	}
+	return "expired"
+}
+
+/*
+Silencer sits in front of the notifying sinks and drops the events whose targets are silenced.
+Silenced targets are still polled, recorded and tracked in incidents; only notifications are
+muted. A correlated event is dropped when every one of its targets is silenced.
+One goroutine owns the silences; with a file, it saves them there after every change and they are
+restored on startup.
+It returns the channel on which it wants to hear about events, to be passed on to the Alerter,
+and the silences to hand to silencesAPI.
+*/
+func Silencer(path string, listeners ...chan<- Event) (chan<- Event, *Silences, error) {
+	var silences []Silence
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &silences); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		for i := range silences {
+			for j := range silences[i].Matchers {
+				if err := silences[i].Matchers[j].compile(); err != nil {
+					return nil, nil, fmt.Errorf("%s: %v", path, err)
+				}
+			}
+		}
+	}
+	events := make(chan Event)
+	sl := &Silences{controls: make(chan silenceControl)}
+	save := func() {
+		if path == "" {
+			return
+		}
+		if err := writeFileAtomic(path, silences); err != nil {
+			logger.Println("Error", "silences", err)
+		}
+	}
+	muted := func(t Target, now time.Time) *Silence {
+		for i := range silences {
+			if silences[i].activeAt(now) && silences[i].mutes(t) {
+				return &silences[i]
+			}
+		}
+		return nil
+	}
+	go func() {
+		for {
+			select {
+			case e := <-events:
+				now := time.Now()
+				states := e.related
+				if len(states) == 0 {
+					states = []State{e.state}
+				}
+				var by *Silence
+				for _, s := range states {
+					if by = muted(s.target, now); by == nil {
+						break
+					}
+				}
+				if by != nil {
+					logger.Printf("Silenced %s %s by %s", e.kind, e.key(), by.ID)
+					continue
+				}
+				for _, l := range listeners {
+					l <- e
+				}
+			case c := <-sl.controls:
+				now := time.Now()
+				kept := silences[:0]
+				for _, s := range silences {
+					if now.Sub(s.EndsAt) < silenceRetention {
+						kept = append(kept, s)
+					}
+				}
+				silences = kept
+				switch c.op {
+				case "add":
+					silences = append(silences, c.silence)
+					logger.Printf("Silence %s by %s until %s: %v", c.silence.ID, c.silence.CreatedBy, c.silence.EndsAt.Format(time.RFC3339), c.silence.Matchers)
+					save()
+					c.reply <- silenceReply{silence: c.silence}
+				case "expire":
+					var found *Silence
+					for i := range silences {
+						if silences[i].ID == c.id {
+							found = &silences[i]
+						}
+					}
+					switch {
+					case found == nil:
+						c.reply <- silenceReply{err: fmt.Errorf("no silence %s", c.id)}
+					case !now.Before(found.EndsAt):
+						c.reply <- silenceReply{silence: *found}
+					default:
+						found.EndsAt = now
+						if now.Before(found.StartsAt) {
+							found.StartsAt = now
+						}
+						logger.Printf("Silence %s expired", found.ID)
+						save()
+						c.reply <- silenceReply{silence: *found, changed: true}
+					}
+				case "list":
+					out := make([]Silence, len(silences))
+					copy(out, silences)
+					c.reply <- silenceReply{silences: out}
+				}
+			}
+		}
+	}()
+	return events, sl, nil
+}
+
+// Silences are the silences held by a Silencer.
+type Silences struct {
+	controls chan silenceControl
+}
+
+type silenceControl struct {
+	op      string // "add", "expire" or "list"
+	id      string
+	silence Silence
+	reply   chan silenceReply
+}
+
+type silenceReply struct {
+	silence  Silence
+	silences []Silence
+	changed  bool
+	err      error
+}
+
+// silenceJSON is a Silence as the API shows it.
+type silenceJSON struct {
+	Silence
+	Matchers []string `json:"matchers"`
+	Status   string   `json:"status"`
+}
+
+func (s Silence) json(now time.Time) silenceJSON {
+	j := silenceJSON{Silence: s, Matchers: []string{}, Status: s.status(now)}
+	j.StartsAt, j.EndsAt = displayed(s.StartsAt), displayed(s.EndsAt)
+	for _, m := range s.Matchers {
+		j.Matchers = append(j.Matchers, m.String())
+	}
+	return j
+}
+
+// silencesAPI serves the silence endpoints:
+//
+//	GET  /silences/                 every silence, with its status
+//	POST /silences/                 create one from the form: matcher (repeated), for or endsAt, startsAt, comment
+//	POST /silences/expire?id=ID     end a silence now
+//
+// Creating and expiring silences is recorded in audit.
+func silencesAPI(sl *Silences, audit *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verb := strings.TrimPrefix(r.URL.Path, "/silences/")
+		reply := make(chan silenceReply)
+		switch {
+		case verb == "" && r.Method == http.MethodGet:
+			sl.controls <- silenceControl{op: "list", reply: reply}
+			now := time.Now()
+			out := []silenceJSON{}
+			for _, s := range (<-reply).silences {
+				out = append(out, s.json(now))
+			}
+			writeJSON(w, map[string]interface{}{"silences": out})
+		case verb == "" && r.Method == http.MethodPost:
+			s, err := silenceFromForm(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sl.controls <- silenceControl{op: "add", silence: s, reply: reply}
+			rep := <-reply
+			created := rep.silence.json(time.Now())
+			audit.Record(r, "silence", rep.silence.ID, nil, created)
+			writeJSON(w, created)
+		case verb == "expire" && r.Method == http.MethodPost:
+			id := r.FormValue("id")
+			if id == "" {
+				http.Error(w, "id: missing", http.StatusBadRequest)
+				return
+			}
+			sl.controls <- silenceControl{op: "expire", id: id, reply: reply}
+			rep := <-reply
+			if rep.err != nil {
+				http.Error(w, rep.err.Error(), http.StatusNotFound)
+				return
+			}
+			if rep.changed {
+				audit.Record(r, "unsilence", id, nil, nil)
+			}
+			writeJSON(w, rep.silence.json(time.Now()))
+		case verb == "" || verb == "expire":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// silenceFromForm builds the silence described by the form of r.
+func silenceFromForm(r *http.Request) (Silence, error) {
+	if err := r.ParseForm(); err != nil {
+		return Silence{}, err
+	}
+	s := Silence{CreatedBy: principal(r), Comment: r.Form.Get("comment"), StartsAt: time.Now().UTC()}
+	for _, v := range r.Form["matcher"] {
+		m, err := parseMatcher(v)
+		if err != nil {
+			return Silence{}, err
+		}
+		s.Matchers = append(s.Matchers, m)
+	}
+	if len(s.Matchers) == 0 {
+		return Silence{}, errors.New("matcher: missing")
+	}
+	var err error
+	if v := r.Form.Get("startsAt"); v != "" {
+		if s.StartsAt, err = parseTime(v); err != nil {
+			return Silence{}, fmt.Errorf("startsAt: %v", err)
+		}
+	}
+	switch {
+	case r.Form.Get("endsAt") != "":
+		if s.EndsAt, err = parseTime(r.Form.Get("endsAt")); err != nil {
+			return Silence{}, fmt.Errorf("endsAt: %v", err)
+		}
+	case r.Form.Get("for") != "":
+		d, err := time.ParseDuration(r.Form.Get("for"))
+		if err != nil || d <= 0 {
+			return Silence{}, fmt.Errorf("for: bad duration %q", r.Form.Get("for"))
+		}
+		s.EndsAt = s.StartsAt.Add(d)
+	default:
+		return Silence{}, errors.New("for or endsAt: missing")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return Silence{}, errors.New("endsAt: must be after startsAt")
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	s.ID = hex.EncodeToString(id)
+	return s, nil
+}
+
+// silenceCommand implements "silence [-for d] [-comment c] matcher..." and
+// "unsilence <id>", which create and expire silences through the API of a
+// running poller. It returns the process exit code.
+func silenceCommand(verb string, args []string) int {
+	fs := flag.NewFlagSet(verb, flag.ContinueOnError)
+	api := fs.String("api", defaultAPI, "`URL` of the running poller's API")
+	var dur *time.Duration
+	var comment *string
+	if verb == "silence" {
+		dur = fs.Duration("for", time.Hour, "how long the silence lasts")
+		comment = fs.String("comment", "", "why the targets are silenced")
+	}
+	fs.Usage = func() {
+		if verb == "silence" {
+			fmt.Fprintf(fs.Output(), "usage: %s silence [-api URL] [-for duration] [-comment text] <matcher>...\n", os.Args[0])
+			fmt.Fprintln(fs.Output(), "matchers are label=value, label!=value, label=~regexp or label!~regexp; url is the target's URL")
+		} else {
+			fmt.Fprintf(fs.Output(), "usage: %s unsilence [-api URL] <id>\n", os.Args[0])
+		}
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() == 0 || verb == "unsilence" && fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	var res silenceJSON
+	var err error
+	if verb == "unsilence" {
+		err = apiPost(*api, "/silences/expire", url.Values{"id": {fs.Arg(0)}}, &res)
+	} else {
+		err = apiPost(*api, "/silences/", url.Values{"matcher": fs.Args(), "for": {dur.String()}, "comment": {*comment}}, &res)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s %s, ends %s: %s\n", res.ID, res.Status, res.EndsAt.Local().Format(time.RFC3339), strings.Join(res.Matchers, " "))
+	return 0
+}
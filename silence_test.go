@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestParseMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		in             string
+		name, value    string
+		regex, isEqual bool
+	}{
+		{"env=prod", "env", "prod", false, true},
+		{"env!=prod", "env", "prod", false, false},
+		{"env=~prod|staging", "env", "prod|staging", true, true},
+		{"env!~dev.*", "env", "dev.*", true, false},
+		{` env = "prod" `, "env", "prod", false, true},
+		{"url=https://h/?x!=1", "url", "https://h/?x!=1", false, true},
+		{"url=https://h/?a=~b", "url", "https://h/?a=~b", false, true},
+		{"url!=https://h/?a=b", "url", "https://h/?a=b", false, false},
+	} {
+		m, err := parseMatcher(tc.in)
+		if err != nil {
+			t.Errorf("parseMatcher(%q): %v", tc.in, err)
+			continue
+		}
+		if m.Name != tc.name || m.Value != tc.value || m.IsRegex != tc.regex || m.IsEqual != tc.isEqual {
+			t.Errorf("parseMatcher(%q) = %+v", tc.in, m)
+		}
+	}
+	for _, in := range []string{"", "env", "=prod", "env!prod", "env=~(", "!=x"} {
+		if m, err := parseMatcher(in); err == nil {
+			t.Errorf("parseMatcher(%q) = %+v, want an error", in, m)
+		}
+	}
+}
+
+func TestMatcherMatches(t *testing.T) {
+	target := Target{url: "https://shop.example.com/health", labels: map[string]string{"env": "prod", "team": "payments"}}
+	for _, tc := range []struct {
+		matcher string
+		want    bool
+	}{
+		{"env=prod", true},
+		{"env=staging", false},
+		{"env!=staging", true},
+		{"env!=prod", false},
+		{"team=~pay.*", true},
+		{"team=~pay", false}, // anchored at both ends
+		{"team!~pay.*", false},
+		{"region=", true}, // a missing label is empty
+		{"region!=", false},
+		{"url=https://shop.example.com/health", true},
+		{"url=~https://shop\\..*", true},
+		{"url!~.*example.com.*", false},
+	} {
+		m, err := parseMatcher(tc.matcher)
+		if err != nil {
+			t.Fatalf("parseMatcher(%q): %v", tc.matcher, err)
+		}
+		if got := m.matches(target); got != tc.want {
+			t.Errorf("%s matches = %v, want %v", tc.matcher, got, tc.want)
+		}
+	}
+}
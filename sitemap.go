@@ -0,0 +1,262 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sitemapPrefix marks a target that is a sitemap, whose pages are polled, as
+// in sitemap+https://www.example.com/sitemap.xml.
+const sitemapPrefix = "sitemap+"
+
+// Target labels of sitemap+ targets: which of the pages to poll, by regexps
+// over their URLs, and at most how many.
+const (
+	labelInclude = "include"
+	labelExclude = "exclude"
+	labelMaxURLs = "max-urls"
+)
+
+// sitemapMaxBytes bounds the size of a sitemap, as the protocol does
+// (50MB uncompressed), and sitemapMaxChildren how many sitemaps of a sitemap
+// index are fetched.
+const (
+	sitemapMaxBytes    = 50 << 20
+	sitemapMaxChildren = 50
+)
+
+// sitemapPages maps a sitemap's URL to the pages it lists.
+type sitemapPages map[string][]string
+
+/*
+SitemapExpander sits between the discovery sources and the SRVExpander and expands sitemap+ targets
+into one target per page the sitemap lists, so a whole site is polled without listing every page.
+The include and exclude labels of the target select pages by regexps over their URLs, and
+max-urls caps how many are polled, maxURLs unless it says otherwise; pages are taken in the order
+listed. Every interval it fetches all sitemaps again and sends the affected sources' updates on,
+so pages that are published or retired start or stop being polled.
+Pages the robots.txt of their site disallows are left out, unless ignoreRobots.
+Like the SRVExpander it keeps a sitemap's previous pages when fetching it fails, and fetches on
+their own goroutine so that a slow site never blocks the sources sending updates.
+*/
+func SitemapExpander(in <-chan TargetUpdate, interval time.Duration, maxURLs int, ignoreRobots bool) <-chan TargetUpdate {
+	next := make(chan TargetUpdate)
+	fetched := make(chan sitemapPages)
+	go func() {
+		sources := make(map[string][]Target)
+		pages := make(sitemapPages)
+		ticker := time.NewTicker(interval)
+		fetch := func(sitemaps []string) {
+			if len(sitemaps) > 0 {
+				go func() { fetched <- fetchSitemaps(sitemaps, ignoreRobots) }()
+			}
+		}
+		forward := func(source string) {
+			var ts []Target
+			for _, t := range sources[source] {
+				ts = append(ts, expandSitemap(t, pages, maxURLs)...)
+			}
+			next <- TargetUpdate{source: source, targets: ts}
+		}
+		for {
+			select {
+			case u := <-in:
+				sources[u.source] = u.targets
+				var unknown []string
+				for _, t := range u.targets {
+					if sitemap, ok := strings.CutPrefix(t.url, sitemapPrefix); ok {
+						if _, seen := pages[sitemap]; !seen {
+							unknown = append(unknown, sitemap)
+						}
+					}
+				}
+				fetch(unknown)
+				forward(u.source)
+			case <-ticker.C:
+				sitemaps := make(map[string]bool)
+				for _, ts := range sources {
+					for _, t := range ts {
+						if sitemap, ok := strings.CutPrefix(t.url, sitemapPrefix); ok {
+							sitemaps[sitemap] = true
+						}
+					}
+				}
+				var all []string
+				for sitemap := range sitemaps {
+					all = append(all, sitemap)
+				}
+				fetch(all)
+			case ps := <-fetched:
+				for sitemap, urls := range ps {
+					pages[sitemap] = urls
+				}
+				for source, ts := range sources {
+					for _, t := range ts {
+						if sitemap, ok := strings.CutPrefix(t.url, sitemapPrefix); ok && ps[sitemap] != nil {
+							forward(source)
+							break
+						}
+					}
+				}
+			}
+		}
+	}()
+	return next
+}
+
+// fetchSitemaps fetches each sitemap, leaving out the ones that fail and,
+// unless ignoreRobots, the pages robots.txt disallows.
+func fetchSitemaps(sitemaps []string, ignoreRobots bool) sitemapPages {
+	ps := make(sitemapPages)
+	client := &http.Client{Timeout: errTimeout}
+	robots := make(map[string]*robotsRules)
+	for _, sitemap := range sitemaps {
+		urls, err := fetchSitemap(sitemap)
+		if err != nil {
+			logger.Println("Error", sitemapPrefix+sitemap, err)
+			continue
+		}
+		if ignoreRobots {
+			ps[sitemap] = urls
+			continue
+		}
+		allowed := make([]string, 0, len(urls))
+		for _, page := range urls {
+			u, err := url.Parse(page)
+			if err != nil {
+				continue
+			}
+			rules, ok := robots[u.Host]
+			if !ok {
+				rules = fetchRobots(client, u)
+				robots[u.Host] = rules
+			}
+			if rules.allowed(u) {
+				allowed = append(allowed, page)
+			}
+		}
+		if n := len(urls) - len(allowed); n > 0 {
+			logger.Printf("%s%s: leaving out %d pages robots.txt disallows", sitemapPrefix, sitemap, n)
+		}
+		ps[sitemap] = allowed
+	}
+	return ps
+}
+
+// sitemapXML is a sitemap, listing pages, or a sitemap index, listing
+// sitemaps; either may be gzipped.
+type sitemapXML struct {
+	XMLName  xml.Name
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// fetchSitemap returns the pages a sitemap lists, following a sitemap index
+// to the first sitemapMaxChildren sitemaps in it.
+func fetchSitemap(sitemap string) ([]string, error) {
+	sm, err := getSitemap(sitemap)
+	if err != nil {
+		return nil, err
+	}
+	if sm.XMLName.Local != "sitemapindex" {
+		return sm.URLs, nil
+	}
+	var urls []string
+	for i, child := range sm.Sitemaps {
+		if i == sitemapMaxChildren {
+			logger.Printf("Error %s%s: only the first %d of its %d sitemaps are fetched", sitemapPrefix, sitemap, i, len(sm.Sitemaps))
+			break
+		}
+		c, err := getSitemap(child)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, c.URLs...)
+	}
+	return urls, nil
+}
+
+func getSitemap(url string) (*sitemapXML, error) {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var body io.Reader = resp.Body
+	if strings.HasSuffix(resp.Request.URL.Path, ".gz") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		body = gz
+	}
+	var sm sitemapXML
+	if err := xml.NewDecoder(io.LimitReader(body, sitemapMaxBytes)).Decode(&sm); err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	for i := range sm.URLs {
+		sm.URLs[i] = strings.TrimSpace(sm.URLs[i])
+	}
+	for i := range sm.Sitemaps {
+		sm.Sitemaps[i] = strings.TrimSpace(sm.Sitemaps[i])
+	}
+	return &sm, nil
+}
+
+// expandSitemap returns t itself when it is not a sitemap+ target and
+// otherwise one target per selected page, labelled with the sitemap.
+func expandSitemap(t Target, pages sitemapPages, maxURLs int) []Target {
+	sitemap, ok := strings.CutPrefix(t.url, sitemapPrefix)
+	if !ok {
+		return []Target{t}
+	}
+	include, err := regexp.Compile(t.labels[labelInclude])
+	if err != nil {
+		logger.Printf("Error %s: %s: %v", t.url, labelInclude, err)
+		return nil
+	}
+	var exclude *regexp.Regexp
+	if e := t.labels[labelExclude]; e != "" {
+		if exclude, err = regexp.Compile(e); err != nil {
+			logger.Printf("Error %s: %s: %v", t.url, labelExclude, err)
+			return nil
+		}
+	}
+	if n, err := strconv.Atoi(t.labels[labelMaxURLs]); err == nil && n > 0 {
+		maxURLs = n
+	}
+	var ts []Target
+	seen := make(map[string]bool)
+	for _, page := range pages[sitemap] {
+		if seen[page] || !include.MatchString(page) || exclude != nil && exclude.MatchString(page) {
+			continue
+		}
+		seen[page] = true
+		if len(ts) == maxURLs {
+			logger.Printf("%s: polling the first %d pages only", t.url, maxURLs)
+			break
+		}
+		labels := map[string]string{"sitemap": sitemap}
+		for k, v := range t.labels {
+			if k != labelInclude && k != labelExclude && k != labelMaxURLs {
+				labels[k] = v
+			}
+		}
+		et := t
+		et.url, et.labels = page, labels
+		ts = append(ts, et)
+	}
+	return ts
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceSLA is one service's compliance with its SLO over a month.
+type serviceSLA struct {
+	Service      string   `json:"service"`
+	Targets      []string `json:"targets"`
+	SLO          float64  `json:"slo_percent"`
+	Polls        int      `json:"polls"`
+	Up           int      `json:"up"`
+	Availability float64  `json:"availability_percent"`
+	// The error budget is the downtime the SLO allows over the month; the
+	// downtime is estimated from the share of failed polls.
+	BudgetMinutes   float64 `json:"error_budget_minutes"`
+	DowntimeMinutes float64 `json:"downtime_minutes"`
+	BudgetBurned    float64 `json:"error_budget_burned_percent"`
+	Met             bool    `json:"met"`
+}
+
+// slaReport is the SLA compliance of every service for one month.
+type slaReport struct {
+	Month    string       `json:"month"` // YYYY-MM, in displayLocation
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Services []serviceSLA `json:"services"`
+}
+
+// monthRange returns the bounds of month (YYYY-MM) in displayLocation; the
+// current month ends now.
+func monthRange(month string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation("2006-01", month, displayLocation)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("bad month %q: want YYYY-MM", month)
+	}
+	end := start.AddDate(0, 1, 0)
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("month %s has not started", month)
+	}
+	return start, end, nil
+}
+
+/*
+buildSLAReport works out each service's availability over month from the history.
+A target belongs to the service named by its label serviceLabel, or is a service of its own when
+it has none or is no longer known, and its SLO is its "slo" label, e.g. 99.9, or defaultSLO. A
+service's SLO is the strictest of its targets'. Paused polls do not count.
+*/
+func buildSLAReport(h *History, targets []Target, serviceLabel string, defaultSLO float64, month string) (slaReport, error) {
+	from, to, err := monthRange(month)
+	if err != nil {
+		return slaReport{}, err
+	}
+	sum, err := buildSummary(h, nil, from, to)
+	if err != nil {
+		return slaReport{}, err
+	}
+	known := make(map[string]Target)
+	for _, t := range targets {
+		known[t.url] = t
+	}
+	byService := make(map[string]*serviceSLA)
+	for _, ts := range sum.Targets {
+		t := known[ts.URL]
+		name := t.labels[serviceLabel]
+		if name == "" {
+			name = ts.URL
+		}
+		slo := defaultSLO
+		if v, err := strconv.ParseFloat(t.labels["slo"], 64); err == nil && v > 0 && v < 100 {
+			slo = v
+		}
+		s, ok := byService[name]
+		if !ok {
+			s = &serviceSLA{Service: name, SLO: slo}
+			byService[name] = s
+		}
+		if slo > s.SLO {
+			s.SLO = slo
+		}
+		s.Targets = append(s.Targets, ts.URL)
+		s.Polls += ts.Polls
+		s.Up += ts.Up
+	}
+	rep := slaReport{Month: month, From: from, To: to, Services: []serviceSLA{}}
+	minutes := to.Sub(from).Minutes()
+	for _, s := range byService {
+		if s.Polls > 0 {
+			s.Availability = 100 * float64(s.Up) / float64(s.Polls)
+		}
+		s.BudgetMinutes = (100 - s.SLO) / 100 * minutes
+		s.DowntimeMinutes = (100 - s.Availability) / 100 * minutes
+		if s.BudgetMinutes > 0 {
+			s.BudgetBurned = 100 * s.DowntimeMinutes / s.BudgetMinutes
+		}
+		s.Met = s.Availability >= s.SLO
+		rep.Services = append(rep.Services, *s)
+	}
+	sort.Slice(rep.Services, func(i, j int) bool { return rep.Services[i].Service < rep.Services[j].Service })
+	return rep, nil
+}
+
+// writeCSV writes rep as CSV, one row per service.
+func (rep slaReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"month", "service", "targets", "slo_percent", "availability_percent", "error_budget_minutes", "downtime_minutes", "error_budget_burned_percent", "met", "polls", "up"})
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, s := range rep.Services {
+		cw.Write([]string{rep.Month, s.Service, strings.Join(s.Targets, " "), f(s.SLO), f(s.Availability), f(s.BudgetMinutes), f(s.DowntimeMinutes), f(s.BudgetBurned), strconv.FormatBool(s.Met), strconv.Itoa(s.Polls), strconv.Itoa(s.Up)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// lastMonth returns the month before now's, as YYYY-MM. It steps back from the
+// first of now's month, since a month back from March 31st is March 3rd.
+func lastMonth(now time.Time) string {
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return first.AddDate(0, -1, 0).Format("2006-01")
+}
+
+// slaAPI answers GET /sla?month=YYYY-MM&format=json|csv with the SLA report
+// of that month, the previous one by default, in JSON by default.
+func slaAPI(h *History, controls chan<- schedulerControl, serviceLabel string, defaultSLO float64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		month := r.URL.Query().Get("month")
+		if month == "" {
+			month = lastMonth(time.Now().In(displayLocation))
+		}
+		reply := make(chan controlReply)
+		controls <- schedulerControl{op: "list", reply: reply}
+		var targets []Target
+		for _, lt := range (<-reply).targets {
+			targets = append(targets, lt.target)
+		}
+		rep, err := buildSLAReport(h, targets, serviceLabel, defaultSLO, month)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			writeJSON(w, rep)
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="sla-`+month+`.csv"`)
+			rep.writeCSV(w)
+		default:
+			http.Error(w, "format: want json or csv", http.StatusBadRequest)
+		}
+	})
+}
+
+// slaCommand implements "sla [-month YYYY-MM] [-format json|csv]", which
+// prints the SLA report of a running poller. It returns the process exit code.
+func slaCommand(args []string) int {
+	fs := flag.NewFlagSet("sla", flag.ContinueOnError)
+	api := fs.String("api", defaultAPI, "`URL` of the running poller's API")
+	month := fs.String("month", "", "`YYYY-MM` to report on (default: last month)")
+	format := fs.String("format", "csv", "output `format`: csv or json")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	q := url.Values{"format": {*format}}
+	if *month != "" {
+		q.Set("month", *month)
+	}
+	return apiGet(*api, "/sla?"+q.Encode(), os.Stdout)
+}
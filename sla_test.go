@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMonthRange(t *testing.T) {
+	displayLocation = time.UTC
+	for _, tc := range []struct {
+		month    string
+		from, to string
+	}{
+		{"2024-02", "2024-02-01T00:00:00Z", "2024-03-01T00:00:00Z"},
+		{"2024-12", "2024-12-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+	} {
+		from, to, err := monthRange(tc.month)
+		if err != nil || from.Format(time.RFC3339) != tc.from || to.Format(time.RFC3339) != tc.to {
+			t.Errorf("monthRange(%s) = %s, %s, %v; want %s, %s", tc.month, from, to, err, tc.from, tc.to)
+		}
+	}
+	now := time.Now()
+	if _, to, err := monthRange(now.Format("2006-01")); err != nil || to.Before(now) || to.Sub(now) > time.Minute {
+		t.Errorf("this month ends at %s, %v; want about now", to, err)
+	}
+	for _, month := range []string{"", "2024", "2024-13", "May 2024", now.AddDate(0, 2, 0).Format("2006-01")} {
+		if _, _, err := monthRange(month); err == nil {
+			t.Errorf("monthRange(%q) succeeded", month)
+		}
+	}
+
+	displayLocation, _ = time.LoadLocation("Asia/Tokyo")
+	defer func() { displayLocation = time.UTC }()
+	if from, _, _ := monthRange("2024-02"); from.UTC().Format(time.RFC3339) != "2024-01-31T15:00:00Z" {
+		t.Errorf("February in Tokyo starts at %s", from.UTC())
+	}
+}
+
+func TestLastMonth(t *testing.T) {
+	for _, tc := range []struct{ now, want string }{
+		{"2026-03-31T12:00:00Z", "2026-02"},
+		{"2026-03-01T00:00:00Z", "2026-02"},
+		{"2026-01-15T08:00:00Z", "2025-12"},
+		{"2026-10-31T23:59:59Z", "2026-09"},
+	} {
+		now, _ := time.Parse(time.RFC3339, tc.now)
+		if got := lastMonth(now); got != tc.want {
+			t.Errorf("lastMonth(%s) = %s, want %s", tc.now, got, tc.want)
+		}
+	}
+}
+
+func TestBuildSLAReport(t *testing.T) {
+	displayLocation = time.UTC
+	dir := t.TempDir()
+	hour := time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC)
+	var records []historyRecord
+	add := func(url string, n int, up bool, status string) {
+		for i := 0; i < n; i++ {
+			records = append(records, historyRecord{Time: hour.Add(time.Duration(len(records)) * time.Second), URL: url, Status: status, Up: up})
+		}
+	}
+	add("https://a/", 9, true, "200 OK")
+	add("https://a/", 1, false, "503 Service Unavailable")
+	add("https://b/", 10, true, "200 OK")
+	add("https://b/", 5, false, statusPaused) // not polled, so it does not count
+	add("https://c/", 4, true, "200 OK")
+	add("https://gone/", 2, false, "404 Not Found")
+	f, err := os.Create(filepath.Join(dir, rawSegment(hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		enc.Encode(r)
+	}
+	f.Close()
+	_, h, err := HistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := []Target{
+		{url: "https://a/", labels: map[string]string{"service": "checkout", "slo": "99.9"}},
+		{url: "https://b/", labels: map[string]string{"service": "checkout", "slo": "99"}},
+		{url: "https://c/"},
+	}
+	rep, err := buildSLAReport(h, targets, "service", 99.5, "2024-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	minutes := float64(31 * 24 * 60)
+	want := []serviceSLA{
+		{Service: "checkout", Targets: []string{"https://a/", "https://b/"}, SLO: 99.9, Polls: 20, Up: 19, Availability: 95,
+			BudgetMinutes: 0.001 * minutes, DowntimeMinutes: 0.05 * minutes, BudgetBurned: 5000, Met: false},
+		{Service: "https://c/", Targets: []string{"https://c/"}, SLO: 99.5, Polls: 4, Up: 4, Availability: 100,
+			BudgetMinutes: 0.005 * minutes, Met: true},
+		{Service: "https://gone/", Targets: []string{"https://gone/"}, SLO: 99.5, Polls: 2, Availability: 0,
+			BudgetMinutes: 0.005 * minutes, DowntimeMinutes: minutes, BudgetBurned: 20000, Met: false},
+	}
+	if len(rep.Services) != len(want) {
+		t.Fatalf("services = %+v", rep.Services)
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+	for i, w := range want {
+		s := rep.Services[i]
+		if s.Service != w.Service || len(s.Targets) != len(w.Targets) || s.SLO != w.SLO || s.Polls != w.Polls || s.Up != w.Up || s.Met != w.Met ||
+			!near(s.Availability, w.Availability) || !near(s.BudgetMinutes, w.BudgetMinutes) ||
+			!near(s.DowntimeMinutes, w.DowntimeMinutes) || !near(s.BudgetBurned, w.BudgetBurned) {
+			t.Errorf("service %d = %+v, want %+v", i, s, w)
+		}
+	}
+	if rep.Month != "2024-05" || !rep.From.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) || !rep.To.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("report covers %s, %s to %s", rep.Month, rep.From, rep.To)
+	}
+}
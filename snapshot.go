@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotInterval is how often SnapshotWriter saves the monitor's state.
+const snapshotInterval = 30 * time.Second
+
+// snapshotVersion is bumped whenever the layout of the snapshot file changes
+// in a way older readers would misinterpret.
+const snapshotVersion = 1
+
+type snapshotFile struct {
+	Version int              `json:"version"`
+	Saved   time.Time        `json:"saved"`
+	Targets []snapshotTarget `json:"targets"`
+}
+
+type snapshotTarget struct {
+	URL      string    `json:"url"`
+	Status   string    `json:"status"`
+	Observed time.Time `json:"observed"` // when Status was, zero in snapshots from before it was kept
+	ErrCount int       `json:"err_count"`
+	Since    time.Time `json:"since"`
+}
+
+// loadSnapshot reads the states saved at path. A missing file is a first run
+// and yields no states.
+func loadSnapshot(path string) ([]State, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f snapshotFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if f.Version != snapshotVersion {
+		logger.Printf("Ignoring %s: snapshot version %d, want %d", path, f.Version, snapshotVersion)
+		return nil, nil
+	}
+	states := make([]State, len(f.Targets))
+	for i, t := range f.Targets {
+		res := statusResult(t.Status)
+		res.Timestamp = t.Observed
+		states[i] = State{url: t.URL, result: res, errCount: t.ErrCount, since: t.Since}
+	}
+	return states, nil
+}
+
+/*
+SnapshotWriter saves the latest state of every URL to path every interval, so that the next run
+can restore status, error count and last-change time instead of starting over.
+It returns the channel on which it wants to hear about state changes; pass it to StateMonitor
+as a listener. It starts from restored so that targets not yet polled since the restart are not
+dropped from the file, as discovery may not have listed them yet. Any other URL live from
+WatchTargets shows the Scheduler has retired is dropped; paused ones stay, to be restored paused.
+The file is written to a temporary name and renamed into place, so a crash mid-write never
+leaves a truncated snapshot behind.
+*/
+func SnapshotWriter(path string, interval time.Duration, restored []State, live <-chan map[string]bool) chan<- State {
+	states := make(chan State)
+	latest := make(map[string]snapshotTarget)
+	unpolled := make(map[string]bool)
+	for _, s := range restored {
+		latest[s.url] = snapshotTarget{URL: s.url, Status: s.result.String(), Observed: s.result.Timestamp, ErrCount: s.errCount, Since: s.since}
+		unpolled[s.url] = true
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		dirty := false
+		for {
+			select {
+			case s := <-states:
+				latest[s.url] = snapshotTarget{URL: s.url, Status: s.result.String(), Observed: s.result.Timestamp, ErrCount: s.errCount, Since: s.since}
+				delete(unpolled, s.url)
+				dirty = true
+			case targets := <-live:
+				for u := range latest {
+					if _, ok := targets[u]; !ok && !unpolled[u] {
+						delete(latest, u)
+						dirty = true
+					}
+				}
+			case now := <-ticker.C:
+				if !dirty {
+					continue
+				}
+				f := snapshotFile{Version: snapshotVersion, Saved: now.UTC()}
+				for _, t := range latest {
+					f.Targets = append(f.Targets, t)
+				}
+				sort.Slice(f.Targets, func(i, j int) bool { return f.Targets[i].URL < f.Targets[j].URL })
+				if err := writeFileAtomic(path, f); err != nil {
+					logger.Println("Error", "snapshot", err)
+					continue
+				}
+				dirty = false
+			}
+		}
+	}()
+	return states
+}
+
+// writeFileAtomic replaces path with the JSON encoding of v.
+func writeFileAtomic(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
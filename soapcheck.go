@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+)
+
+// soapPrefix marks a SOAP or other XML-over-HTTP endpoint, checked by
+// posting an envelope to it, as in soap+https://legacy.internal/OrderService.
+const soapPrefix = "soap+"
+
+// Target labels of soap+ targets: the envelope to post and the XPath
+// assertions about the answer, named files in -soap-dir, and the SOAPAction.
+const (
+	labelEnvelope   = "envelope"
+	labelXPath      = "xpath"
+	labelSOAPAction = "soap-action"
+)
+
+// soap12 is the namespace of SOAP 1.2 envelopes, which are posted as
+// application/soap+xml rather than text/xml.
+const soap12 = "http://www.w3.org/2003/05/soap-envelope"
+
+/*
+soapCheck checks a legacy XML service: it posts the envelope name.xml from -soap-dir, for a target
+labelled envelope=name, and evaluates the assertions of the target's xpath=name label, the lines of
+name.xpath, against the answer. The envelope is a text/template given the target's URL and Labels,
+so one envelope serves several targets; a label it uses that the target lacks is an error. Each
+assertion is an XPath expression that must hold: a boolean that is true, a node set that is not
+empty, a string or number that is not empty or zero, as in count(//Order) > 0 or
+//Status[text()='OK']. A response with a SOAP Fault is down whatever the assertions say, as is one
+that is not XML. soap-action sets the SOAPAction header, and headers=name the Name: value lines of
+name.headers in -soap-dir.
+*/
+func soapCheck(endpoint string, labels map[string]string) string {
+	if labels[labelEnvelope] == "" {
+		return checkFail("soap: no %s label", labelEnvelope)
+	}
+	raw, err := readCheckFile(*soapDir, "-soap-dir", labels[labelEnvelope]+".xml")
+	if err != nil {
+		return checkFail("soap: %s: %v", labelEnvelope, err)
+	}
+	tmpl, err := template.New(labels[labelEnvelope]).Option("missingkey=error").Parse(string(raw))
+	if err != nil {
+		return checkFail("soap: %v", err)
+	}
+	var envelope bytes.Buffer
+	if err := tmpl.Execute(&envelope, struct {
+		URL    string
+		Labels map[string]string
+	}{endpoint, labels}); err != nil {
+		return checkFail("soap: %v", err)
+	}
+	var assertions []string
+	if name := labels[labelXPath]; name != "" {
+		b, err := readCheckFile(*soapDir, "-soap-dir", name+".xpath")
+		if err != nil {
+			return checkFail("soap: %s: %v", labelXPath, err)
+		}
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				assertions = append(assertions, line)
+			}
+		}
+	}
+
+	contentType := "text/xml; charset=utf-8"
+	if bytes.Contains(envelope.Bytes(), []byte(soap12)) {
+		contentType = "application/soap+xml; charset=utf-8"
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, &envelope)
+	if err != nil {
+		return checkFail("soap: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if action, ok := labels[labelSOAPAction]; ok {
+		req.Header.Set("SOAPAction", `"`+action+`"`)
+	}
+	if err := setHeaders(req, *soapDir, "-soap-dir", labels[labelHeaders]); err != nil {
+		return checkFail("soap: %v", err)
+	}
+	client, err := probeClient(labels)
+	if err != nil {
+		return checkFail("soap: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return checkFail("soap: %v", err)
+	}
+	defer resp.Body.Close()
+	doc, err := xmlquery.Parse(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return checkFail("soap: %s: answer is not XML: %v", resp.Status, err)
+	}
+	if fault := xmlquery.FindOne(doc, "//*[local-name()='Fault']"); fault != nil {
+		reason := xmlquery.FindOne(fault, ".//*[local-name()='faultstring' or local-name()='Text']")
+		if reason != nil {
+			return checkFail("soap: fault: %s", strings.TrimSpace(reason.InnerText()))
+		}
+		return checkFail("soap: fault")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return checkFail("soap: %s", resp.Status)
+	}
+	for _, a := range assertions {
+		ok, err := xpathHolds(doc, a)
+		if err != nil {
+			return checkFail("soap: %s: %v", a, err)
+		}
+		if !ok {
+			return checkFail("soap: %s does not hold", a)
+		}
+	}
+	switch len(assertions) {
+	case 0:
+		return checkOK("%s", resp.Status)
+	case 1:
+		return checkOK("%s, %s holds", resp.Status, assertions[0])
+	}
+	return checkOK("%s, all %d assertions hold", resp.Status, len(assertions))
+}
+
+// xpathHolds evaluates expr against doc and reports whether it holds.
+func xpathHolds(doc *xmlquery.Node, expr string) (bool, error) {
+	e, err := xpath.Compile(expr)
+	if err != nil {
+		return false, err
+	}
+	switch v := e.Evaluate(xmlquery.CreateXPathNavigator(doc)).(type) {
+	case bool:
+		return v, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		return v != "", nil
+	case *xpath.NodeIterator:
+		return v.MoveNext(), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srvPrefix marks a target whose host is a DNS SRV name, as in
+// srv+http://_api._tcp.example.com/health.
+const srvPrefix = "srv+"
+
+// srvRecords maps an SRV name to the host:port pairs it resolved to.
+type srvRecords map[string][]string
+
+/*
+SRVExpander sits between the discovery sources and the Scheduler and expands srv+ targets into
+one target per SRV record, so srv+http://_api._tcp.example.com/health becomes
+http://host1:8080/health, http://host2:8080/health and so on. Every interval it resolves all SRV
+names again and sends the affected sources' updates on, so records that appear or disappear
+start or stop being polled through the Scheduler's usual bookkeeping.
+A failed lookup keeps the previous answer: a DNS hiccup should not retire healthy targets.
+The lookups run on their own goroutine so that slow DNS never blocks the sources sending updates.
+*/
+func SRVExpander(in <-chan TargetUpdate, interval time.Duration) <-chan TargetUpdate {
+	next := make(chan TargetUpdate)
+	resolved := make(chan srvRecords)
+	go func() {
+		sources := make(map[string][]Target)
+		records := make(srvRecords)
+		ticker := time.NewTicker(interval)
+		lookup := func(names []string) {
+			if len(names) > 0 {
+				go func() { resolved <- lookupSRV(names) }()
+			}
+		}
+		forward := func(source string) {
+			var ts []Target
+			for _, t := range sources[source] {
+				ts = append(ts, expandSRV(t, records)...)
+			}
+			next <- TargetUpdate{source: source, targets: ts}
+		}
+		for {
+			select {
+			case u := <-in:
+				sources[u.source] = u.targets
+				var unknown []string
+				for _, t := range u.targets {
+					if name, ok := srvName(t.url); ok {
+						if _, seen := records[name]; !seen {
+							unknown = append(unknown, name)
+						}
+					}
+				}
+				lookup(unknown)
+				forward(u.source)
+			case <-ticker.C:
+				names := make(map[string]bool)
+				for _, ts := range sources {
+					for _, t := range ts {
+						if name, ok := srvName(t.url); ok {
+							names[name] = true
+						}
+					}
+				}
+				var all []string
+				for name := range names {
+					all = append(all, name)
+				}
+				lookup(all)
+			case rs := <-resolved:
+				for name, hosts := range rs {
+					records[name] = hosts
+				}
+				for source, ts := range sources {
+					for _, t := range ts {
+						if name, ok := srvName(t.url); ok && rs[name] != nil {
+							forward(source)
+							break
+						}
+					}
+				}
+			}
+		}
+	}()
+	return next
+}
+
+// srvName returns the SRV name of a srv+ target.
+func srvName(rawURL string) (string, bool) {
+	if !strings.HasPrefix(rawURL, srvPrefix) {
+		return "", false
+	}
+	u, err := url.Parse(strings.TrimPrefix(rawURL, srvPrefix))
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	return u.Host, true
+}
+
+// lookupSRV resolves each name, leaving out the ones that fail.
+func lookupSRV(names []string) srvRecords {
+	rs := make(srvRecords)
+	for _, name := range names {
+		_, addrs, err := net.LookupSRV("", "", name)
+		if err != nil {
+			logger.Println("Error", srvPrefix+name, err)
+			continue
+		}
+		hosts := make([]string, 0, len(addrs))
+		for _, a := range addrs {
+			hosts = append(hosts, net.JoinHostPort(strings.TrimSuffix(a.Target, "."), strconv.Itoa(int(a.Port))))
+		}
+		rs[name] = hosts
+	}
+	return rs
+}
+
+// expandSRV returns t itself when it is not a srv+ target and otherwise one
+// target per known record, labelled with the SRV name.
+func expandSRV(t Target, records srvRecords) []Target {
+	name, ok := srvName(t.url)
+	if !ok {
+		return []Target{t}
+	}
+	u, _ := url.Parse(strings.TrimPrefix(t.url, srvPrefix))
+	var ts []Target
+	for _, host := range records[name] {
+		labels := map[string]string{"srv": name}
+		for k, v := range t.labels {
+			labels[k] = v
+		}
+		e := *u
+		e.Host = host
+		et := t
+		et.url, et.labels = e.String(), labels
+		ts = append(ts, et)
+	}
+	return ts
+}
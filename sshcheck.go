@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+sshCheck checks that an SSH server, a bastion or jump host, is there and is the one expected.
+ssh://bastion.example.com completes the handshake and stops at authentication, which proves the
+server is up without needing an account on it. With a user, ssh://poller@bastion.example.com,
+and -ssh-key, it also authenticates and runs -ssh-command, which must succeed. The command is
+the same for every target, as targets can come from any discovery source.
+hostkey=SHA256:... pins the server's host key, as ssh-keygen -lf prints its fingerprint; without
+it any key is accepted, and its fingerprint reported.
+*/
+func sshCheck(u *url.URL) string {
+	ctx, cancel, err := checkDeadline(u)
+	if err != nil {
+		return checkFail("ssh: %v", err)
+	}
+	defer cancel()
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	pin := u.Query().Get("hostkey")
+	var fingerprint string
+	config := &ssh.ClientConfig{
+		User: "sharemem",
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			fingerprint = ssh.FingerprintSHA256(key)
+			if pin != "" && fingerprint != pin {
+				return fmt.Errorf("host key %s is not the pinned %s", fingerprint, pin)
+			}
+			return nil
+		},
+	}
+	login := u.User.Username() != "" && *sshKey != ""
+	if login {
+		signer, err := loadSSHKey(*sshKey)
+		if err != nil {
+			return checkFail("ssh: %v", err)
+		}
+		config.User = u.User.Username()
+		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	}
+
+	d, err := checkDialer("tcp", u)
+	if err != nil {
+		return checkFail("ssh: %v", err)
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return checkFail("ssh: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		if !login && fingerprint != "" && strings.Contains(err.Error(), "unable to authenticate") {
+			return checkOK("%s: handshake complete, host key %s", addr, fingerprint)
+		}
+		return checkFail("%v", err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+	if !login {
+		return checkOK("%s: handshake complete, host key %s", addr, fingerprint)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return checkFail("ssh: %s: %v", addr, err)
+	}
+	defer session.Close()
+	start := time.Now()
+	if out, err := session.CombinedOutput(*sshCommand); err != nil {
+		return checkFail("ssh: %s: %q: %v: %s", addr, *sshCommand, err, strings.TrimSpace(string(out)))
+	}
+	return checkOK("%s: logged in as %s and ran %q in %s, host key %s", addr, config.User, *sshCommand,
+		time.Since(start).Round(time.Millisecond), fingerprint)
+}
+
+func loadSSHKey(path string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(pem)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Target labels asking for the content of an HTTP target to be checked for
+// freshness: max-age=30m, and timestamp=REGEXP to find its time in its body.
+const (
+	labelMaxAge    = "max-age"
+	labelTimestamp = "timestamp"
+)
+
+// maxTimestampBody bounds how much of a body is searched for a timestamp.
+const maxTimestampBody = 1 << 20
+
+/*
+staleness checks that what an HTTP target serves is newer than its max-age label, catching a
+cache that is stuck or a publishing pipeline that died behind a healthy 200. The content's time is
+its Last-Modified header or, with a timestamp label, the first group of that regexp matched
+against a GET of the body, as in timestamp="generated":\s*"([^"]+)": RFC 3339 or RFC 1123 times
+and Unix seconds or milliseconds are understood. An Age header older than max-age is stale too.
+It returns why the content is stale, or "" if it is fresh or its target asks for no check.
+*/
+func staleness(client *http.Client, resp *http.Response, labels map[string]string) string {
+	max, err := time.ParseDuration(labels[labelMaxAge])
+	if err != nil {
+		return ""
+	}
+	if age, err := strconv.Atoi(resp.Header.Get("Age")); err == nil && time.Duration(age)*time.Second > max {
+		return fmt.Sprintf("cached for %s, more than %s", time.Duration(age)*time.Second, max)
+	}
+	var modified time.Time
+	source := "last modified"
+	if pattern := labels[labelTimestamp]; pattern != "" {
+		source = "timestamp"
+		if modified, err = bodyTimestamp(client, resp.Request.URL.String(), pattern); err != nil {
+			return err.Error()
+		}
+	} else if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		if modified, err = http.ParseTime(lm); err != nil {
+			return fmt.Sprintf("Last-Modified %q: %v", lm, err)
+		}
+	} else {
+		return "no Last-Modified to check max-age against"
+	}
+	if age := time.Since(modified).Round(time.Second); age > max {
+		return fmt.Sprintf("%s %s ago, more than %s", source, age, max)
+	}
+	return ""
+}
+
+// bodyTimestamp GETs url and returns the time the first group of pattern
+// matches in its body.
+func bodyTimestamp(client *http.Client, url, pattern string) (time.Time, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil || re.NumSubexp() < 1 {
+		return time.Time{}, fmt.Errorf("%s: %q is not a regexp with a group", labelTimestamp, pattern)
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTimestampBody))
+	if err != nil {
+		return time.Time{}, err
+	}
+	m := re.FindSubmatch(body)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("no timestamp matching %s in the body", pattern)
+	}
+	return parseTimestamp(string(m[1]))
+}
+
+// parseTimestamp parses a time as content publishes it.
+func parseTimestamp(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, time.RFC1123, time.RFC1123Z, "2006-01-02 15:04:05Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("timestamp %q not understood", s)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// statDisk is not implemented here.
+func statDisk(path string) (diskUsage, error) {
+	return diskUsage{}, errors.New("disk checks are not supported on " + runtime.GOOS)
+}
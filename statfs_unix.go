@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// statDisk returns the usage of the filesystem holding path. Like df, it
+// counts the blocks reserved for root as used.
+func statDisk(path string) (diskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return diskUsage{}, err
+	}
+	bsize := uint64(st.Bsize)
+	used := uint64(st.Blocks) - uint64(st.Bfree)
+	return diskUsage{
+		total:      (used + uint64(st.Bavail)) * bsize,
+		free:       uint64(st.Bavail) * bsize,
+		inodes:     uint64(st.Files),
+		inodesFree: uint64(st.Ffree),
+	}, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throughputPrefix marks a payload to download and time, as in
+// throughput+https://mirror.example.com/ubuntu.iso, so that a slow CDN node or
+// mirror shows up and not just one that is down.
+const throughputPrefix = "throughput+"
+
+// Target labels of throughput+ targets: the byte range to download, the rates
+// below which the target is degraded or down, and how long it may take.
+const (
+	labelRange    = "range"
+	labelWarnRate = "warn-rate"
+	labelMinRate  = "min-rate"
+	labelTimeout  = "timeout"
+)
+
+const (
+	throughputTimeout  = time.Minute
+	throughputMaxBytes = 1 << 30 // of a payload downloaded without a range
+)
+
+// rateUnits are the units rates are written in, in bytes per second.
+var rateUnits = map[string]float64{
+	"B/s": 1, "kB/s": 1e3, "MB/s": 1e6, "GB/s": 1e9,
+	"bit/s": 1.0 / 8, "kbit/s": 1e3 / 8, "Mbit/s": 1e6 / 8, "Gbit/s": 1e9 / 8,
+}
+
+/*
+throughputCheck downloads the payload at url, or with range=0-1048575 just those bytes, and
+reports how fast it came once its first byte was in: a target labelled min-rate=10MB/s is down
+below that rate, and one labelled warn-rate=50Mbit/s warns in its status below that. Rates are in
+B/s, kB/s, MB/s or GB/s, or the same in bit/s. The download is uncompressed, may take up to a
+minute or its timeout label, and without a range stops after 1 GiB.
+*/
+func throughputCheck(url string, labels map[string]string) string {
+	var limits [2]float64
+	for i, key := range []string{labelWarnRate, labelMinRate} {
+		if s := labels[key]; s != "" {
+			r, err := parseRate(s)
+			if err != nil {
+				return checkFail("throughput: %s: %v", key, err)
+			}
+			limits[i] = r
+		}
+	}
+	warn, crit := limits[0], limits[1]
+	timeout := throughputTimeout
+	if s := labels[labelTimeout]; s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return checkFail("throughput: %s: %v", labelTimeout, err)
+		}
+		timeout = d
+	}
+	client, err := probeClient(labels)
+	if err != nil {
+		return checkFail("throughput: %v", err)
+	}
+	client.Timeout = timeout
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return checkFail("throughput: %v", err)
+	}
+	want := int64(throughputMaxBytes)
+	if r := labels[labelRange]; r != "" {
+		first, last, ok := strings.Cut(r, "-")
+		f, err1 := strconv.ParseInt(first, 10, 64)
+		l, err2 := strconv.ParseInt(last, 10, 64)
+		if !ok || err1 != nil || err2 != nil || l < f {
+			return checkFail("throughput: %s: %q is not first-last", labelRange, r)
+		}
+		want = l - f + 1
+		req.Header.Set("Range", "bytes="+r)
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return checkFail("throughput: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return checkFail("throughput: %s", resp.Status)
+	}
+	firstByte := time.Now()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, want))
+	elapsed := time.Since(firstByte)
+	if err != nil {
+		return checkFail("throughput: after %s: %v", byteSize(uint64(n)), err)
+	}
+	rate := float64(n) / max(elapsed.Seconds(), 1e-6)
+	report := fmt.Sprintf("%s in %s, first byte after %s: %s", byteSize(uint64(n)),
+		elapsed.Round(time.Millisecond), firstByte.Sub(start).Round(time.Millisecond), rateString(rate))
+	if labels[labelRange] != "" && resp.StatusCode == http.StatusOK {
+		report += " (server ignores Range)"
+	}
+	switch {
+	case crit > 0 && rate < crit:
+		return checkFail("throughput under %s: %s", labels[labelMinRate], report)
+	case warn > 0 && rate < warn:
+		return checkOK("warning: under %s: %s", labels[labelWarnRate], report)
+	}
+	return checkOK("%s", report)
+}
+
+// parseRate parses a rate such as 10MB/s or 80Mbit/s into bytes per second.
+func parseRate(s string) (float64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i <= 0 {
+		return 0, fmt.Errorf("%q is not a rate such as 10MB/s", s)
+	}
+	unit, ok := rateUnits[strings.TrimSpace(s[i:])]
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("%q is not a rate such as 10MB/s", s)
+	}
+	return n * unit, nil
+}
+
+// rateString renders a rate in bytes per second in the largest decimal unit
+// that keeps it at least 1, as rates are, as in 8.3 MB/s.
+func rateString(r float64) string {
+	for _, unit := range []string{"GB/s", "MB/s", "kB/s"} {
+		if r >= rateUnits[unit] {
+			return fmt.Sprintf("%.1f %s", r/rateUnits[unit], unit)
+		}
+	}
+	return fmt.Sprintf("%.0f B/s", r)
+}
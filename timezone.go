@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// displayLocation is the time zone the API renders timestamps in and reads
+// times without an offset in, set by -display-tz.
+var displayLocation = time.Local
+
+// displayed returns t in displayLocation, for the API to show; the instant is
+// the same. The zero time stays as it is, so that omitted times still are.
+func displayed(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.In(displayLocation)
+}
+
+// displayedPtr is displayed for an optional time.
+func displayedPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	d := displayed(*t)
+	return &d
+}
+
+// displayed returns s with its times in displayLocation, its certificates' too.
+func (s State) displayed() State {
+	s.result.Timestamp, s.since = displayed(s.result.Timestamp), displayed(s.since)
+	if s.chain != nil {
+		chain := make([]certInfo, len(s.chain))
+		for i, c := range s.chain {
+			c.NotAfter = displayed(c.NotAfter)
+			chain[i] = c
+		}
+		s.chain = chain
+	}
+	return s
+}
+
+// scheduleLayouts are the forms of time parseTime accepts besides Unix
+// seconds and RFC 3339; they are read in displayLocation.
+var scheduleLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func parseLocalTime(s string) (time.Time, error) {
+	for _, layout := range scheduleLayouts {
+		if t, err := time.ParseInLocation(layout, s, displayLocation); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q: want Unix seconds, RFC 3339 or YYYY-MM-DD[THH:MM[:SS]]", s)
+}
+
+/*
+schedule is a recurring time of day, daily, weekly or monthly, in a time zone:
+
+	daily 09:00
+	weekly mon 09:00 Europe/Berlin
+	monthly 1 06:30 America/New_York
+
+The zone is any IANA name and defaults to displayLocation. Occurrences follow the zone's wall
+clock through daylight saving changes: a time that does not exist that day runs at the
+equivalent instant after the gap, and one that occurs twice runs the first time.
+*/
+type schedule struct {
+	every   string // "daily", "weekly" or "monthly"
+	weekday time.Weekday
+	day     int // of the month, 1 to 28
+	hour    int
+	minute  int
+	loc     *time.Location
+}
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+func parseSchedule(s string) (schedule, error) {
+	f := strings.Fields(s)
+	bad := fmt.Errorf("bad schedule %q: want daily HH:MM, weekly DAY HH:MM or monthly N HH:MM, then optionally a time zone", s)
+	if len(f) < 2 {
+		return schedule{}, bad
+	}
+	sc := schedule{every: f[0], loc: displayLocation}
+	rest := f[1:]
+	switch sc.every {
+	case "daily":
+	case "weekly":
+		name := strings.ToLower(rest[0])
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		wd, ok := weekdays[name]
+		if !ok || len(rest) < 2 {
+			return schedule{}, bad
+		}
+		sc.weekday, rest = wd, rest[1:]
+	case "monthly":
+		n, err := strconv.Atoi(rest[0])
+		if err != nil || n < 1 || n > 28 || len(rest) < 2 {
+			return schedule{}, fmt.Errorf("bad schedule %q: the day of the month must be 1 to 28", s)
+		}
+		sc.day, rest = n, rest[1:]
+	default:
+		return schedule{}, bad
+	}
+	t, err := time.Parse("15:04", rest[0])
+	if err != nil {
+		return schedule{}, bad
+	}
+	sc.hour, sc.minute = t.Hour(), t.Minute()
+	switch len(rest) {
+	case 1:
+	case 2:
+		if sc.loc, err = time.LoadLocation(rest[1]); err != nil {
+			return schedule{}, fmt.Errorf("bad schedule %q: %v", s, err)
+		}
+	default:
+		return schedule{}, bad
+	}
+	return sc, nil
+}
+
+// next returns the first occurrence of sc after t.
+func (sc schedule) next(after time.Time) time.Time {
+	t := after.In(sc.loc)
+	for d := 0; ; d++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+d, sc.hour, sc.minute, 0, 0, sc.loc)
+		// Where the clocks go back, time.Date may give the second of the
+		// two instants showing that time; take the first.
+		_, off := day.Zone()
+		if _, before := day.Add(-12 * time.Hour).Zone(); before > off {
+			first := day.Add(-time.Duration(before-off) * time.Second).In(sc.loc)
+			if first.Hour() == sc.hour && first.Minute() == sc.minute {
+				day = first
+			}
+		}
+		switch {
+		case sc.every == "weekly" && day.Weekday() != sc.weekday:
+		case sc.every == "monthly" && day.Day() != sc.day:
+		case !day.After(after):
+		default:
+			return day
+		}
+	}
+}
+
+// String returns sc in the form parseSchedule reads.
+func (sc schedule) String() string {
+	var on string
+	switch sc.every {
+	case "weekly":
+		on = strings.ToLower(sc.weekday.String()[:3]) + " "
+	case "monthly":
+		on = strconv.Itoa(sc.day) + " "
+	}
+	return fmt.Sprintf("%s %s%02d:%02d %s", sc.every, on, sc.hour, sc.minute, sc.loc)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseScheduleNext(t *testing.T) {
+	displayLocation = time.UTC
+	for _, tc := range []struct {
+		schedule string
+		after    string
+		want     string
+	}{
+		{"daily 09:00", "2026-10-14T08:00:00Z", "2026-10-14T09:00:00Z"},
+		{"daily 09:00", "2026-10-14T09:00:00Z", "2026-10-15T09:00:00Z"}, // strictly after
+		{"weekly mon 09:00", "2026-10-14T12:00:00Z", "2026-10-19T09:00:00Z"},
+		{"weekly Monday 09:00", "2026-10-19T08:59:00Z", "2026-10-19T09:00:00Z"},
+		{"monthly 1 06:30 America/New_York", "2026-10-14T00:00:00Z", "2026-11-01T11:30:00Z"}, // EST by then
+		{"monthly 28 23:00 Asia/Tokyo", "2026-02-28T13:59:00Z", "2026-02-28T14:00:00Z"},
+		{"daily 02:30 Europe/Berlin", "2026-03-28T12:00:00Z", "2026-03-29T01:30:00Z"}, // in the gap: 03:30 CEST
+		{"daily 02:30 Europe/Berlin", "2026-10-24T12:00:00Z", "2026-10-25T00:30:00Z"}, // twice: the first, CEST
+		{"daily 03:30 Europe/Berlin", "2026-10-24T12:00:00Z", "2026-10-25T02:30:00Z"}, // once, in CET
+		{"daily 09:00 Europe/Berlin", "2026-10-24T12:00:00Z", "2026-10-25T08:00:00Z"},
+		{"daily 09:00 Europe/Berlin", "2026-10-25T12:00:00Z", "2026-10-26T08:00:00Z"},
+	} {
+		sc, err := parseSchedule(tc.schedule)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", tc.schedule, err)
+			continue
+		}
+		after, _ := time.Parse(time.RFC3339, tc.after)
+		want, _ := time.Parse(time.RFC3339, tc.want)
+		if got := sc.next(after); !got.Equal(want) {
+			t.Errorf("%s after %s = %s, want %s", tc.schedule, tc.after, got.UTC().Format(time.RFC3339), tc.want)
+		}
+		again, err := parseSchedule(sc.String())
+		if err != nil || again.String() != sc.String() {
+			t.Errorf("parseSchedule(%q) = %v, %v; want %v", sc.String(), again, err, sc)
+		}
+	}
+	for _, s := range []string{"", "daily", "hourly 09:00", "daily 9am", "daily 25:00", "weekly 09:00", "weekly xyz 09:00",
+		"monthly 0 09:00", "monthly 29 09:00", "monthly 1", "daily 09:00 Mars/Olympus_Mons", "daily 09:00 UTC extra"} {
+		if sc, err := parseSchedule(s); err == nil {
+			t.Errorf("parseSchedule(%q) = %v, want an error", s, sc)
+		}
+	}
+}
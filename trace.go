@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// traceMaxHops bounds how far a failure traceroute goes.
+const traceMaxHops = 20
+
+// networkError reports whether a status returned by Poll is a transport error
+// rather than an HTTP response, i.e. the request never got an answer.
+func networkError(status string) bool {
+	if status == statusPaused || strings.HasPrefix(status, statusFail) {
+		return false
+	}
+	_, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+	return err != nil
+}
+
+/*
+traceHops runs a traceroute to the host of rawURL and returns its hop report.
+It uses traceroute, or tracepath where traceroute is not installed, and gives up after timeout,
+returning whatever hops it has by then. It never fails: errors are described in the report
+instead, since the report is only ever read by a human.
+*/
+func traceHops(rawURL string, timeout time.Duration) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Sprintf("traceroute: no host in %q", rawURL)
+	}
+	host := u.Hostname()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("traceroute"); err == nil {
+		cmd = exec.CommandContext(ctx, "traceroute", "-n", "-q", "1", "-w", "2", "-m", strconv.Itoa(traceMaxHops), host)
+	} else if _, err := exec.LookPath("tracepath"); err == nil {
+		cmd = exec.CommandContext(ctx, "tracepath", "-n", "-m", strconv.Itoa(traceMaxHops), host)
+	} else {
+		return "traceroute: neither traceroute nor tracepath is installed"
+	}
+	out, err := cmd.CombinedOutput()
+	report := strings.TrimRight(string(out), "\n")
+	switch {
+	case ctx.Err() != nil:
+		report += fmt.Sprintf("\n(traceroute stopped after %v)", timeout)
+	case err != nil && report == "":
+		report = "traceroute: " + err.Error()
+	}
+	return "Traceroute to " + host + ":\n" + report
+}
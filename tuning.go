@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// tuning holds the scheduling parameters that may change while the poller
+// runs. The Scheduler owns the current one.
+type tuning struct {
+	interval   time.Duration // between polls of a target that is up
+	backoff    time.Duration // added to interval per consecutive error
+	maxBackoff time.Duration // most the back-off may add; 0 for no ceiling
+	pollers    int
+}
+
+// sleep is how long a Resource with errCount consecutive errors sleeps
+// before its next poll.
+func (t tuning) sleep(errCount int) time.Duration {
+	b := t.backoff * time.Duration(errCount)
+	if t.maxBackoff > 0 && b > t.maxBackoff {
+		b = t.maxBackoff
+	}
+	return t.interval + b
+}
+
+func (t tuning) json() map[string]interface{} {
+	return map[string]interface{}{
+		"interval": t.interval.String(), "backoff": t.backoff.String(),
+		"max_backoff": t.maxBackoff.String(), "pollers": t.pollers,
+	}
+}
+
+// tuningFromForm returns t changed by the fields of r's form that are set:
+// interval, backoff and max-backoff as durations, pollers as a count.
+func tuningFromForm(t tuning, r *http.Request) (tuning, error) {
+	for _, f := range []struct {
+		name string
+		d    *time.Duration
+		min  time.Duration
+	}{{"interval", &t.interval, time.Second}, {"backoff", &t.backoff, 0}, {"max-backoff", &t.maxBackoff, 0}} {
+		s := r.FormValue(f.name)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return t, fmt.Errorf("%s: %v", f.name, err)
+		}
+		if d < f.min {
+			return t, fmt.Errorf("%s: must be at least %s", f.name, f.min)
+		}
+		*f.d = d
+	}
+	if s := r.FormValue("pollers"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return t, fmt.Errorf("pollers: %q is not a positive number", s)
+		}
+		t.pollers = n
+	}
+	return t, nil
+}
+
+// tuningAPI serves the scheduling parameters:
+//
+//	GET  /tuning  the current ones
+//	POST /tuning  change those in the form: interval, backoff, max-backoff, pollers
+//
+// Changes apply from each target's next sleep, and to the Pollers once they
+// finish the poll they are on; they last until a restart. They are recorded
+// in audit.
+func tuningAPI(controls chan<- schedulerControl, audit *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := schedulerControl{op: "tuning"}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c.op = "tune"
+			c.tune = func(t tuning) (tuning, error) { return tuningFromForm(t, r) }
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reply := make(chan controlReply)
+		c.reply = reply
+		controls <- c
+		rep := <-reply
+		if rep.err != nil {
+			http.Error(w, rep.err.Error(), http.StatusBadRequest)
+			return
+		}
+		if rep.changed {
+			audit.Record(r, "tune", "", rep.prevTuning.json(), rep.tuning.json())
+		}
+		writeJSON(w, rep.tuning.json())
+	})
+}
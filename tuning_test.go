@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTuningFromForm(t *testing.T) {
+	current := tuning{interval: time.Minute, backoff: 10 * time.Second, maxBackoff: 0, pollers: 2}
+	for _, tc := range []struct {
+		form string
+		want tuning
+		err  string // a prefix of the error, empty when the form is good
+	}{
+		{"", current, ""},
+		{"interval=5m", tuning{5 * time.Minute, 10 * time.Second, 0, 2}, ""},
+		{"interval=1s&backoff=0s&max-backoff=10m&pollers=8", tuning{time.Second, 0, 10 * time.Minute, 8}, ""},
+		{"pollers=1", tuning{time.Minute, 10 * time.Second, 0, 1}, ""},
+		{"interval=500ms", current, "interval: must be at least 1s"},
+		{"interval=soon", current, "interval: "},
+		{"backoff=-1s", current, "backoff: must be at least 0s"},
+		{"max-backoff=1d", current, "max-backoff: "},
+		{"pollers=0", current, "pollers: "},
+		{"pollers=many", current, "pollers: "},
+	} {
+		r := httptest.NewRequest("POST", "/tuning", strings.NewReader(tc.form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		got, err := tuningFromForm(current, r)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%q: %v", tc.form, err)
+		case tc.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.err)):
+			t.Errorf("%q: err = %v, want %s…", tc.form, err, tc.err)
+		case tc.err == "" && got != tc.want:
+			t.Errorf("%q = %+v, want %+v", tc.form, got, tc.want)
+		}
+	}
+
+	// The query string counts as well as the body.
+	r := httptest.NewRequest("POST", "/tuning?"+url.Values{"pollers": {"4"}}.Encode(), nil)
+	if got, err := tuningFromForm(current, r); err != nil || got.pollers != 4 {
+		t.Errorf("pollers from the query = %+v, %v", got, err)
+	}
+}
+
+func TestTuningSleep(t *testing.T) {
+	tu := tuning{interval: time.Minute, backoff: 10 * time.Second, maxBackoff: 30 * time.Second}
+	for errs, want := range []time.Duration{time.Minute, 70 * time.Second, 80 * time.Second, 90 * time.Second, 90 * time.Second} {
+		if got := tu.sleep(errs); got != want {
+			t.Errorf("sleep(%d) = %s, want %s", errs, got, want)
+		}
+	}
+	tu.maxBackoff = 0
+	if got := tu.sleep(100); got != time.Minute+1000*time.Second {
+		t.Errorf("sleep(100) without a ceiling = %s", got)
+	}
+}
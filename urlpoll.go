@@ -5,16 +5,106 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"hash/fnv"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"example/concurrent/chanutil"
 )
 
 const (
-	numPollers     = 2                // number of Poller goroutines to launch
+	numPollers     = 2                // default number of Poller goroutines to launch
 	pollInterval   = 60 * time.Second // how often to poll each URL
 	statusInterval = 10 * time.Second // how often to log status to stdout
 	errTimeout     = 10 * time.Second // back-off timeout on error
+
+	statusBatchWait = 100 * time.Millisecond // longest a result waits for its -status-batch to fill
+)
+
+var (
+	operatorMode    = flag.Bool("operator", false, "poll the PollTarget resources of a Kubernetes cluster")
+	kubeAPI         = flag.String("kube-api", "", "Kubernetes API server `URL`, e.g. one served by kubectl proxy (default: in-cluster config)")
+	kubeDiscovery   = flag.Bool("kube-discovery", false, "poll Kubernetes Services and Ingresses annotated with "+annotationPath)
+	kubeNamespace   = flag.String("namespace", "", "only watch Kubernetes objects in this namespace (default: all namespaces)")
+	consulAddr      = flag.String("consul", "", "poll the healthy service instances registered with the Consul agent at `address`")
+	consulTags      = flag.String("consul-tags", "", "comma-separated `tags` a Consul service must carry to be polled")
+	consulInterval  = flag.Duration("consul-interval", 30*time.Second, "how often to refresh targets from Consul")
+	targetsDir      = flag.String("targets-dir", "", "poll the targets listed in the files of `directory`, following changes live")
+	route53NS       = flag.String("route53-namespace", "", "publish each target's health to this CloudWatch `namespace` for Route53 health checks")
+	cloudWatchNS    = flag.String("cloudwatch-namespace", "", "publish per-target availability and latency to this CloudWatch `namespace`")
+	datadog         = flag.Bool("datadog", false, "push metrics and state-change events to Datadog using $DD_API_KEY")
+	datadogSite     = flag.String("datadog-site", "datadoghq.com", "Datadog `site` to send to")
+	listenAddr      = flag.String("listen", "", "serve /metrics and the HTTP API on this `address`, e.g. :9100")
+	metricLabels    = flag.String("metric-labels", "", "comma-separated target `labels` to export with metrics (default: all)")
+	metricDeny      = flag.String("metric-labels-deny", "", "comma-separated target `labels` never to export with metrics")
+	metricURL       = flag.String("metric-url", "full", "how to export the url label: full, host (drop path and query) or hash (shorten path and query to a hash)")
+	historyDir      = flag.String("history-dir", "", "record every poll result under `directory` and serve them at /history")
+	rawRetention    = flag.Duration("history-raw-retention", 7*24*time.Hour, "keep raw poll results this long before compacting them into hourly rollups")
+	rollupRetention = flag.Duration("history-rollup-retention", 90*24*time.Hour, "keep hourly rollups this long")
+	awsRegion       = flag.String("aws-region", "", "AWS region for CloudWatch (default: $AWS_REGION)")
+	stateFile       = flag.String("state-file", "", "save the monitor's state to `file` periodically and restore it on startup")
+	walDir          = flag.String("wal-dir", "", "append every state transition to a checksummed log in `directory`")
+	recentResults   = flag.Int("recent", 100, "keep up to `n` recent results per target in memory for /recent (0: off)")
+	recentMemory    = flag.Int64("recent-memory", 64<<20, "keep at most this many `bytes` of recent results in all, dropping the oldest")
+	walMaxSize      = flag.Int64("wal-max-size", 16<<20, "start a new transition log segment after this many `bytes`")
+	walMaxFiles     = flag.Int("wal-max-files", 8, "keep at most this many transition log segments")
+	auditFile       = flag.String("audit-log", "", "append every administrative action to `file` and serve it at /audit")
+	tokenFile       = flag.String("admin-tokens", "", "require one of the \"principal token\" lines in `file` for the admin API")
+	degradeFactor   = flag.Float64("degrade-factor", 3, "raise a DEGRADING event when a target's mean latency grows by this `factor` from one window to the next")
+	degradeWindow   = flag.Int("degrade-window", 5, "number of `polls` per latency window")
+	traceTimeout    = flag.Duration("trace-timeout", 30*time.Second, "run a traceroute of at most this long when a target becomes unreachable, and attach it to the alert (0 to disable)")
+	captureBytes    = flag.Int("capture-bytes", 4096, "when a target answers with an error, fetch it again and attach its headers and the first `n` bytes of its body to the alert (0 to disable)")
+	harDir          = flag.String("har-dir", "", "write a HAR file of every failed poll to `directory`")
+	correlateWindow = flag.Duration("correlate-window", 30*time.Second, "hold DOWN events this long to fold targets failing together into one alert (0 to disable)")
+	correlateMin    = flag.Int("correlate-min", 3, "fold DOWN events into one alert when at least this many `targets` share a host, domain or label")
+	correlateLabels = flag.String("correlate-labels", "", "comma-separated target `labels` to correlate failures by, besides host and domain")
+	incidentFile    = flag.String("incident-file", "", "keep incidents in `file` across restarts")
+	incidentResolve = flag.Duration("incident-resolve-after", 5*time.Minute, "resolve an incident once all its targets have stayed up this long")
+	silenceFile     = flag.String("silence-file", "", "keep alert silences in `file` across restarts")
+	escalationFile  = flag.String("escalation-policies", "", "notify webhooks, Slack and PagerDuty in stages as an outage goes on, following the policies in the JSON `file`")
+	displayTZ       = flag.String("display-tz", "Local", "IANA time `zone` the API shows timestamps in and reads times without an offset in, e.g. Europe/Berlin")
+	reportSchedule  = flag.String("report", "", "send a summary report on this `schedule`, e.g. \"weekly mon 09:00 Europe/Berlin\" (needs -history-dir)")
+	reportTo        = flag.String("report-to", "", "comma-separated report `destinations`: file:DIR, slack:WEBHOOK-URL, mailto:ADDRESS")
+	reportFormat    = flag.String("report-format", formatText, "`format` of scheduled reports: text, markdown or html")
+	reportTemplate  = flag.String("report-template", "", "render scheduled reports with the template in `file` instead of the built-in one")
+	slaLabel        = flag.String("sla-service-label", "service", "target `label` that groups targets into services for SLA reports")
+	slaDefault      = flag.Float64("slo", 99.9, "availability `percent` a service must meet, unless its targets have an slo label")
+	smtpAddr        = flag.String("smtp", "", "SMTP relay `host:port` for mailing reports, authenticating with $SMTP_USERNAME and $SMTP_PASSWORD if set")
+	smtpFrom        = flag.String("smtp-from", "poller@localhost", "sender `address` of mailed reports")
+	statusBatch     = flag.Int("status-batch", 0, "hand poll results to the StateMonitor in batches of up to `n`, or what came in 100ms, instead of one by one")
+	listenerBuffer  = flag.Int("listener-buffer", 0, "queue up to `n` updates for each sink and alerter, dropping its oldest when full, instead of making the StateMonitor wait for the slowest")
+	statusBuffer    = flag.Int("status-buffer", 0, "queue up to `n` poll results for the StateMonitor, dropping the oldest when full, instead of making Pollers wait for it")
+	checkDir        = flag.String("check-dir", "", "`directory` of the scripts exec: targets may run")
+	sshKey          = flag.String("ssh-key", "", "private key `file` to log in to ssh:// targets that name a user with")
+	sshCommand      = flag.String("ssh-command", "true", "`command` to run on ssh:// targets once logged in")
+	hookDir         = flag.String("hook-dir", "", "`directory` of the scripts targets name in their on-failure and on-success labels")
+	onFailure       = flag.String("on-failure", "", "shell `command` to run when a target without an on-failure label goes down")
+	onSuccess       = flag.String("on-success", "", "shell `command` to run when a target without an on-success label recovers")
+	hookTimeout     = flag.Duration("hook-timeout", 30*time.Second, "kill hook commands still running after this long")
+	monitorShards   = flag.Int("monitor-shards", 1, "split the StateMonitor's map between this many goroutines by URL, for very many targets")
+	pollers         = flag.Int("pollers", numPollers, "number of Poller goroutines; each polls one target at a time")
+	maxBackoff      = flag.Duration("max-backoff", 0, "most the back-off after consecutive errors may add to the poll interval (0: no ceiling)")
+	watchdogTimeout = flag.Duration("watchdog", time.Minute, "log a warning and a goroutine dump when the Scheduler or StateMonitor makes no progress for this long; must exceed 10s (0: off)")
+	queueSize       = flag.Int("queue-size", 0, "hold up to `n` Resources waiting for a Poller in a queue whose depth and wait times are exported on /metrics")
+	queueFull       = flag.String("queue-full", "block", "what to do with a Resource due while the -queue-size queue is full: `block` until there is room, or reject it, skipping that poll")
+	srvInterval     = flag.Duration("srv-interval", 30*time.Second, "how often to re-resolve the SRV records of srv+ targets")
+	sitemapInterval = flag.Duration("sitemap-interval", time.Hour, "how often to fetch the sitemaps of sitemap+ targets again")
+	sitemapMax      = flag.Int("sitemap-max", 500, "how many pages of a sitemap+ target to poll at most, unless its max-urls label says otherwise")
+	graphqlDir      = flag.String("graphql-dir", "", "`dir`ectory of the queries and header files graphql+ targets name in their query and headers labels")
+	secretDir       = flag.String("secret-dir", "", "`dir`ectory of the password files check targets name with password_file=")
+	soapDir         = flag.String("soap-dir", "", "`dir`ectory of the envelopes, XPath assertions and header files soap+ targets name in their labels")
+	sourceFlag      = flag.String("source", "", "send probes from this local IP `address` or interface, unless a target's source label or parameter says otherwise")
+	logFormat       = flag.String("log-format", "text", "write the log as `text` lines or as json objects with time, level and msg")
+	debugEndpoints  = flag.Bool("debug", false, "serve pprof profiles, goroutine stacks and channel stats under /debug/ to admins (needs -admin-tokens)")
+	ignoreRobots    = flag.Bool("ignore-robots", false, "poll the pages of sitemap+ targets even where robots.txt disallows them; only for sites you run")
 )
 
 var urls = []string{
@@ -25,77 +115,450 @@ var urls = []string{
 
 // State represents the last-known state of a URL.
 type State struct {
-	url    string
-	status string
+	url      string
+	result   Result
+	target   Target            // what was polled; never modified
+	errCount int               // consecutive failed polls, including this one
+	response *capturedResponse // what a target that answered with an error returned, if captured
+	chain    []certInfo        // the certificates a TLS target presented, leaf first
+
+	// Filled in by the StateMonitor before the State reaches its listeners.
+	prev  Result    // the previous result, the zero Result for a URL's first poll
+	since time.Time // when the URL last went from up to down or back
 }
 
 // StateMonitor maintains a map that stores the state of the URLs being
-// polled, and prints the current state every updateInterval nanoseconds.
-// It returns a chan State to which resource state should be sent.
+// polled, and prints the current state every updateInterval nanoseconds, on
+// the clock's boundaries: with 10s, at :00, :10, :20 and so on.
+// It returns a Monitor, whose Updates is the chan State to which resource
+// state should be sent, and whose Batches takes batches of them, which it
+// records in order.
 /*
-StateMonitor will loop forever, selecting on two channels: ticker.C and update.
+StateMonitor will loop until stopped, selecting on its channels: snapshots, update and quit.
 The select statement blocks until one of its communications is ready to proceed.
-When StateMonitor receives a tick from ticker.C, it calls logState to print the current state.
+On every tick of ticker.C, it is asked on snapshots for a copy of the map, which logState prints.
 When it receives a State update from updates, it records the new status in the urlStatus map.
 Notice that this goroutine owns the urlStatus data structure, ensuring that it can only be accessed sequentially.
 This prevents memory corruption issues that might arise from parallel reads and/or writes to a shared map.
 */
-// the return is channel only be used for send data(cannot read from, but can write to and close())
-func StateMonitor(updateInterval time.Duration) chan<- State {
-	updates := make(chan State)
-	urlStatus := make(map[string]string)
-	ticker := time.NewTicker(updateInterval)
+// Updates is a channel only to be used for sending data (it cannot be read from).
+// Every update is passed on, after it has been recorded, to each of the listeners.
+// restored seeds the map with states saved by a previous run, so the first poll
+// after a restart is not mistaken for a change.
+/*
+With more than one shard, the map is split between that many goroutines by a hash of the URL,
+and a router in front hands each update to the shard owning its URL, so that a hundred thousand
+targets are not all recorded and passed on by one goroutine. Each URL's updates still go through
+one goroutine, in order; the listeners just hear from several, and the state logged merges them
+all. Callers see no difference: one channel to send on, the same listeners.
+*/
+func StateMonitor(updateInterval time.Duration, shards int, restored []State, listeners ...chan<- State) *Monitor {
+	shards = max(shards, 1)
+	ins := make([]chan State, shards)
+	batchIns := make([]chan []State, shards)
+	snapshots := make([]chan chan map[string]Result, shards)
+	shardsDone := make([]<-chan struct{}, shards)
+	shardQuit := make(chan struct{})
+	seeds := make([][]State, shards)
+	for _, s := range restored {
+		i := stateShard(s.url, shards)
+		seeds[i] = append(seeds[i], s)
+	}
+	for i := range ins {
+		name := "StateMonitor"
+		if shards > 1 {
+			name = fmt.Sprintf("StateMonitor shard %d", i)
+		}
+		ins[i], batchIns[i], snapshots[i] = make(chan State), make(chan []State), make(chan chan map[string]Result)
+		shardsDone[i] = monitorShard(name, ins[i], batchIns[i], snapshots[i], shardQuit, seeds[i], listeners)
+	}
+	m := &Monitor{Updates: ins[0], Batches: batchIns[0], stop: make(chan struct{}), done: make(chan struct{})}
+
+	ticker := chanutil.NewAlignedTicker(updateInterval)
+	logQuit, logDone := make(chan struct{}), make(chan struct{})
 	go func() {
+		defer close(logDone)
 		for {
 			select {
 			case <-ticker.C:
-				logState(urlStatus)
+			case <-logQuit:
+				return
+			}
+			all := make(map[string]Result)
+			for _, snap := range snapshots {
+				reply := make(chan map[string]Result)
+				snap <- reply
+				for url, res := range <-reply {
+					all[url] = res
+				}
+			}
+			logState(all)
+		}
+	}()
+
+	routerQuit, routerDone := make(chan struct{}), make(chan struct{})
+	if shards == 1 {
+		close(routerDone)
+	} else {
+		updates := make(chan State)
+		batches := make(chan []State)
+		m.Updates, m.Batches = updates, batches
+		route := func(b []State) {
+			// Split by shard, keeping the order of each URL's results.
+			split := make([][]State, shards)
+			for _, s := range b {
+				i := stateShard(s.url, shards)
+				split[i] = append(split[i], s)
+			}
+			for i, sb := range split {
+				if len(sb) > 0 {
+					batchIns[i] <- sb
+				}
+			}
+		}
+		go func() {
+			defer close(routerDone)
+			for {
+				select {
+				case s := <-updates:
+					ins[stateShard(s.url, shards)] <- s
+				case b := <-batches:
+					route(b)
+				case <-routerQuit:
+					for {
+						select {
+						case s := <-updates:
+							ins[stateShard(s.url, shards)] <- s
+						case b := <-batches:
+							route(b)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	// Stopping goes front to back: the log, which asks the shards for their
+	// maps, then the router, which feeds them, then the shards.
+	go func() {
+		<-m.stop
+		close(logQuit)
+		<-logDone
+		ticker.Stop()
+		close(routerQuit)
+		<-routerDone
+		close(shardQuit)
+		for _, done := range shardsDone {
+			<-done
+		}
+		close(m.done)
+	}()
+	return m
+}
+
+/*
+Monitor is a running StateMonitor. Send poll results on Updates, or batches of them on Batches.
+Stop shuts it down for good: it stops the ticker, records and passes on the updates whose
+senders are already waiting, and ends every goroutine behind it. The listeners belong to the
+caller and are left open, but once Stop has returned nothing more is sent on them, so the caller
+may close them. Updates sent after Stop are never received, so stop the senders first.
+*/
+type Monitor struct {
+	Updates chan<- State
+	Batches chan<- []State
+	stop    chan struct{}
+	done    chan struct{} // closed once every goroutine has exited
+}
+
+// Stop stops the Monitor and waits for it to finish. It may be called more
+// than once.
+func (m *Monitor) Stop() {
+	select {
+	case m.stop <- struct{}{}:
+		<-m.done
+	case <-m.done:
+	}
+}
+
+// stateShard returns which of n StateMonitor shards owns url.
+func stateShard(url string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(url))
+	return int(h.Sum32() % uint32(n))
+}
+
+// monitorShard starts one StateMonitor goroutine, owning the states of the
+// URLs sent on updates, one at a time or in batches, and sending a copy of
+// them to whoever asks on snapshots; the ticks of the StateMonitor's log wake
+// it regularly. Once quit is closed it records the updates already waiting,
+// then exits and closes the channel it returns.
+func monitorShard(name string, updates <-chan State, batches <-chan []State, snapshots <-chan chan map[string]Result, quit <-chan struct{}, restored []State, listeners []chan<- State) <-chan struct{} {
+	urlStatus := make(map[string]Result)
+	changed := make(map[string]time.Time)
+	for _, s := range restored {
+		urlStatus[s.url] = s.result
+		changed[s.url] = s.since
+	}
+	record := func(s State) {
+		prev, seen := urlStatus[s.url]
+		s.prev, s.since = prev, changed[s.url]
+		if !seen || prev.Up != s.result.Up {
+			s.since = time.Now()
+			changed[s.url] = s.since
+		}
+		urlStatus[s.url] = s.result
+		for _, l := range listeners {
+			l <- s
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			watchdog.Beat(name)
+			select {
+			case reply := <-snapshots:
+				c := make(map[string]Result, len(urlStatus))
+				for url, res := range urlStatus {
+					c[url] = res
+				}
+				reply <- c
 			case s := <-updates: //
-				urlStatus[s.url] = s.status
+				record(s)
+			case b := <-batches:
+				for _, s := range b {
+					record(s)
+				}
+			case <-quit:
+				for {
+					select {
+					case s := <-updates:
+						record(s)
+					case b := <-batches:
+						for _, s := range b {
+							record(s)
+						}
+					default:
+						watchdog.Retire(name)
+						return
+					}
+				}
+			}
+		}
+	}()
+	return done
+}
+
+/*
+statusBatcher gathers the Pollers' results into batches for the StateMonitor, so that it wakes
+once a batch rather than once a result. The batch being filled travels on a channel of one: a
+Poller takes it, appends its State and puts it back, which wakes nobody, and only the Poller that
+fills it, or the flush every statusBatchWait, sends it on, still holding it so that batches
+arrive in the order they were filled. Within a batch, results are in the order they came.
+States sent on in, such as PAUSED reports, join the batches too, so they cannot overtake a
+result still waiting in one.
+*/
+type statusBatcher struct {
+	in      chan<- State
+	open    chan []State
+	batches chan<- []State
+	size    int
+}
+
+// newStatusBatcher starts a statusBatcher sending on batches, in batches of
+// up to size.
+func newStatusBatcher(batches chan<- []State, size int) *statusBatcher {
+	in := make(chan State)
+	b := &statusBatcher{in: in, open: make(chan []State, 1), batches: batches, size: size}
+	b.open <- nil
+	go func() {
+		for s := range in {
+			b.add(s)
+		}
+	}()
+	go func() {
+		for range time.Tick(statusBatchWait) {
+			sb := <-b.open
+			if len(sb) > 0 {
+				b.batches <- sb
 			}
+			b.open <- nil
 		}
 	}()
-	return updates
+	return b
+}
+
+// add adds s to the open batch, sending it if that fills it.
+func (b *statusBatcher) add(s State) {
+	sb := <-b.open
+	if sb == nil {
+		sb = make([]State, 0, b.size)
+	}
+	sb = append(sb, s)
+	if len(sb) >= b.size {
+		b.batches <- sb
+		sb = nil
+	}
+	b.open <- sb
 }
 
-// logState prints a state map.
-func logState(s map[string]string) {
-	log.Println("Current state:")
+// logState prints a state map, with when each result was observed so that
+// stale entries stand out.
+func logState(s map[string]Result) {
+	logger.Println("Current state:")
+	now := time.Now()
 	for k, v := range s {
-		log.Printf(" %s %s", k, v)
+		if v.Timestamp.IsZero() {
+			logger.Printf(" %s %s", k, v)
+			continue
+		}
+		logger.Printf(" %s %s (observed %s, %s ago)", k, v, v.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"), now.Sub(v.Timestamp).Round(time.Millisecond))
 	}
 }
 
 // Resource represents an HTTP URL to be polled by this program.
 type Resource struct {
 	url      string
+	target   Target
 	errCount int
+	response *capturedResponse // set by Poll when the target answers with an error
+	chain    []certInfo        // set by Poll when the target answers over TLS
+	polls    int               // how many times PollState has polled it
+	due      time.Time         // when its next poll is due; zero once it is back
 }
 
 // Poll executes an HTTP HEAD request for url
-// and returns the HTTP status string or an error string.
-func (r *Resource) Poll() string {
-	resp, err := http.Head(r.url)
+// and returns its Result: the HTTP status or the error.
+// With -har-dir, the exchanges of a failed poll are also written out as a HAR file.
+// URLs of the schemes in checkers are checked by their checker instead, and
+// URLs with the prefixes in prefixCheckers by theirs.
+// The certificate chain of a target answering over TLS is kept in r.chain and,
+// with a revocation label, checked for revocation. With a security-headers label
+// the status of a target that is up also grades its security headers; with a
+// max-age label, a target serving stale content fails. Requests are sent from
+// the target's source label, or -source, and through its proxy.
+func (r *Resource) Poll() Result {
+	r.response, r.chain = nil, nil
+	target := r.checkURL()
+	scheme, _, _ := strings.Cut(target, ":")
+	if check, ok := checkers[scheme]; ok {
+		u, err := parseCheckURL(target)
+		if err == nil {
+			err = checkPassword(u)
+		}
+		if err != nil {
+			return r.checked(checkFail("%v", err))
+		}
+		return r.checked(check(u))
+	}
+	for prefix, check := range prefixCheckers {
+		if rest, ok := strings.CutPrefix(target, prefix); ok {
+			return r.checked(check(rest, r.target.labels))
+		}
+	}
+	transport, err := targetRoute(r.target.labels).transport()
+	if err != nil {
+		return r.checked(checkFail("%v", err))
+	}
+	client := &http.Client{Transport: transport}
+	var rec *harRecorder
+	if *harDir != "" {
+		rec = newHARRecorder(transport)
+		client = &http.Client{Transport: rec}
+	}
+	resp, err := client.Head(target)
 	if err != nil {
-		log.Println("Error", r.url, err)
+		logger.Println("Error", r.url, err)
 		r.errCount++
-		return err.Error()
+		if rec != nil {
+			recordHAR(rec, r.url)
+		}
+		return failedResult(err)
 	}
 	r.errCount = 0
-	return resp.Status
+	// fail is the Result of a target that answered but fails all the same.
+	fail := func(format string, args ...interface{}) Result {
+		res := statusResult(checkFail(format, args...))
+		res.Code = resp.StatusCode
+		return res
+	}
+	if resp.TLS != nil {
+		r.chain = certChain(resp.TLS.PeerCertificates)
+		if modes := r.target.labels[labelRevocation]; modes != "" {
+			if err := checkRevocation(resp.TLS, modes); err != nil {
+				return fail("%v", err)
+			}
+		}
+	}
+	if !statusUp(resp.Status) {
+		if *captureBytes > 0 {
+			r.response = captureResponse(client, target, *captureBytes)
+		}
+		if rec != nil {
+			recordHAR(rec, r.url)
+		}
+	} else if why := staleness(client, resp, r.target.labels); why != "" {
+		return fail("%s", why)
+	} else if audit, _ := strconv.ParseBool(r.target.labels[labelSecurityHeaders]); audit {
+		grade, missing := securityHeaders(resp)
+		if len(missing) == 0 {
+			return httpResult(resp, "; security headers "+grade)
+		}
+		return httpResult(resp, "; security headers "+grade+": "+strings.Join(missing, ", "))
+	}
+	return httpResult(resp, "")
+}
+
+// checked returns the Result of a check that ended in status, counting it
+// among r's consecutive errors unless it passed, so that failing checks back
+// off as unreachable HTTP targets do.
+func (r *Resource) checked(status string) Result {
+	res := statusResult(status)
+	if res.Up {
+		r.errCount = 0
+	} else {
+		r.errCount++
+	}
+	return res
+}
+
+// checkURL is the URL Poll polls: r's own, unless that has its password
+// masked.
+func (r *Resource) checkURL() string {
+	if r.target.check != "" {
+		return r.target.check
+	}
+	return r.url
+}
+
+// statusPaused is the status of a target whose polling has been paused.
+const statusPaused = "PAUSED"
+
+// statusUp reports whether a status string, as a Result prints it, means the URL is
+// reachable, i.e. it is an HTTP status below 400 or a passing check.
+func statusUp(status string) bool {
+	if status == statusOK || strings.HasPrefix(status, statusOK+" ") {
+		return true
+	}
+	code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+	return err == nil && code < 400
 }
 
 /*
-Sleep calls time.Sleep to pause before sending the Resource to done.
- The pause will either be of a fixed length (pollInterval) plus an additional delay proportional to the number of sequential errors (r.errCount).
+Sleep calls time.Sleep to pause for d before sending the Resource to done.
+
+	The Scheduler works out d from its tuning: a fixed length (pollInterval, unless tuned) plus an additional delay proportional to the number of sequential errors (r.errCount).
 
 This is an example of a typical Go idiom:
 a function intended to run inside a goroutine takes a channel,
 upon which it sends its return value (or other indication of completed state).
 
 */
-func (r *Resource) Sleep(done chan<- *Resource) {
-	time.Sleep(pollInterval + errTimeout*time.Duration(r.errCount))
+func (r *Resource) Sleep(done chan<- *Resource, d time.Duration) {
+	r.due = time.Now().Add(d)
+	time.Sleep(d)
 	done <- r
 }
 
@@ -109,52 +572,399 @@ It sends a State value to the status channel, to inform the StateMonitor of the
 Finally, it sends the Resource pointer to the out channel.
 This can be interpreted as the Poller saying "I'm done with this Resource" and returning ownership of it to the main goroutine.
 Several goroutines run Pollers, processing Resources in parallel.
+They are the workers of a chanutil.Pool, which does the receiving and sending: Poller returns the
+work each one does with a Resource, whose result is the Resource itself on its way to the out
+channel. With a batcher, the Pollers add their States to its batches instead.
 */
-
-func Poller(in <-chan *Resource, out chan<- *Resource, status chan<- State) {
-	for r := range in {
-		s := r.Poll()
-		status <- State{r.url, s}
-		out <- r
+func Poller(status chan<- State, batcher *statusBatcher) func(context.Context, *Resource) (*Resource, error) {
+	return func(_ context.Context, r *Resource) (*Resource, error) {
+		schedStats.Started(r)
+		s := r.PollState()
+		schedStats.Finished()
+		if batcher != nil {
+			batcher.add(s)
+		} else {
+			status <- s
+		}
+		return r, nil
 	}
 }
 
+// PollState polls r and returns the result as a State, timed.
+func (r *Resource) PollState() State {
+	r.polls++
+	start := time.Now()
+	res := r.Poll()
+	res.Latency, res.Timestamp, res.Attempt = time.Since(start), start, r.polls
+	return State{url: r.url, result: res, target: r.target, errCount: r.errCount, response: r.response, chain: r.chain}
+}
+
 func main() {
-	// Create our input and output channels.
-	pending, complete := make(chan *Resource), make(chan *Resource)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "healthcheck":
+			os.Exit(healthcheck(os.Args[2:]))
+		case "wal":
+			os.Exit(walCommand(os.Args[2:]))
+		case "pause", "resume", "poll":
+			os.Exit(controlCommand(os.Args[1], os.Args[2:]))
+		case "report":
+			os.Exit(reportCommand(os.Args[2:]))
+		case "sla":
+			os.Exit(slaCommand(os.Args[2:]))
+		case "silence", "unsilence":
+			os.Exit(silenceCommand(os.Args[1], os.Args[2:]))
+		case "crawl":
+			os.Exit(crawlCommand(os.Args[2:]))
+		}
+	}
+	flag.Parse()
+	switch *logFormat {
+	case "text":
+	case "json":
+		logger = SlogLogger(slog.NewJSONHandler(os.Stderr, nil))
+	default:
+		log.Fatalf("-log-format: want text or json, not %q", *logFormat)
+	}
+	if loc, err := time.LoadLocation(*displayTZ); err != nil {
+		log.Fatal("-display-tz: ", err)
+	} else {
+		displayLocation = loc
+	}
+	if *watchdogTimeout > 0 {
+		if *watchdogTimeout <= statusInterval {
+			log.Fatalf("-watchdog: must exceed %s, how often an idle stage wakes up", statusInterval)
+		}
+		watchdog = NewWatchdog(*watchdogTimeout)
+	}
+
+	// Create our input channel; the output channel is the Pollers' pool's.
+	pending := make(chan *Resource)
+
+	// With -queue-size, due Resources wait for a Poller in a bounded queue that
+	// reports how far behind the Pollers are; rejected ones skip a poll.
+	var polled, rejected <-chan *Resource = pending, nil
+	var queue *chanutil.Queue[*Resource]
+	if *queueSize > 0 {
+		full := map[string]chanutil.Policy{"block": chanutil.Block, "reject": chanutil.Drop}
+		p, ok := full[*queueFull]
+		if !ok {
+			log.Fatalf("-queue-full: want block or reject, not %q", *queueFull)
+		}
+		queue, rejected = queuePending(pending, *queueSize, p)
+		polled = queue.Out()
+	}
+
+	// Pick up where the previous run left off.
+	var restored []State
+	if *stateFile != "" {
+		var err error
+		if restored, err = loadSnapshot(*stateFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Discovery sources send their targets here; the channel is drained by the
+	// Scheduler, started below once the StateMonitor exists.
+	targets := make(chan TargetUpdate)
+
+	// Launch the discovery sources, collecting any that want to hear about
+	// state changes.
+	var listeners []listener
+	discovering := *operatorMode || *kubeDiscovery || *consulAddr != "" || *targetsDir != ""
+	if *operatorMode || *kubeDiscovery {
+		kube, err := newKubeClient(*kubeAPI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *operatorMode {
+			listeners = append(listeners, listener{"operator", Operator(kube, *kubeNamespace, targets)})
+		}
+		if *kubeDiscovery {
+			KubeDiscovery(kube, *kubeNamespace, targets)
+		}
+	}
+	if *consulAddr != "" {
+		ConsulDiscovery(*consulAddr, splitList(*consulTags), *consulInterval, targets)
+	}
+	if *targetsDir != "" {
+		if err := FileDiscovery(*targetsDir, targets); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The goroutines keeping something per target hear from WatchTargets,
+	// started with the Scheduler, which targets it still has.
+	var watchers []chan<- map[string]bool
+	watch := func() <-chan map[string]bool {
+		c := make(chan map[string]bool)
+		watchers = append(watchers, c)
+		return c
+	}
+
+	// Launch the sinks, which only listen.
+	policy, err := newLabelPolicy(splitList(*metricLabels), splitList(*metricDeny), *metricURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var eventListeners, notifiers []chan<- Event
+	mux := http.NewServeMux()
+	var promSink *Prometheus // mounted once the channels it reports on exist
+	if *listenAddr != "" {
+		prom, p := PrometheusSink(policy)
+		listeners = append(listeners, listener{"prometheus", prom})
+		promSink = p
+	}
+	var recent *Recent
+	if *listenAddr != "" && *recentResults > 0 {
+		var states chan<- State
+		states, recent = RecentStore(*recentResults, *recentMemory)
+		listeners = append(listeners, listener{"recent", states})
+		mux.Handle("/recent", recent)
+	}
+	var history *History
+	if *historyDir != "" {
+		var states chan<- State
+		var err error
+		states, history, err = HistoryStore(*historyDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, listener{"history", states})
+		CompactHistory(history, *rawRetention, *rollupRetention)
+	}
+	if *route53NS != "" || *cloudWatchNS != "" {
+		aws, err := newAWSClient(*awsRegion)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *route53NS != "" {
+			listeners = append(listeners, listener{"route53", Route53Sync(aws, *route53NS, pollInterval, watch())})
+		}
+		if *cloudWatchNS != "" {
+			listeners = append(listeners, listener{"cloudwatch", CloudWatchSink(aws, *cloudWatchNS, policy, cloudWatchFlush)})
+		}
+	}
+	if *datadog {
+		dd, err := newDatadogClient(*datadogSite)
+		if err != nil {
+			log.Fatal(err)
+		}
+		metrics, events := DatadogSink(dd, policy, datadogFlush)
+		listeners = append(listeners, listener{"datadog", metrics})
+		notifiers = append(notifiers, events)
+	}
+
+	if *walDir != "" {
+		wal, err := TransitionLog(*walDir, *walMaxSize, *walMaxFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, listener{"wal", wal})
+	}
+	if *stateFile != "" {
+		listeners = append(listeners, listener{"snapshot", SnapshotWriter(*stateFile, snapshotInterval, restored, watch())})
+	}
+	incidentEvents, incidents, err := IncidentTracker(*incidentFile, *incidentResolve, watch())
+	if err != nil {
+		log.Fatal(err)
+	}
+	eventListeners = append(eventListeners, incidentEvents)
+	if *reportSchedule != "" {
+		if history == nil {
+			log.Fatal("-report needs -history-dir")
+		}
+		sc, err := parseSchedule(*reportSchedule)
+		if err != nil {
+			log.Fatal("-report: ", err)
+		}
+		destinations, err := parseReportDestinations(splitList(*reportTo))
+		if err != nil {
+			log.Fatal("-report-to: ", err)
+		}
+		renderer, err := newReportRenderer(*reportFormat, *reportTemplate)
+		if err != nil {
+			log.Fatal("-report-format: ", err)
+		}
+		Reporter(sc, history, incidents, renderer, destinations)
+	}
+	if *escalationFile != "" {
+		escalations, err := Escalator(*escalationFile, watch())
+		if err != nil {
+			log.Fatal(err)
+		}
+		notifiers = append(notifiers, escalations)
+	}
+	notify, silences, err := Silencer(*silenceFile, notifiers...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	eventListeners = append(eventListeners, notify)
+	alertListeners := []chan<- Event{Correlator(*correlateWindow, *correlateMin, splitList(*correlateLabels), eventListeners...)}
+	if *hookDir != "" || *onFailure != "" || *onSuccess != "" {
+		alertListeners = append(alertListeners, Hooks(*hookDir, *onFailure, *onSuccess, *hookTimeout))
+	}
+	listeners = append(listeners, listener{"alerter", Alerter(*degradeFactor, *degradeWindow, *traceTimeout, watch(), alertListeners...)})
+
+	// Launch the StateMonitor, handing each listener its updates through a
+	// ring buffer of its own with -listener-buffer, so that a slow one cannot
+	// hold up the others, and through them the Pollers.
+	var drops []dropCounter
+	sinks := make([]chan<- State, len(listeners))
+	for i, l := range listeners {
+		sinks[i] = l.states
+		if *listenerBuffer > 0 {
+			var dropped func() uint64
+			sinks[i], dropped = bufferStatus(l.states, *listenerBuffer, "the "+l.name+" listener")
+			drops = append(drops, dropCounter{"listener:" + l.name, dropped})
+		}
+	}
+	monitor := StateMonitor(statusInterval, *monitorShards, restored, sinks...)
+	status := monitor.Updates
+
+	// Launch some Poller goroutines, reporting in batches with -status-batch,
+	// or else through a ring buffer with -status-buffer so a stalled
+	// StateMonitor cannot hold them up.
+	pollStatus := status
+	var batcher *statusBatcher
+	if *statusBatch > 1 {
+		if *statusBuffer > 0 {
+			log.Fatal("-status-batch and -status-buffer do not go together")
+		}
+		batcher = newStatusBatcher(monitor.Batches, *statusBatch)
+		status = batcher.in
+	}
+	if *statusBuffer > 0 {
+		var dropped func() uint64
+		pollStatus, dropped = bufferStatus(status, *statusBuffer, "the StateMonitor")
+		drops = append(drops, dropCounter{"status", dropped})
+	}
+	if queue != nil && *queueFull == "reject" {
+		drops = append(drops, dropCounter{"pending", func() uint64 { return queue.Stats().Rejected }})
+	}
+	if *listenAddr != "" {
+		schedStats = NewSchedulerStats()
+	}
+	pool := chanutil.NewPool(context.Background(), polled, *pollers, Poller(pollStatus, batcher))
+	complete := pool.Out()
+	if rejected != nil {
+		complete = chanutil.Merge(context.Background(), complete, rejected)
+	}
 
-	// Launch the StateMonitor.
-	status := StateMonitor(statusInterval)
+	// Launch the Scheduler, which feeds pending and drains complete, behind
+	// the expanders for sitemap+ and srv+ targets.
+	expanded := SRVExpander(SitemapExpander(targets, *sitemapInterval, *sitemapMax, *ignoreRobots), *srvInterval)
+	tune := tuning{interval: pollInterval, backoff: errTimeout, maxBackoff: *maxBackoff, pollers: *pollers}
+	controls := Scheduler(expanded, pending, complete, status, restored, tune, pool.Resize)
+	WatchTargets(controls, statusInterval, watchers...)
 
-	// Launch some Poller goroutines.
-	for i := 0; i < numPollers; i++ {
-		go Poller(pending, complete, status)
+	audit, err := OpenAuditLog(*auditFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokens, err := loadTokens(*tokenFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *debugEndpoints && tokens == nil {
+		log.Fatal("-debug needs -admin-tokens")
+	}
+	quiesceOnSignal(controls, audit)
+	if *listenAddr != "" {
+		mux.Handle("/metrics", withSchedulerMetrics(withRecentMetrics(withChannelMetrics(promSink.Handler(controls), queue, drops), recent), schedStats))
+		mux.Handle("/targets/", adminOnly(tokens, targetsAPI(controls, status, audit)))
+		mux.Handle("/targets", http.RedirectHandler("/targets/", http.StatusMovedPermanently))
+		mux.Handle("/quiesce", adminOnly(tokens, quiesceAPI(controls, audit)))
+		mux.Handle("/tuning", adminOnly(tokens, tuningAPI(controls, audit)))
+		mux.Handle("/audit", adminOnly(tokens, audit))
+		if *debugEndpoints {
+			mux.Handle("/debug/", adminOnly(tokens, debugHandler(queue, drops, schedStats)))
+		}
+		mux.Handle("/incidents/", adminOnly(tokens, incidentsAPI(incidents, audit)))
+		mux.Handle("/incidents", http.RedirectHandler("/incidents/", http.StatusMovedPermanently))
+		mux.Handle("/silences/", adminOnly(tokens, silencesAPI(silences, audit)))
+		mux.Handle("/silences", http.RedirectHandler("/silences/", http.StatusMovedPermanently))
+		if history != nil {
+			mux.Handle("/history", adminOnly(tokens, history))
+			mux.Handle("/history/rollups", adminOnly(tokens, history.rollupsHandler()))
+			mux.Handle("/report", adminOnly(tokens, reportAPI(history, incidents)))
+			mux.Handle("/sla", adminOnly(tokens, slaAPI(history, controls, *slaLabel, *slaDefault)))
+		}
+		go func() { log.Fatal(http.ListenAndServe(*listenAddr, mux)) }()
 	}
+
 	/*
-	   To add the initial work to the system, main starts a new goroutine that allocates and sends one Resource per URL to pending.
+	   To add the initial work to the system, main hands the built-in URLs to the Scheduler.
+	   The Scheduler allocates one Resource per URL and sends each to pending from a new goroutine.
 	   The new goroutine is necessary because unbuffered channel sends and receives are synchronous.
 	   That means these channel sends will block until the Pollers are ready to read from pending.
-	   Were these sends performed in the main goroutine with fewer Pollers than channel sends,
-	   the program would reach a deadlock situation, because main would not yet be receiving from complete.
+	   Were these sends performed by the Scheduler itself with fewer Pollers than channel sends,
+	   the program would reach a deadlock situation, because nothing would be receiving from complete.
 	   Exercise for the reader: modify this part of the program to read a list of URLs from a file.
-	    (You may want to move this goroutine into its own named function.)
 	*/
+	if !discovering {
+		targets <- TargetUpdate{source: "static", targets: staticTargets(urls)}
+	}
+
+	// Everything from here on happens in the goroutines started above.
+	select {}
+}
+
+// listener is one of the StateMonitor's listeners, named for the logs and
+// /metrics.
+type listener struct {
+	name   string
+	states chan<- State
+}
+
+// bufferStatus returns a channel that queues up to size States in a
+// chanutil.Ring and forwards them to status, logging every statusInterval
+// how many had to be dropped to make room, if any, and a function returning
+// how many have been. reader names who reads status, for the log.
+func bufferStatus(status chan<- State, size int, reader string) (chan<- State, func() uint64) {
+	ring := chanutil.NewRing[State](size)
 	go func() {
-		for _, url := range urls {
-			pending <- &Resource{url: url}
+		ticker := time.NewTicker(statusInterval)
+		var reported uint64
+		out := ring.Out()
+		for {
+			select {
+			case s := <-out:
+				status <- s
+			case <-ticker.C:
+				if d := ring.Dropped(); d > reported {
+					logger.Printf("Dropped %d status updates (%d in all): %s is falling behind", d-reported, d, reader)
+					reported = d
+				}
+			}
 		}
 	}()
+	return ring.In(), ring.Dropped
+}
 
-	/*
-		When a Poller is done with a Resource, it sends it on the complete channel.
-		This loop receives those Resource pointers from complete. For each received Resource,
-		it starts a new goroutine calling the Resource's Sleep method.
-		Using a new goroutine for each ensures that the sleeps can happen in parallel.
-		Note that any single Resource pointer may only be sent on either pending or complete at any one time.
-		This ensures that a Resource is either being handled by a Poller goroutine or sleeping, but never both simultaneously.
-		In this way, we share our Resource data by communicating.
-	*/
-	for r := range complete {
-		go r.Sleep(pending)
-	}
+// queuePending moves Resources from pending into a chanutil.Queue of the
+// given size for the Pollers to take from. Those the queue turns away are
+// sent back on the returned channel, to be treated as polled; how many were
+// is logged every statusInterval.
+func queuePending(pending <-chan *Resource, size int, policy chanutil.Policy) (*chanutil.Queue[*Resource], <-chan *Resource) {
+	queue := chanutil.NewQueue[*Resource](size, policy)
+	rejected := make(chan *Resource)
+	go func() {
+		for r := range pending {
+			if err := queue.Put(context.Background(), r); err != nil {
+				rejected <- r
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(statusInterval)
+		var reported uint64
+		for range ticker.C {
+			if n := queue.Stats().Rejected; n > reported {
+				logger.Printf("Skipped %d polls (%d in all): the Pollers are falling behind", n-reported, n)
+				reported = n
+			}
+		}
+	}()
+	return queue, rejected
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+The transition log is a directory of append-only segments named wal-NNNNNN.log.
+Every line is one transition, a CRC-32C checksum of the JSON that follows it, a space and the JSON:
+
+	1a2b3c4d {"seq":42,"time":"2024-05-01T10:00:00Z","url":"http://a/","from":"200 OK","to":"503 Service Unavailable","up":false}
+
+Sequence numbers increase by one across segments and restarts, so a gap shows lost entries and a
+bad checksum shows a damaged one.
+*/
+const (
+	walPrefix = "wal-"
+	walSuffix = ".log"
+)
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walEntry is one state transition.
+type walEntry struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	URL  string    `json:"url"`
+	From string    `json:"from"` // empty for a URL's first poll
+	To   string    `json:"to"`
+	Up   bool      `json:"up"`
+	// The state the transition led to, in its wire form; missing from logs
+	// written before it was kept.
+	State *State `json:"state,omitempty"`
+}
+
+func walSegment(n int) string { return fmt.Sprintf("%s%06d%s", walPrefix, n, walSuffix) }
+
+// walSegments returns the segment numbers in dir, oldest first.
+func walSegments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ns []int
+	for _, e := range entries {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), walPrefix+"%06d"+walSuffix, &n); err == nil {
+			ns = append(ns, n)
+		}
+	}
+	sort.Ints(ns)
+	return ns, nil
+}
+
+// replayWAL calls fn for every entry in dir, oldest first. Lines failing their
+// checksum are reported to bad and skipped.
+func replayWAL(dir string, fn func(walEntry), bad func(segment string, line int, err error)) error {
+	ns, err := walSegments(dir)
+	if err != nil {
+		return err
+	}
+	for _, n := range ns {
+		path := filepath.Join(dir, walSegment(n))
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			e, err := decodeWALLine(sc.Text())
+			if err != nil {
+				bad(path, line, err)
+				continue
+			}
+			fn(e)
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeWALLine(line string) (walEntry, error) {
+	var e walEntry
+	sum, payload, ok := strings.Cut(line, " ")
+	if !ok {
+		return e, errors.New("no checksum")
+	}
+	want, err := hex.DecodeString(sum)
+	if err != nil || len(want) != 4 {
+		return e, errors.New("malformed checksum")
+	}
+	got := crc32.Checksum([]byte(payload), walTable)
+	if uint32(want[0])<<24|uint32(want[1])<<16|uint32(want[2])<<8|uint32(want[3]) != got {
+		return e, errors.New("checksum mismatch")
+	}
+	return e, json.Unmarshal([]byte(payload), &e)
+}
+
+/*
+TransitionLog appends every status change to the transition log in dir.
+It returns the channel on which it wants to hear about state changes; pass it to StateMonitor
+as a listener.
+A segment is closed once it reaches maxSize bytes and only the newest maxFiles segments are
+kept; if the next one cannot be created, the current one grows until it can. Each entry is
+synced to disk before the next update is accepted: transitions are rare, and an audit trail
+that loses its last entries in a crash is not much of one.
+*/
+func TransitionLog(dir string, maxSize int64, maxFiles int) (chan<- State, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ns, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Continue the sequence where the newest segment stopped.
+	var seq uint64
+	segment := 1
+	if len(ns) > 0 {
+		segment = ns[len(ns)-1]
+		err := replayWAL(dir, func(e walEntry) {
+			if e.Seq > seq {
+				seq = e.Seq
+			}
+		}, func(string, int, error) {})
+		if err != nil {
+			return nil, err
+		}
+	}
+	open := func(n int) (*os.File, int64, error) {
+		f, err := os.OpenFile(filepath.Join(dir, walSegment(n)), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, 0, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, fi.Size(), nil
+	}
+	f, size, err := open(segment)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(chan State)
+	go func() {
+		for s := range states {
+			if !transitioned(s.prev, s.result) {
+				continue
+			}
+			// Should the next segment not open, say on a full disk, the
+			// entry goes on the end of this one, and the next entry tries
+			// again.
+			if size >= maxSize {
+				if nf, nsize, err := open(segment + 1); err != nil {
+					logger.Println("Error", "transition log", "rotating:", err)
+				} else {
+					f.Close()
+					f, size = nf, nsize
+					segment++
+					pruneWAL(dir, maxFiles)
+				}
+			}
+			seq++
+			payload, _ := json.Marshal(walEntry{
+				Seq: seq, Time: time.Now().UTC(), URL: s.url, From: s.prev.String(), To: s.result.String(), Up: s.result.Up, State: &s,
+			})
+			line := fmt.Sprintf("%08x %s\n", crc32.Checksum(payload, walTable), payload)
+			n, err := f.WriteString(line)
+			size += int64(n)
+			if err == nil {
+				err = f.Sync()
+			}
+			if err != nil {
+				logger.Println("Error", "transition log", err)
+			}
+		}
+	}()
+	return states, nil
+}
+
+// transitioned reports whether a target whose previous result was prev has
+// changed state with cur: gone up or down, failed differently, answered with
+// another HTTP status or been paused or resumed. Checks put measurements in
+// their statuses, a round trip or a clock offset, which change with every
+// poll and are not transitions.
+func transitioned(prev, cur Result) bool {
+	return cur.Up != prev.Up || cur.Class != prev.Class || cur.Code != prev.Code || cur.Paused() != prev.Paused()
+}
+
+// pruneWAL removes all but the newest keep segments.
+func pruneWAL(dir string, keep int) {
+	ns, err := walSegments(dir)
+	if err != nil || len(ns) <= keep {
+		return
+	}
+	for _, n := range ns[:len(ns)-keep] {
+		os.Remove(filepath.Join(dir, walSegment(n)))
+	}
+}
+
+// walCommand implements the "wal [-state] <dir>" subcommand: it prints every
+// verified entry of a transition log, or with -state the status of every URL
+// as of the last entry, and reports damaged entries and sequence gaps on
+// stderr. It returns the process exit code, 1 if anything was damaged.
+func walCommand(args []string) int {
+	fs := flag.NewFlagSet("wal", flag.ContinueOnError)
+	state := fs.Bool("state", false, "print the state rebuilt from the log instead of the entries")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s wal [-state] <dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	damaged := false
+	var last uint64
+	latest := make(map[string]walEntry)
+	err := replayWAL(fs.Arg(0), func(e walEntry) {
+		if last != 0 && e.Seq != last+1 {
+			fmt.Fprintf(os.Stderr, "gap: entries %d to %d are missing\n", last+1, e.Seq-1)
+			damaged = true
+		}
+		last = e.Seq
+		if *state {
+			latest[e.URL] = e
+			return
+		}
+		fmt.Printf("%d %s %s: %q -> %q\n", e.Seq, e.Time.Format(time.RFC3339), e.URL, e.From, e.To)
+	}, func(segment string, line int, err error) {
+		fmt.Fprintf(os.Stderr, "%s:%d: %v\n", segment, line, err)
+		damaged = true
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *state {
+		urls := make([]string, 0, len(latest))
+		for u := range latest {
+			urls = append(urls, u)
+		}
+		sort.Strings(urls)
+		for _, u := range urls {
+			e := latest[u]
+			fmt.Printf("%s %s since %s\n", u, e.To, e.Time.Format(time.RFC3339))
+		}
+	}
+	if damaged {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+// walLine is a transition log line for payload, as TransitionLog writes it.
+func walLine(payload string) string {
+	return fmt.Sprintf("%08x %s", crc32.Checksum([]byte(payload), walTable), payload)
+}
+
+func TestDecodeWALLine(t *testing.T) {
+	const entry = `{"seq":7,"time":"2026-10-01T12:00:00Z","url":"https://x/","from":"200 OK","to":"503 Service Unavailable","up":false}`
+	const withState = `{"seq":8,"time":"2026-10-01T12:01:00Z","url":"https://x/","from":"503 Service Unavailable","to":"200 OK","up":true,` +
+		`"state":{"version":1,"target":"https://x/","status":"200 OK","up":true,"code":200,"latency_ms":12.5,"err_count":0}}`
+	for _, tc := range []struct {
+		name string
+		line string
+		err  string // empty when the line is good
+	}{
+		{"good", walLine(entry), ""},
+		{"with state", walLine(withState), ""},
+		{"no checksum", `{"seq":7}`, "no checksum"},
+		{"short checksum", "abcd " + entry, "malformed checksum"},
+		{"not hex", "zzzzzzzz " + entry, "malformed checksum"},
+		{"damaged", walLine(entry)[:len(walLine(entry))-2] + "x}", "checksum mismatch"},
+		{"bad JSON", walLine("{"), "unexpected end of JSON input"},
+	} {
+		e, err := decodeWALLine(tc.line)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.err != "" && (err == nil || err.Error() != tc.err):
+			t.Errorf("%s: err = %v, want %s", tc.name, err, tc.err)
+		case tc.err == "" && (e.URL != "https://x/" || e.Seq == 0):
+			t.Errorf("%s: decoded %+v", tc.name, e)
+		}
+	}
+
+	e, _ := decodeWALLine(walLine(withState))
+	if e.State == nil || e.State.result.Code != 200 || !e.State.result.Up || e.State.url != "https://x/" {
+		t.Errorf("state = %+v", e.State)
+	}
+	if e, _ := decodeWALLine(walLine(entry)); e.State != nil || e.From != "200 OK" || e.Up {
+		t.Errorf("entry = %+v", e)
+	}
+}
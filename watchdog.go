@@ -0,0 +1,98 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+// watchdog watches the owner goroutines for stalls, set by main with
+// -watchdog; nil when it is off.
+var watchdog *Watchdog
+
+/*
+Watchdog notices pipeline stages that have stopped making progress. Each stage's owner goroutine
+calls Beat every time round its loop; as every one of them also wakes on a ticker at least every
+statusInterval, a stage that has not beaten for longer than the timeout is stuck, typically on a
+send nobody will ever receive. The first time that happens the Watchdog logs a warning and a dump
+of every goroutine, which shows who is waiting on what, and it logs again once the stage recovers.
+One goroutine owns the heartbeat times.
+*/
+type Watchdog struct {
+	beats chan heartbeat
+}
+
+// heartbeat is a Beat, or with retire set a Retire; both travel on one
+// channel so that a retirement cannot overtake a stage's last beat.
+type heartbeat struct {
+	stage  string
+	retire bool
+}
+
+// NewWatchdog starts a Watchdog reporting stages silent for longer than
+// timeout.
+func NewWatchdog(timeout time.Duration) *Watchdog {
+	w := &Watchdog{beats: make(chan heartbeat, 64)}
+	go func() {
+		last := make(map[string]time.Time)
+		stalled := make(map[string]bool)
+		ticker := time.NewTicker(timeout / 4)
+		for {
+			select {
+			case hb := <-w.beats:
+				stage := hb.stage
+				if hb.retire {
+					delete(last, stage)
+					delete(stalled, stage)
+					continue
+				}
+				last[stage] = time.Now()
+				if stalled[stage] {
+					delete(stalled, stage)
+					logger.Printf("Watchdog: %s is making progress again", stage)
+				}
+			case <-ticker.C:
+				for stage, t := range last {
+					if d := time.Since(t); d > timeout && !stalled[stage] {
+						stalled[stage] = true
+						logger.Printf("Watchdog: %s has made no progress for %s; goroutines:\n%s", stage, d.Round(time.Second), goroutineDump())
+					}
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// Beat records that stage is making progress. It never blocks: should the
+// Watchdog be behind, the beat is skipped, the next one will do. Beat on a
+// nil Watchdog does nothing.
+func (w *Watchdog) Beat(stage string) {
+	if w == nil {
+		return
+	}
+	select {
+	case w.beats <- heartbeat{stage: stage}:
+	default:
+	}
+}
+
+// Retire stops watching stage, which has exited on purpose and will beat no
+// more. Unlike Beat it waits for room. Retire on a nil Watchdog does nothing.
+func (w *Watchdog) Retire(stage string) {
+	if w == nil {
+		return
+	}
+	w.beats <- heartbeat{stage: stage, retire: true}
+}
+
+// goroutineDump returns the stacks of all goroutines.
+func goroutineDump() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
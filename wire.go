@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+/*
+wireVersion is the version of the JSON form of States and Events, which the API, webhooks and the
+transition log all write and which each carries as "version". It goes up only when a field
+changes meaning or goes away; fields may be added within a version, so readers must ignore the
+ones they do not know. A Result, which only ever appears inside the other two, has no version of
+its own.
+*/
+const wireVersion = 1
+
+// resultJSON is the wire form of a Result.
+type resultJSON struct {
+	Status     string     `json:"status"`
+	Up         bool       `json:"up"`
+	Code       int        `json:"code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ErrorClass errorClass `json:"error_class,omitempty"`
+	Time       *time.Time `json:"time,omitempty"` // when the poll started
+	LatencyMS  float64    `json:"latency_ms"`
+	Attempt    int        `json:"attempt,omitempty"`
+}
+
+// stateJSON is the wire form of a State: the target and its Result, flat,
+// and what the StateMonitor knows of its history.
+type stateJSON struct {
+	Version int    `json:"version"`
+	Target  string `json:"target"`
+	resultJSON
+	Labels   map[string]string `json:"labels,omitempty"`
+	Previous string            `json:"previous_status,omitempty"`
+	Since    *time.Time        `json:"since,omitempty"` // of the last change between up and down
+	ErrCount int               `json:"err_count"`
+	Response *capturedResponse `json:"response,omitempty"`
+	Chain    []certInfo        `json:"chain,omitempty"`
+}
+
+// eventJSON is the wire form of an Event. Target, status and error class
+// repeat those of state for consumers that look no further.
+type eventJSON struct {
+	Version    int        `json:"version"`
+	Kind       string     `json:"kind"`
+	Title      string     `json:"title"`
+	Text       string     `json:"text"`
+	Target     string     `json:"target"`
+	Status     string     `json:"status"`
+	ErrorClass errorClass `json:"error_class,omitempty"`
+	Group      string     `json:"group,omitempty"`
+	Targets    []string   `json:"targets,omitempty"`
+	Time       time.Time  `json:"time"`
+	State      State      `json:"state"`
+	Related    []State    `json:"related,omitempty"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (r Result) wire() resultJSON {
+	w := resultJSON{
+		Status: r.text, Up: r.Up, Code: r.Code, ErrorClass: r.Class, Time: optionalTime(r.Timestamp),
+		LatencyMS: float64(r.Latency) / float64(time.Millisecond), Attempt: r.Attempt,
+	}
+	if r.Err != nil {
+		w.Error = r.Err.Error()
+	}
+	return w
+}
+
+func (w resultJSON) result() Result {
+	r := Result{Up: w.Up, Code: w.Code, Class: w.ErrorClass, Latency: time.Duration(w.LatencyMS * float64(time.Millisecond)), Attempt: w.Attempt, text: w.Status}
+	if w.Error != "" {
+		r.Err = errors.New(w.Error)
+	}
+	if w.Time != nil {
+		r.Timestamp = *w.Time
+	}
+	return r
+}
+
+func (r Result) MarshalJSON() ([]byte, error) { return json.Marshal(r.wire()) }
+
+func (r *Result) UnmarshalJSON(b []byte) error {
+	var w resultJSON
+	if err := json.Unmarshal(b, &w); err != nil {
+		return err
+	}
+	*r = w.result()
+	return nil
+}
+
+func (s State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(stateJSON{
+		Version: wireVersion, Target: s.url, resultJSON: s.result.wire(), Labels: s.target.labels,
+		Previous: s.prev.String(), Since: optionalTime(s.since), ErrCount: s.errCount,
+		Response: s.response, Chain: s.chain,
+	})
+}
+
+func (s *State) UnmarshalJSON(b []byte) error {
+	var w stateJSON
+	if err := json.Unmarshal(b, &w); err != nil {
+		return err
+	}
+	*s = State{
+		url: w.Target, result: w.resultJSON.result(), target: Target{url: w.Target, labels: w.Labels},
+		errCount: w.ErrCount, response: w.Response, chain: w.Chain,
+	}
+	if w.Previous != "" {
+		s.prev = statusResult(w.Previous)
+	}
+	if w.Since != nil {
+		s.since = *w.Since
+	}
+	return nil
+}
+
+func (e Event) wire() eventJSON {
+	w := eventJSON{
+		Version: wireVersion, Kind: e.kind, Title: e.title(), Text: e.text(), Target: e.state.url,
+		Status: e.state.result.String(), ErrorClass: e.state.result.Class, Group: e.group,
+		Time: e.time.UTC(), State: e.state, Related: e.related,
+	}
+	for _, s := range e.related {
+		w.Targets = append(w.Targets, s.url)
+	}
+	return w
+}
+
+func (e Event) MarshalJSON() ([]byte, error) { return json.Marshal(e.wire()) }